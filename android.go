@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// trashedDir is where --trashed separate puts files Android had deleted.
+const trashedDir = "Trashed"
+
+// androidMarked matches the names Android 11+ gives media in the trash or
+// still being written: .trashed-<expiry>-IMG_1.jpg and .pending-<expiry>-IMG_1.jpg.
+var androidMarked = regexp.MustCompile(`^\.(trashed|pending)-(\d+)-(.+)$`)
+
+// androidState returns "trashed" or "pending" for a file marked by Android,
+// along with its original name and when Android will delete it.
+func androidState(file string) (state, name string, expiry time.Time) {
+	m := androidMarked.FindStringSubmatch(filepath.Base(file))
+	if m == nil {
+		return "", "", time.Time{}
+	}
+	seconds, _ := strconv.ParseInt(m[2], 10, 64)
+	return m[1], m[3], time.Unix(seconds, 0)
+}
+
+// androidName strips the Android trash or pending mark from a file name.
+func androidName(name string) string {
+	if _, original, _ := androidState(name); original != "" {
+		return original
+	}
+	return name
+}
+
+// skipAndroid reports whether a file marked by Android is left out: pending
+// files always, trashed ones unless --trashed restores them.
+func skipAndroid(file string) bool {
+	state, _, expiry := androidState(file)
+	switch {
+	case state == "pending":
+		action(labelSkip, "%s: Android is still writing it", file)
+		return true
+	case state == "trashed" && c.Trashed != "restore" && c.Trashed != "separate":
+		action(labelSkip, "%s: in the Android trash until %s", file, expiry.Format(time.DateOnly))
+		return true
+	}
+	return false
+}
+
+func checkTrashed() error {
+	switch c.Trashed {
+	case "", "skip", "restore", "separate":
+		return nil
+	}
+	return fmt.Errorf("--trashed is skip, restore or separate, not %q", c.Trashed)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// --apple-edits choices for the edits iPhones export next to originals
+const (
+	appleEditsBoth     = "both"
+	appleEditsOriginal = "original"
+	appleEditsEdited   = "edited"
+)
+
+// appleName matches the files of one iPhone shot: IMG_0001.HEIC is the
+// original, IMG_E0001.JPG its edit and IMG_0001.AAE, IMG_E0001.AAE or
+// IMG_O0001.AAE the adjustments Photos made.
+var appleName = regexp.MustCompile(`^IMG_([EO]?)(\d{4})\.([A-Za-z0-9]+)$`)
+
+// appleSuffixes name the variants of a shot in the destination, after the
+// original: 2021-07-14_150405.heic, 2021-07-14_150405_edited.jpg.
+var appleSuffixes = map[string]string{"E": "_edited", "O": "_original"}
+
+func checkAppleEdits() error {
+	switch c.AppleEdits {
+	case "", appleEditsBoth, appleEditsOriginal, appleEditsEdited:
+		return nil
+	}
+	return fmt.Errorf("--apple-edits is both, original or edited, not %q", c.AppleEdits)
+}
+
+// sameKind reports whether two extensions are both images or both videos,
+// as an edited photo may be a JPEG of a HEIC but never a video.
+func sameKind(a, b string) bool {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	return primaryTypes[a] && primaryTypes[b] || videoTypes[a] && videoTypes[b]
+}
+
+// appleVariant finds the file of variant ("" for the original, "E" for the
+// edit) of the shot file belongs to, of the same kind as file, or "".
+func appleVariant(file, variant string) string {
+	m := appleName.FindStringSubmatch(filepath.Base(file))
+	if m == nil {
+		return ""
+	}
+	entries, err := os.ReadDir(filepath.Dir(file))
+	if err != nil {
+		return ""
+	}
+	for _, entry := range entries {
+		other := appleName.FindStringSubmatch(entry.Name())
+		if other != nil && other[1] == variant && other[2] == m[2] && sameKind(other[3], m[3]) && entry.Type().IsRegular() {
+			return filepath.Join(filepath.Dir(file), entry.Name())
+		}
+	}
+	return ""
+}
+
+// appleOriginal returns the original an edit belongs to, or "" when file is
+// not an edit or its original is not there.
+func appleOriginal(file string) string {
+	m := appleName.FindStringSubmatch(filepath.Base(file))
+	if m == nil || m[1] != "E" {
+		return ""
+	}
+	return appleVariant(file, "")
+}
+
+// appleEdit returns the edit of an original, or "".
+func appleEdit(file string) string {
+	m := appleName.FindStringSubmatch(filepath.Base(file))
+	if m == nil || m[1] != "" {
+		return ""
+	}
+	return appleVariant(file, "E")
+}
+
+// appleCompanions returns what travels with an original: its edit, unless
+// only originals are kept, and for a photo its adjustment files.
+func appleCompanions(file string) []string {
+	m := appleName.FindStringSubmatch(filepath.Base(file))
+	if m == nil || m[1] != "" {
+		return nil
+	}
+	companions := make([]string, 0)
+	if c.AppleEdits == "" || c.AppleEdits == appleEditsBoth {
+		if edit := appleEdit(file); edit != "" {
+			companions = append(companions, edit)
+		}
+	}
+	// a Live Photo video shares the number, the adjustments go with the photo
+	if c.AppleEdits != appleEditsEdited && primaryTypes[strings.ToLower(m[3])] {
+		dir := filepath.Dir(file)
+		for _, variant := range []string{"", "E", "O"} {
+			for _, ext := range []string{"AAE", "aae"} {
+				if candidate := filepath.Join(dir, "IMG_"+variant+m[2]+"."+ext); fileExists(candidate) {
+					companions = append(companions, candidate)
+					break
+				}
+			}
+		}
+	}
+	return companions
+}
+
+// appleCompanionDest names an edit or adjustment file after the destination
+// of its original with the suffix of its variant, or returns "" for the
+// other companions.
+func appleCompanionDest(source, companion, dest string) string {
+	src := appleName.FindStringSubmatch(filepath.Base(source))
+	m := appleName.FindStringSubmatch(filepath.Base(companion))
+	if src == nil || m == nil || src[2] != m[2] {
+		return ""
+	}
+	destStem := strings.TrimSuffix(dest, filepath.Ext(dest))
+	return destStem + appleSuffixes[m[1]] + normalizeExt("."+m[3])
+}
+
+// useAppleEdit returns the edit of an original and its destination, to be
+// placed instead of it when only edits are kept, or file and dest as they
+// are. The original still dated the shot, the edit keeps its own extension.
+func useAppleEdit(file, dest string) (string, string) {
+	if c.AppleEdits != appleEditsEdited {
+		return file, dest
+	}
+	edit := appleEdit(file)
+	if edit == "" {
+		return file, dest
+	}
+	return edit, strings.TrimSuffix(dest, filepath.Ext(dest)) + normalizeExt(filepath.Ext(edit))
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var archiveSuffixes = []string{".zip", ".tar", ".tar.gz", ".tgz", ".7z"}
+
+// archivePasswordEnv is read when no password was given on the command line.
+const archivePasswordEnv = "MEDIA_TOOL_ARCHIVE_PASSWORD"
+
+func isArchive(file string) bool {
+	lower := strings.ToLower(file)
+	for _, suffix := range archiveSuffixes {
+		if strings.HasSuffix(lower, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// stagingDir is where archive entries are extracted, inside the destination
+// so placing them is a rename rather than a second copy.
+func stagingDir(archive string) string {
+	return filepath.Join(c.Destination, metaDirName, "staging", filepath.Base(archive))
+}
+
+// streamArchive extracts the media entries of a zip or tar archive one at a
+// time into staging and sends their paths. In a dry run each file is removed
+// again as soon as the next one is requested.
+func streamArchive(archive, staging string) (<-chan string, func() error) {
+	log.Infof("scanning archive: %s", archive)
+
+	files := make(chan string)
+	done := make(chan error, 1)
+
+	go func() {
+		defer close(files)
+		sevenZip, err := needsSevenZip(archive)
+		if err != nil {
+			done <- err
+			return
+		}
+		// 7z cannot hand out single entries cheaply, so it unpacks everything
+		// at once and staging is scanned like a directory
+		if sevenZip {
+			if err = extractSevenZip(archive, staging); err != nil {
+				done <- err
+				return
+			}
+			media, wait := streamMediaFiles(staging)
+			for file := range media {
+				files <- file
+			}
+			done <- wait()
+			return
+		}
+
+		previous := ""
+		send := func(name string, modTime time.Time, r io.Reader) error {
+			if !isMediaFile(name) || skipArchiveEntry(name) {
+				return nil
+			}
+			target, err := extractEntry(staging, name, modTime, r)
+			if err != nil {
+				return err
+			}
+			files <- target
+			if c.Dry && previous != "" {
+				_ = os.Remove(previous)
+			}
+			previous = target
+			return nil
+		}
+
+		if strings.HasSuffix(strings.ToLower(archive), ".zip") {
+			done <- walkZip(archive, send)
+		} else {
+			done <- walkTar(archive, send)
+		}
+	}()
+
+	return files, func() error {
+		return <-done
+	}
+}
+
+func skipArchiveEntry(name string) bool {
+	parts := strings.Split(path.Clean(name), "/")
+	for i, dir := range parts[:len(parts)-1] {
+		if contains(y.SkipDir, dir) || skipSynologyDir(dir) || profileSkipsDir(strings.Join(parts[:i+1], "/")) {
+			return true
+		}
+	}
+	return contains(y.SkipFile, parts[len(parts)-1]) || profileSkipsFile(parts[len(parts)-1])
+}
+
+func extractEntry(staging, name string, modTime time.Time, r io.Reader) (string, error) {
+	target := filepath.Join(staging, filepath.FromSlash(path.Clean("/"+name)))
+	if !strings.HasPrefix(target, filepath.Clean(staging)+string(filepath.Separator)) {
+		return "", fmt.Errorf("archive entry %s escapes the staging folder", name)
+	}
+	if err := createParentDir(filepath.Dir(target)); err != nil {
+		return "", err
+	}
+
+	out, err := os.Create(target)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(out, r)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("error extracting %s: %w", name, err)
+	}
+	// the modification time is the last resort for dating a file
+	if err = os.Chtimes(target, modTime, modTime); err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+func walkZip(archive string, fn func(string, time.Time, io.Reader) error) error {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = fn(f.Name, f.Modified, r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkTar(archive string, fn func(string, time.Time, io.Reader) error) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	lower := strings.ToLower(archive)
+	if strings.HasSuffix(lower, ".gz") || strings.HasSuffix(lower, ".tgz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err = fn(header.Name, header.ModTime, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// needsSevenZip reports whether an archive can only be read by 7z: 7z
+// archives themselves and zips with encrypted entries.
+func needsSevenZip(archive string) (bool, error) {
+	lower := strings.ToLower(archive)
+	if strings.HasSuffix(lower, ".7z") {
+		return true, nil
+	}
+	if !strings.HasSuffix(lower, ".zip") {
+		return false, nil
+	}
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return false, err
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		if f.Flags&0x1 != 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func sevenZipPath() string {
+	if y.SevenZip != "" {
+		return y.SevenZip
+	}
+	for _, name := range []string{"7z", "7zz", "7za"} {
+		if _, err := exec.LookPath(name); err == nil {
+			return name
+		}
+	}
+	return "7z"
+}
+
+func extractSevenZip(archive, staging string) error {
+	path, err := exec.LookPath(sevenZipPath())
+	if err != nil {
+		return fmt.Errorf("7z not found: %w", err)
+	}
+	encrypted, err := sevenZipEncrypted(path, archive)
+	if err != nil {
+		return err
+	}
+	password := ""
+	if encrypted {
+		if password, err = archivePassword(archive); err != nil {
+			return err
+		}
+	}
+
+	log.Infof("extracting %s with 7z", archive)
+	// an empty -p keeps 7z from prompting on its own; a password is answered
+	// to its prompt on stdin, on the command line anyone could read it
+	args := []string{"x", "-y", "-o" + staging}
+	if password == "" {
+		args = append(args, "-p")
+	}
+	cmd := exec.Command(path, append(args, archive)...)
+	if password != "" {
+		cmd.Stdin = strings.NewReader(password + "\n")
+		detachTerminal(cmd)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("error extracting %s: %w: %s", archive, err, strings.TrimSpace(lastLine(out)))
+	}
+	return nil
+}
+
+// sevenZipEncrypted lists the archive without a password; entries marked
+// encrypted, or headers that cannot be read at all, mean one is needed.
+func sevenZipEncrypted(path, archive string) (bool, error) {
+	out, err := exec.Command(path, "l", "-slt", "-p", archive).CombinedOutput()
+	if strings.Contains(string(out), "Encrypted = +") {
+		return true, nil
+	}
+	if err != nil {
+		if strings.Contains(strings.ToLower(string(out)), "password") {
+			return true, nil
+		}
+		return false, fmt.Errorf("error listing %s: %w: %s", archive, err, strings.TrimSpace(lastLine(out)))
+	}
+	return false, nil
+}
+
+func lastLine(out []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	return lines[len(lines)-1]
+}
+
+// archivePassword comes from --archive-password, then the environment, and
+// is asked for as a last resort.
+func archivePassword(archive string) (string, error) {
+	if c.ArchivePassword != "" {
+		return c.ArchivePassword, nil
+	}
+	if password := os.Getenv(archivePasswordEnv); password != "" {
+		return password, nil
+	}
+	fmt.Printf("password for %s: ", filepath.Base(archive))
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("no password for %s: %w", archive, err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// primaryTypes are the images a logical asset is planned by; files in
+// rawTypes or holding a gain map travel with a primary of the same name,
+// as iPhone ProRAW and RAW+JPEG cameras produce.
+var primaryTypes = map[string]bool{
+	"jpg":  true,
+	"jpeg": true,
+	"heic": true,
+}
+
+var rawTypes = map[string]bool{
+	"dng": true,
+	"arw": true,
+	"cr2": true,
+	"cr3": true,
+	"nef": true,
+	"raf": true,
+	"orf": true,
+	"rw2": true,
+}
+
+var gainMapSuffixes = []string{"_gainmap", ".gainmap"}
+
+// subtitleTypes travel with the video of the same name, which may be
+// followed by a language such as Movie.en.srt or Movie.eng.forced.ass.
+var subtitleTypes = map[string]bool{
+	"srt": true,
+	"ass": true,
+	"ssa": true,
+	"sub": true,
+	"idx": true,
+	"vtt": true,
+}
+
+// assetCompanions returns the RAW and gain map files that belong to the
+// primary image file, or the subtitles of a video, and the edits of an
+// iPhone original or the other files of a burst or motion photo.
+func assetCompanions(file string) []string {
+	if videoTypes[getFileExtension(file, false)] {
+		return append(subtitles(file), appleCompanions(file)...)
+	}
+	if !primaryTypes[getFileExtension(file, false)] {
+		return nil
+	}
+	stem := strings.TrimSuffix(file, filepath.Ext(file))
+	companions := make([]string, 0)
+	for ext := range rawTypes {
+		for _, candidate := range []string{stem + "." + ext, stem + "." + strings.ToUpper(ext)} {
+			if fileExists(candidate) {
+				companions = append(companions, candidate)
+				break
+			}
+		}
+	}
+	for _, suffix := range gainMapSuffixes {
+		for ext := range primaryTypes {
+			for _, candidate := range []string{stem + suffix + "." + ext, stem + suffix + "." + strings.ToUpper(ext)} {
+				if fileExists(candidate) {
+					companions = append(companions, candidate)
+				}
+			}
+		}
+	}
+	companions = append(companions, shotCompanions(file)...)
+	return append(companions, appleCompanions(file)...)
+}
+
+// primaryOf returns the primary image a RAW or gain map file belongs to,
+// the original of an iPhone edit or the file standing for a burst or
+// motion photo, or "" when it stands alone.
+func primaryOf(file string) string {
+	if original := appleOriginal(file); original != "" {
+		return original
+	}
+	if primary := shotPrimary(file); primary != "" {
+		return primary
+	}
+	ext := filepath.Ext(file)
+	stem := strings.TrimSuffix(file, ext)
+	if !rawTypes[strings.ToLower(strings.TrimPrefix(ext, "."))] {
+		found := false
+		for _, suffix := range gainMapSuffixes {
+			if strings.HasSuffix(strings.ToLower(stem), suffix) {
+				stem, found = stem[:len(stem)-len(suffix)], true
+				break
+			}
+		}
+		if !found {
+			return ""
+		}
+	}
+	for primary := range primaryTypes {
+		for _, candidate := range []string{stem + "." + primary, stem + "." + strings.ToUpper(primary)} {
+			if candidate != file && fileExists(candidate) {
+				return candidate
+			}
+		}
+	}
+	return ""
+}
+
+// subtitles returns the subtitle files named after a video.
+func subtitles(video string) []string {
+	entries, err := os.ReadDir(filepath.Dir(video))
+	if err != nil {
+		return nil
+	}
+	stem := strings.TrimSuffix(filepath.Base(video), filepath.Ext(video)) + "."
+	found := make([]string, 0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && strings.HasPrefix(name, stem) && subtitleTypes[getFileExtension(name, false)] {
+			found = append(found, filepath.Join(filepath.Dir(video), name))
+		}
+	}
+	return found
+}
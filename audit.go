@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	issueFolder    = "folder"
+	issueName      = "name"
+	issueDuplicate = "duplicate"
+)
+
+var auditCommand = &cli.Command{
+	Name:  "audit",
+	Usage: "check an organized library without changing it",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:        "dir",
+			Aliases:     []string{"d"},
+			Destination: &c.Destination,
+			Usage:       "the library to check",
+			Required:    true,
+		},
+		&cli.StringFlag{
+			Name:        "config",
+			Aliases:     []string{"c"},
+			Destination: &c.ConfigPath,
+			Usage:       "yaml config file path",
+			DefaultText: "config.yaml",
+			Required:    false,
+		},
+		&cli.IntFlag{
+			Name:        "workers",
+			Aliases:     []string{"w"},
+			Destination: &c.Workers,
+			Usage:       "number of concurrent hashing workers",
+			Value:       4,
+		},
+		&cli.StringFlag{
+			Name:        "plan",
+			Destination: &c.Plan,
+			Usage:       "write the fix plan as json lines to this file",
+		},
+	},
+	Action: audit,
+}
+
+// auditIssue is one line of a fix plan: a file that should move to Dest, or
+// a duplicate of the file at Dest.
+type auditIssue struct {
+	Op     string `json:"op"`
+	Reason string `json:"reason"`
+	Source string `json:"source"`
+	Dest   string `json:"dest"`
+}
+
+func audit(_ *cli.Context) error {
+	if err := loadConfigFile(); err != nil {
+		return err
+	}
+	issues, err := auditLibrary(c.Destination, c.Workers)
+	if err != nil {
+		return err
+	}
+
+	counts := make(map[string]int)
+	for _, issue := range issues {
+		counts[issue.Reason]++
+		if issue.Op == "move" {
+			log.Warnf("%s: %s should be %s", issue.Reason, issue.Source, issue.Dest)
+		} else {
+			log.Warnf("%s: %s has the same content as %s", issue.Reason, issue.Source, issue.Dest)
+		}
+	}
+	log.Infof("%d misfiled, %d misnamed, %d duplicates across folders",
+		counts[issueFolder], counts[issueName], counts[issueDuplicate])
+
+	if c.Plan == "" {
+		return nil
+	}
+	return writePlan(c.Plan, issues)
+}
+
+// auditLibrary plans every media file of an organized library again and
+// compares the result with where the file is now, then looks for the same
+// content in different folders.
+func auditLibrary(root string, workers int) ([]auditIssue, error) {
+	issues, media, err := misfiledFiles(root)
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := findDuplicates(media, workers)
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range groups {
+		// copies within one folder are the dedupe command's business
+		dirs := make(map[string]bool)
+		for _, file := range group.Files {
+			dirs[filepath.Dir(file)] = true
+		}
+		if len(dirs) < 2 {
+			continue
+		}
+		for _, file := range group.Files[1:] {
+			issues = append(issues, auditIssue{Op: "dedupe", Reason: issueDuplicate, Source: file, Dest: group.Files[0]})
+		}
+	}
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		return issues[i].Source < issues[j].Source
+	})
+	return issues, nil
+}
+
+// misfiledFiles returns a move for every media file that is not where the
+// current config would put it, along with all media files it looked at.
+func misfiledFiles(root string) ([]auditIssue, []string, error) {
+	// the library is its own source, so folder dates resolve against it
+	c.Source = root
+	files, err := walkDirectory(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	// the catalog knows which files carry their camera offset already
+	if err = loadCatalog(); err != nil {
+		return nil, nil, err
+	}
+
+	media := make([]string, 0, len(files))
+	issues := make([]auditIssue, 0)
+	for _, file := range files {
+		if !isMediaFile(file) {
+			continue
+		}
+		media = append(media, file)
+		if primaryOf(file) != "" {
+			continue
+		}
+
+		newPath, _, err := processImage(file)
+		if err != nil {
+			log.Debugf("skip file %s: %v", file, err)
+			continue
+		}
+		expected := filepath.Join(root, newPath)
+		switch {
+		case filepath.Dir(expected) != filepath.Dir(file):
+			issues = append(issues, auditIssue{Op: "move", Reason: issueFolder, Source: file, Dest: expected})
+		case expected != file:
+			issues = append(issues, auditIssue{Op: "move", Reason: issueName, Source: file, Dest: expected})
+		}
+	}
+	return issues, media, nil
+}
+
+func writePlan(path string, issues []auditIssue) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	for _, issue := range issues {
+		if err = enc.Encode(issue); err != nil {
+			return err
+		}
+	}
+	log.Infof("write fix plan: %s", path)
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// runBudget stops a run cleanly after --max-files files or --max-duration,
+// leaving the rest for the next run, so nightly imports fit their window.
+var runBudget = struct {
+	sync.Mutex
+	start time.Time
+	files int
+	// left counts the files the budget turned away
+	left int
+}{start: time.Now()}
+
+// overBudget reports whether the run has used up its budget, counting file
+// as left for the next run when it has.
+func overBudget(file string) bool {
+	runBudget.Lock()
+	defer runBudget.Unlock()
+	spent := (c.MaxFiles > 0 && runBudget.files >= c.MaxFiles) || !withinDuration()
+	if spent {
+		if runBudget.left == 0 {
+			log.Infof("run budget used up, %s and the files after it are left for the next run", file)
+		}
+		runBudget.left++
+	}
+	return spent
+}
+
+// spendBudget counts a file planned to be placed.
+func spendBudget() {
+	runBudget.Lock()
+	runBudget.files++
+	runBudget.Unlock()
+}
+
+// outOfTime reports whether --max-duration passed while a planned batch is
+// placed, counting the left files of the batch for the next run when it did.
+func outOfTime(left int) bool {
+	if withinDuration() {
+		return false
+	}
+	runBudget.Lock()
+	runBudget.left += left
+	runBudget.Unlock()
+	return true
+}
+
+// withinDuration reports whether --max-duration, if any, has not passed.
+func withinDuration() bool {
+	return c.MaxDuration <= 0 || time.Since(runBudget.start) < c.MaxDuration
+}
+
+// budgetLeftOver returns how many files were left for the next run.
+func budgetLeftOver() int {
+	runBudget.Lock()
+	defer runBudget.Unlock()
+	return runBudget.left
+}
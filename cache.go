@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type cacheEntry struct {
+	Size    int64      `json:"size"`
+	ModTime int64      `json:"mtime"`
+	Meta    *mediaMeta `json:"meta"`
+}
+
+// metaCache remembers decoded EXIF metadata keyed by path, size and mtime so
+// a real run after a dry run does not parse every file again.
+type metaCache struct {
+	sync.Mutex
+	entries map[string]cacheEntry
+}
+
+var exifCache *metaCache
+
+func loadMetaCache(path string) (*metaCache, error) {
+	cache := &metaCache{entries: make(map[string]cacheEntry)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &cache.entries); err != nil {
+		log.Warnf("ignore unreadable cache file %s: %v", path, err)
+		cache.entries = make(map[string]cacheEntry)
+	}
+	return cache, nil
+}
+
+func (m *metaCache) save(path string) error {
+	m.Lock()
+	defer m.Unlock()
+	data, err := json.Marshal(m.entries)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// decodeExifCached is decodeExif backed by the cache, when one is loaded.
+func decodeExifCached(file string) *mediaMeta {
+	if exifCache == nil {
+		return decodeExif(file)
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return decodeExif(file)
+	}
+	key := absPath(file)
+
+	exifCache.Lock()
+	entry, ok := exifCache.entries[key]
+	exifCache.Unlock()
+	if ok && entry.Size == info.Size() && entry.ModTime == info.ModTime().UnixNano() {
+		if entry.Meta == nil {
+			return nil
+		}
+		meta := *entry.Meta
+		return &meta
+	}
+
+	meta := decodeExif(file)
+	entry = cacheEntry{Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+	if meta != nil {
+		cached := *meta
+		entry.Meta = &cached
+	}
+	exifCache.Lock()
+	exifCache.entries[key] = entry
+	exifCache.Unlock()
+	return meta
+}
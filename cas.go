@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	layoutTree = "tree"
+	layoutCAS  = "cas"
+	// layoutPlex names home videos the way Plex and Jellyfin parse them.
+	layoutPlex = "plex"
+	// objectsDirName holds the content-addressed files of a cas library.
+	objectsDirName = "objects"
+)
+
+func checkLayout() error {
+	switch c.Layout {
+	case "", layoutTree, layoutPlex:
+		return nil
+	case layoutCAS:
+		if c.Mode == "export" || c.TwoPhase {
+			return fmt.Errorf("--layout cas cannot be combined with export mode or --two-phase")
+		}
+		// an object is shared by every link to it, it is never rewritten
+		if c.WriteExif || c.WriteGPS {
+			return fmt.Errorf("--layout cas cannot be combined with --write-exif or --write-gps")
+		}
+		return nil
+	}
+	return fmt.Errorf("--layout is tree, cas or plex, not %q", c.Layout)
+}
+
+// objectPath is where content with this hash is stored below root:
+// objects/ab/cd/abcd...ef.jpg.
+func objectPath(root, hash, ext string) string {
+	return filepath.Join(root, objectsDirName, hash[:2], hash[2:4], hash+ext)
+}
+
+// isObjectStore reports whether dir is the object store of a library, which
+// is only ever reached through the links of the tree.
+func isObjectStore(dir string) bool {
+	return filepath.Base(dir) == objectsDirName && fileExists(filepath.Join(filepath.Dir(dir), metaDirName))
+}
+
+// placeObject stores source by its content and links dest to it. Content
+// that is already stored is not written again, so duplicates cost nothing
+// and the tree can be renamed freely. An object is written to a temporary
+// name and only takes its own once its hash is checked, and a stored one is
+// checked before it is reused, so a cut-off copy is never linked to.
+func placeObject(source, dest string) error {
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	hash, err := fullHash(source)
+	if err != nil {
+		return err
+	}
+	object := objectPath(rootOf(dest), hash, normalizeExt(mediaExt(source)))
+	if fileExists(object) && !objectIntact(object, hash, info.Size()) {
+		log.Warnf("object %s is damaged, store it again", object)
+		if err = os.Remove(object); err != nil {
+			return err
+		}
+	}
+	if fileExists(object) {
+		if c.Mode == "move" {
+			if err = removeFile(source); err != nil {
+				return err
+			}
+		}
+	} else if err = storeObject(source, object, hash, info.Size()); err != nil {
+		return err
+	}
+
+	target, err := filepath.Rel(filepath.Dir(dest), object)
+	if err != nil {
+		return err
+	}
+	if c.OverWrite {
+		_ = os.Remove(dest)
+	}
+	return os.Symlink(target, dest)
+}
+
+func storeObject(source, object, hash string, size int64) error {
+	if _, err := createDestinationDir(object); err != nil {
+		return err
+	}
+	tmp := object + partSuffix
+	release := acquireWrite(object)
+	defer release()
+	var err error
+	if c.Mode == "move" {
+		err = moveFile(source, tmp)
+	} else {
+		err = copyFile(source, tmp)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if !objectIntact(tmp, hash, size) {
+		// a source renamed into the store changed since it was hashed, it
+		// goes back where it was
+		if c.Mode == "move" {
+			_ = os.Rename(tmp, source)
+		} else {
+			_ = os.Remove(tmp)
+		}
+		return fmt.Errorf("%s changed while it was stored", source)
+	}
+	return os.Rename(tmp, object)
+}
+
+// objectIntact reports whether object has the size and hash it is stored
+// under.
+func objectIntact(object, hash string, size int64) bool {
+	info, err := os.Stat(object)
+	if err != nil || info.Size() != size {
+		return false
+	}
+	got, err := fullHash(object)
+	return err == nil && got == hash
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var caseInsensitiveRoots = make(map[string]bool)
+
+// caseInsensitive reports whether the filesystem under root folds case, as
+// exFAT, NTFS and default APFS do, by probing it once with a temporary file.
+func caseInsensitive(root string) bool {
+	if folds, ok := caseInsensitiveRoots[root]; ok {
+		return folds
+	}
+	folds := false
+	probe, err := os.CreateTemp(existingParent(root), ".media_tool-case-*")
+	if err == nil {
+		probe.Close()
+		name := probe.Name()
+		upper := filepath.Join(filepath.Dir(name), strings.ToUpper(filepath.Base(name)))
+		_, err = os.Stat(upper)
+		folds = err == nil
+		os.Remove(name)
+	}
+	if folds {
+		log.Infof("destination %s is case-insensitive", root)
+	}
+	caseInsensitiveRoots[root] = folds
+	return folds
+}
+
+// plannedNames holds the destinations planned so far in a run, by their
+// path, case-folded on destinations where names differing only in case
+// collide.
+type plannedNames map[string]plannedName
+
+// plannedName is a destination and the file planned to it.
+type plannedName struct {
+	Dest, Source string
+}
+
+func (p plannedNames) key(dest string) string {
+	if caseInsensitive(rootOf(dest)) {
+		return strings.ToLower(dest)
+	}
+	return dest
+}
+
+// claim returns the name source may take on its destination, and why it is
+// not dest. A file planned to the name of another one of the run is
+// skipped when it has the same content, otherwise it takes a counter
+// suffix, so the second never overwrites the first when they are placed. A
+// name that only differs in case from one planned before is handled like an
+// existing file: it is skipped, or renamed with --no-skip.
+func (p plannedNames) claim(source, dest string) (string, string, error) {
+	reason := ""
+	for {
+		key := p.key(dest)
+		planned, taken := p[key]
+		if !taken || planned.Source == source {
+			p[key] = plannedName{Dest: dest, Source: source}
+			return dest, reason, nil
+		}
+		if planned.Dest == dest {
+			if sameContent(planned.Source, source) {
+				action(labelSkip, "%s is the same as %s, also planned to %s", source, planned.Source, dest)
+				return "", "", fmt.Errorf("%s is planned already", dest)
+			}
+			log.Infof("file %s is planned to %s as well as %s, add a counter", source, dest, planned.Source)
+			reason = "renamed, another planned file has the name " + dest
+			dest = p.free(dest)
+			continue
+		}
+		if c.OverWrite {
+			p[key] = plannedName{Dest: dest, Source: source}
+			return dest, reason, nil
+		}
+		if !c.NoSkip {
+			log.Infof("file %s collides with %s on a case-insensitive destination, skip", dest, planned.Dest)
+			return "", "", fmt.Errorf("%s collides with %s", dest, planned.Dest)
+		}
+		reason = "renamed, another planned file differs from " + dest + " only in case"
+		dest = generateNewFileName(dest)
+	}
+}
+
+// free returns dest with the lowest counter suffix, from _2, that is
+// neither planned nor on disk.
+func (p plannedNames) free(dest string) string {
+	ext := filepath.Ext(dest)
+	stem := strings.TrimSuffix(dest, ext)
+	for n := 2; ; n++ {
+		candidate := stem + "_" + strconv.Itoa(n) + ext
+		if _, taken := p[p.key(candidate)]; !taken && !fileExists(candidate) {
+			return candidate
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// routeRule sends a kind of file, such as the exports of a chat app or
+// screenshots, to its own tree.
+type routeRule struct {
+	Template string `yaml:"template"`
+}
+
+// chatPattern recognizes the file names a chat app gives its exports. The
+// first group holds the time: unix seconds, optionally followed by a second
+// group of milliseconds, or text in Layout when one is set.
+type chatPattern struct {
+	App     string
+	Pattern *regexp.Regexp
+	Layout  string
+}
+
+var chatPatterns = []chatPattern{
+	{App: "wechat", Pattern: regexp.MustCompile(`mmexport(1\d{9})(\d{3})?`)},
+	{App: "wechat", Pattern: regexp.MustCompile(`^wx_camera_(1\d{9})(\d{3})?`)},
+	// video exports
+	{App: "wechat", Pattern: regexp.MustCompile(`^microMsg\.(1\d{9})(\d{3})?`)},
+	{App: "qq", Pattern: regexp.MustCompile(`^QQ(?:图片|视频|截图)(\d{14})`), Layout: "20060102150405"},
+	// Telegram Desktop
+	{App: "telegram", Pattern: regexp.MustCompile(`^(?:photo|video)_(\d{4}-\d{2}-\d{2}_\d{2}-\d{2}-\d{2})`), Layout: "2006-01-02_15-04-05"},
+	{App: "dingtalk", Pattern: regexp.MustCompile(`^钉钉(?:图片|视频)(\d{14})`), Layout: "20060102150405"},
+	{App: "dingtalk", Pattern: regexp.MustCompile(`^(?i:dingtalk)_(1\d{9})(\d{3})?`)},
+}
+
+// matchChatExport dates a file from the name a chat app exported it under.
+func matchChatExport(file string) *mediaMeta {
+	base := filepath.Base(file)
+	for _, p := range chatPatterns {
+		matches := p.Pattern.FindStringSubmatch(base)
+		if matches == nil {
+			continue
+		}
+		tm, err := p.parse(matches)
+		if err != nil {
+			log.Errorf("error parsing time of %s: %v", file, err)
+			return nil
+		}
+		return &mediaMeta{Time: tm, App: p.App}
+	}
+	return nil
+}
+
+func (p chatPattern) parse(matches []string) (time.Time, error) {
+	if p.Layout != "" {
+		return time.ParseInLocation(p.Layout, matches[1], time.Local)
+	}
+	sec, err := strconv.ParseInt(matches[1], 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var ms int64
+	if len(matches) > 2 && matches[2] != "" {
+		ms, _ = strconv.ParseInt(matches[2], 10, 64)
+	}
+	return time.Unix(sec, ms*int64(time.Millisecond)), nil
+}
+
+// chatApp names the chat app a file was exported from, if any; it applies
+// even when the file kept its EXIF data.
+func chatApp(file string) string {
+	base := filepath.Base(file)
+	for _, p := range chatPatterns {
+		if p.Pattern.MatchString(base) {
+			return p.App
+		}
+	}
+	return ""
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+)
+
+const (
+	classScreenshot      = "screenshot"
+	classScreenRecording = "screen_recording"
+)
+
+// screenAspect is the aspect ratio from which a video without camera
+// metadata is taken for a phone screen recording; cameras film 16:9 or 4:3.
+const screenAspect = 1.9
+
+var classPatterns = []struct {
+	Class   string
+	Pattern *regexp.Regexp
+}{
+	{classScreenshot, regexp.MustCompile(`^(?i:screenshot|screen shot)|^(截屏|屏幕截图|截图)`)},
+	{classScreenRecording, regexp.MustCompile(`^(?i:screen ?recording|screenrecorder|screen-\d|rpreplay_)|^(录屏|屏幕录制)`)},
+}
+
+// classify tells screenshots, screen recordings and document scans from
+// camera media, by name or, for videos without a camera model, by their
+// screen shaped size.
+func classify(file string, meta *mediaMeta) string {
+	if docTypes[getFileExtension(file, false)] {
+		return classDocument
+	}
+	base := filepath.Base(file)
+	for _, p := range classPatterns {
+		if p.Pattern.MatchString(base) {
+			return p.Class
+		}
+	}
+	if isScan(file, meta) {
+		return classScan
+	}
+	if meta.Model != "" || !isoVideoTypes[getFileExtension(file, false)] {
+		return ""
+	}
+	width, height, err := videoDimensions(file)
+	if err != nil {
+		return ""
+	}
+	long, short := float64(width), float64(height)
+	if short > long {
+		long, short = short, long
+	}
+	if long/short >= screenAspect {
+		return classScreenRecording
+	}
+	return ""
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// files returns the item followed by its companions.
+func (item planItem) files() []planItem {
+	return append([]planItem{item}, item.Companions...)
+}
+
+// companionFiles returns the files that travel with source.
+func companionFiles(source string) []string {
+	companions := assetCompanions(source)
+	if c.CatalogSafe {
+		for _, file := range append([]string{source}, companions...) {
+			if sidecar := findSidecar(file); sidecar != "" && !contains(companions, sidecar) {
+				companions = append(companions, sidecar)
+			}
+		}
+	}
+	return companions
+}
+
+// planCompanions finds the files that must travel with a planned file, its
+// RAW and gain map files or subtitles and, in catalog-safe mode, its XMP
+// sidecar, and
+// names them after its destination. It fails rather than let only one file
+// of the group be placed.
+func planCompanions(item *planItem) error {
+	for _, companion := range companionFiles(item.Source) {
+		dest := companionDest(item.Source, companion, item.Dest)
+		if fileExists(dest) && !c.OverWrite {
+			return fmt.Errorf("companion destination %s already exists", dest)
+		}
+		item.Companions = append(item.Companions, planItem{Source: companion, Dest: dest})
+	}
+	return nil
+}
+
+// companionDest names a companion after the destination of its main file,
+// keeping whatever suffix it had: IMG_1.ARW.xmp, IMG_1.xmp or IMG_1.en.srt.
+func companionDest(source, companion, dest string) string {
+	if apple := appleCompanionDest(source, companion, dest); apple != "" {
+		return apple
+	}
+	if shot := shotCompanionDest(source, companion, dest); shot != "" {
+		return shot
+	}
+	if strings.HasPrefix(companion, source) {
+		return dest + strings.TrimPrefix(companion, source)
+	}
+	srcStem := strings.TrimSuffix(source, filepath.Ext(source))
+	destStem := strings.TrimSuffix(dest, filepath.Ext(dest))
+	return destStem + normalizeExt(strings.TrimPrefix(companion, srcStem))
+}
+
+// placeItem puts a file and then its companions in place. When one of them
+// fails, the files of the group placed before it are taken back, so a RAW
+// is never split from its JPEG or sidecar.
+func placeItem(item planItem) error {
+	status.working(item.Source)
+	if err := unchanged(item.Source); err != nil {
+		return err
+	}
+	files := item.files()
+	for i, f := range files {
+		if err := processOneFile(f.Source, f.Dest); err != nil {
+			for _, placed := range files[:i] {
+				if undoErr := unplace(placed); undoErr != nil {
+					log.Errorf("error taking back %s: %v", placed.Dest, undoErr)
+				}
+			}
+			return fmt.Errorf("%s: %w", f.Source, err)
+		}
+	}
+	for _, f := range files {
+		recordRelink(f.Source, f.Dest)
+	}
+	return nil
+}
+
+// unplace undoes processOneFile: a moved file goes back to its source, a
+// copy or a link of the cas layout is removed. An object may be shared and
+// stays; a source moved into it is copied back out.
+func unplace(f planItem) error {
+	if c.Mode == "move" && c.Layout != layoutCAS {
+		return moveFile(f.Dest, f.Source)
+	}
+	if c.Mode == "move" {
+		if err := copyFile(f.Dest, f.Source); err != nil {
+			return err
+		}
+	}
+	return os.Remove(f.Dest)
+}
+
+// relinks are the old and new locations of every placed file, for
+// re-linking catalogs such as Lightroom's after a mass move.
+var relinks = struct {
+	sync.Mutex
+	rows [][]string
+}{}
+
+func recordRelink(source, dest string) {
+	if c.RelinkCSV == "" {
+		return
+	}
+	relinks.Lock()
+	relinks.rows = append(relinks.rows, []string{absPath(source), absPath(dest)})
+	relinks.Unlock()
+}
+
+func writeRelinkCSV(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	if err = w.Write([]string{"old_path", "new_path"}); err != nil {
+		return err
+	}
+	relinks.Lock()
+	err = w.WriteAll(relinks.rows)
+	relinks.Unlock()
+	if err != nil {
+		return err
+	}
+	log.Infof("write relink csv: %s", path)
+	return nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+var configCommand = &cli.Command{
+	Name:  "config",
+	Usage: "check the config before a real run",
+	Subcommands: []*cli.Command{
+		{
+			Name:      "test-template",
+			Usage:     "render the templates for a few files, or sample ones, and catch unknown {variables}",
+			ArgsUsage: "[file or dir...]",
+			Flags: []cli.Flag{
+				configFlag(),
+				&cli.StringFlag{
+					Name:        "template",
+					Aliases:     []string{"t"},
+					Destination: &c.Template,
+					Usage:       "try this template instead of the one in the config",
+				},
+			},
+			Action: testTemplate,
+		},
+	},
+}
+
+// testFilesPerDir is how many files of a directory test-template renders.
+const testFilesPerDir = 5
+
+// anyVar matches whatever looks like a variable, so {Month} and {moth} are
+// caught along with the well-formed ones.
+var anyVar = regexp.MustCompile(`\{[^{}/]*\}`)
+
+func testTemplate(ctx *cli.Context) error {
+	if err := loadConfigFile(); err != nil {
+		return err
+	}
+	if c.Template != "" {
+		y.Template = c.Template
+	}
+
+	bad := 0
+	for _, t := range configuredTemplates() {
+		unknown := unknownVars(t.template)
+		if len(unknown) == 0 {
+			continue
+		}
+		bad++
+		for _, v := range unknown {
+			if guess := closestVar(v); guess != "" {
+				fmt.Printf("%s: unknown %s in %q, did you mean {%s}?\n", t.name, v, t.template, guess)
+			} else {
+				fmt.Printf("%s: unknown %s in %q\n", t.name, v, t.template)
+			}
+		}
+	}
+
+	if ctx.NArg() == 0 {
+		for _, sample := range sampleFiles() {
+			fmt.Printf("%s (sample %s)\n  -> %s\n", sample.file, sample.describe, buildPath(sample.file, sample.meta))
+		}
+	} else {
+		for _, file := range testFiles(ctx.Args().Slice()) {
+			c.Source = filepath.Dir(file)
+			newPath, _, err := processImage(file)
+			if err != nil {
+				fmt.Printf("%s\n  !! %v\n", file, err)
+				continue
+			}
+			fmt.Printf("%s\n  -> %s\n", file, newPath)
+		}
+	}
+
+	if bad > 0 {
+		return fmt.Errorf("%d templates use unknown variables", bad)
+	}
+	return nil
+}
+
+type namedTemplate struct {
+	name, template string
+}
+
+// configuredTemplates lists every template of the config by where it is
+// set, the default one standing in for a missing template.
+func configuredTemplates() []namedTemplate {
+	templates := []namedTemplate{{"template", y.Template}}
+	if y.Template == "" {
+		templates[0].template = defaultTemplate
+	}
+	if y.Album != "" {
+		templates = append(templates, namedTemplate{"album", y.Album})
+	}
+	for _, section := range []struct {
+		name  string
+		rules map[string]string
+	}{
+		{"apps", appTemplates()},
+		{"classes", classTemplates()},
+		{"cameras", cameraTemplates()},
+	} {
+		keys := make([]string, 0, len(section.rules))
+		for k := range section.rules {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			templates = append(templates, namedTemplate{section.name + "." + k, section.rules[k]})
+		}
+	}
+	return templates
+}
+
+func appTemplates() map[string]string {
+	m := make(map[string]string)
+	for k, rule := range y.Apps {
+		if rule.Template != "" {
+			m[k] = rule.Template
+		}
+	}
+	return m
+}
+
+func classTemplates() map[string]string {
+	m := make(map[string]string)
+	for k, rule := range y.Classes {
+		if rule.Template != "" {
+			m[k] = rule.Template
+		}
+	}
+	return m
+}
+
+func cameraTemplates() map[string]string {
+	m := make(map[string]string)
+	for k, rule := range y.Cameras {
+		if rule.Template != "" {
+			m[k] = rule.Template
+		}
+	}
+	return m
+}
+
+// knownVars are the variables a template can use.
+func knownVars() map[string]bool {
+	known := map[string]bool{"index": true}
+	for k := range templateVars("IMG_0001.JPG", &mediaMeta{}) {
+		known[k] = true
+	}
+	return known
+}
+
+// unknownVars returns the {variables} of tmpl that rendering leaves as
+// they are.
+func unknownVars(tmpl string) []string {
+	known := knownVars()
+	unknown := make([]string, 0)
+	for _, v := range anyVar.FindAllString(tmpl, -1) {
+		if !known[v[1:len(v)-1]] && !contains(unknown, v) {
+			unknown = append(unknown, v)
+		}
+	}
+	return unknown
+}
+
+// closestVar suggests the known variable a typo was meant to be, if one is
+// at most two edits away.
+func closestVar(v string) string {
+	name := strings.ToLower(strings.TrimSpace(v[1 : len(v)-1]))
+	best, bestDistance := "", 3
+	for k := range knownVars() {
+		if d := editDistance(name, k); d < bestDistance || d == bestDistance && k < best {
+			best, bestDistance = k, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// testFiles takes the files given as they are and the first few media files
+// of each directory.
+func testFiles(args []string) []string {
+	files := make([]string, 0, len(args))
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			fmt.Printf("%s\n  !! %v\n", arg, err)
+			continue
+		}
+		if !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		found, err := walkDirectory(arg)
+		if err != nil {
+			fmt.Printf("%s\n  !! %v\n", arg, err)
+			continue
+		}
+		n := 0
+		for _, file := range found {
+			if n == testFilesPerDir {
+				break
+			}
+			if isMediaFile(file) && primaryOf(file) == "" {
+				files = append(files, file)
+				n++
+			}
+		}
+	}
+	return files
+}
+
+type sampleFile struct {
+	file, describe string
+	meta           *mediaMeta
+}
+
+// sampleFiles are made up files covering the usual metadata, for trying a
+// template without any at hand.
+func sampleFiles() []sampleFile {
+	taken := time.Date(2021, 7, 14, 15, 4, 5, 0, time.Local)
+	photo := &mediaMeta{Time: taken, Model: "Canon EOS 5D Mark III", Event: "Paris", Serial: "012345678901",
+		Width: 5760, Height: 3840, GPS: &gpsPoint{Lat: 48.8584, Lon: 2.2945}}
+	phone := &mediaMeta{Time: taken, Model: "iPhone 12", Width: 4032, Height: 3024}
+	video := &mediaMeta{Time: taken, Model: "iPhone 12", Width: 3840, Height: 2160, Duration: 65 * time.Second, Codec: "hevc"}
+	screenshot := &mediaMeta{Time: taken, Class: classScreenshot, Width: 1170, Height: 2532}
+	chat := &mediaMeta{Time: taken, App: "wechat"}
+	return []sampleFile{
+		{"IMG_0001.CR2", "camera photo with event and GPS", photo},
+		{"IMG_0002.HEIC", "phone photo", phone},
+		{"IMG_0003.MOV", "4K video", video},
+		{"Screenshot_20210714-150405.png", "screenshot", screenshot},
+		{"mmexport1626267845000.jpg", "WeChat export", chat},
+	}
+}
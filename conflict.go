@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// interactive is true when nobody chose a policy for existing files and a
+// person is there to decide.
+func interactive() bool {
+	return !c.Yes && !c.Dry && !c.OverWrite && !c.NoSkip && c.FilesFrom != "-"
+}
+
+// resolveExisting decides what happens when dest already exists. A file
+// whose capture key differs from the existing one's, another shot such as
+// one of another body of the same model, is kept next to it unless
+// --overwrite is given. Otherwise identical files are skipped; in
+// interactive mode a different file is shown next to the existing one and
+// the user picks, with --skip-identical both are kept, otherwise checkExist
+// applies.
+func resolveExisting(source, dest string, meta *mediaMeta) (string, error) {
+	if !fileExists(dest) || c.OverWrite {
+		return checkExist(dest)
+	}
+	destMeta := decodeExifCached(dest)
+	if otherShot(meta, destMeta) && !sameContent(source, dest) {
+		log.Infof("file %s is another shot than %s, keep both", source, dest)
+		return generateNewFileName(dest), nil
+	}
+	if !(interactive() || c.SkipIdentical) {
+		return checkExist(dest)
+	}
+	sourceHash, err := fullHash(source)
+	if err != nil {
+		return "", err
+	}
+	destHash, err := fullHash(dest)
+	if err != nil {
+		return "", err
+	}
+	if sourceHash == destHash {
+		action(labelSkip, "%s is already at %s", source, dest)
+		return "", fmt.Errorf("%s already exists", dest)
+	}
+
+	if !interactive() {
+		return generateNewFileName(dest), nil
+	}
+
+	fmt.Printf("%s already exists with different content:\n", dest)
+	fmt.Printf("  new      %s\n", describeFile(source, meta, sourceHash))
+	fmt.Printf("  existing %s\n", describeFile(dest, destMeta, destHash))
+	switch askChoice("keep (b)oth, (r)eplace or (s)kip?", "b", "r", "s") {
+	case "b":
+		return generateNewFileName(dest), nil
+	case "r":
+		return dest, nil
+	}
+	return "", fmt.Errorf("%s already exists", dest)
+}
+
+// captureKey identifies a shot by the body that took it and when, or is ""
+// when the body is not known. Two bodies of one model name their files
+// alike; the key tells their shots apart.
+func captureKey(meta *mediaMeta) string {
+	if meta == nil || meta.Serial == "" {
+		return ""
+	}
+	// the time the camera wrote, before any clock correction
+	tm := meta.Time
+	if !meta.OriginalTime.IsZero() {
+		tm = meta.OriginalTime
+	}
+	return meta.Model + "\x00" + meta.Serial + "\x00" + tm.UTC().Format(time.RFC3339Nano)
+}
+
+// otherShot reports whether two files with one name are different shots by
+// their capture keys.
+func otherShot(a, b *mediaMeta) bool {
+	ka, kb := captureKey(a), captureKey(b)
+	return ka != "" && kb != "" && ka != kb
+}
+
+func describeFile(file string, meta *mediaMeta, hash string) string {
+	size := "?"
+	if info, err := os.Stat(file); err == nil {
+		size = formatBytes(uint64(info.Size()))
+	}
+	taken := "unknown"
+	if meta != nil && !meta.Time.IsZero() {
+		taken = meta.Time.Format(time.DateTime)
+	}
+	if meta != nil && meta.Serial != "" {
+		taken += " by body " + meta.Serial
+	}
+	return fmt.Sprintf("%s, taken %s, sha256 %s", size, taken, hash[:12])
+}
+
+// askChoice asks until one of the choices is answered.
+func askChoice(prompt string, choices ...string) string {
+	for {
+		fmt.Printf("%s [%s]: ", prompt, strings.Join(choices, "/"))
+		response, err := stdin.ReadString('\n')
+		if err != nil {
+			log.Fatal(err)
+		}
+		response = strings.ToLower(strings.TrimSpace(response))
+		for _, choice := range choices {
+			if response == choice {
+				return choice
+			}
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// labels of the per-file console lines; the placing ones follow --mode
+const (
+	labelSkip = "SKIP"
+	labelFail = "FAIL"
+)
+
+var labelColors = map[string]string{
+	"COPY":    "\x1b[32m",
+	"MOVE":    "\x1b[36m",
+	"EXPORT":  "\x1b[34m",
+	labelSkip: "\x1b[33m",
+	labelFail: "\x1b[31m",
+}
+
+// labelDone is how the summary names what happened to the files of a label.
+var labelDone = map[string]string{
+	"COPY":    "copied",
+	"MOVE":    "moved",
+	"EXPORT":  "exported",
+	labelSkip: "skipped",
+	labelFail: "failed",
+}
+
+var actionCounts = struct {
+	sync.Mutex
+	n map[string]int
+}{n: make(map[string]int)}
+
+// useColor is set when stderr is a terminal and NO_COLOR is not.
+var useColor = func() bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := os.Stderr.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}()
+
+// setupConsole applies --quiet and --verbose; quiet keeps errors, FAIL
+// lines and the summary.
+func setupConsole() error {
+	if c.Quiet && (c.Verbose || c.Debug) {
+		return fmt.Errorf("--quiet cannot be combined with --verbose or --debug")
+	}
+	switch {
+	case c.Debug:
+		log.SetLevel(log.DebugLevel)
+	case c.Quiet:
+		log.SetLevel(log.ErrorLevel)
+	}
+	return nil
+}
+
+func modeLabel() string {
+	return strings.ToUpper(c.Mode)
+}
+
+// action prints a labelled line for what happened to a file and counts it
+// for the summary.
+func action(label, format string, args ...interface{}) {
+	actionCounts.Lock()
+	actionCounts.n[label]++
+	actionCounts.Unlock()
+	if c.Quiet && label != labelFail {
+		return
+	}
+	tag := fmt.Sprintf("%-6s", label)
+	if useColor {
+		tag = labelColors[label] + tag + "\x1b[0m"
+	}
+	fmt.Fprintf(os.Stderr, "%s %s\n", tag, fmt.Sprintf(format, args...))
+}
+
+// decide logs why a file goes where it goes, with --verbose or --debug.
+func decide(format string, args ...interface{}) {
+	if c.Verbose || c.Debug {
+		log.Infof(format, args...)
+	}
+}
+
+// resetSummary starts the counts over, for a run that imports several
+// sources one after the other.
+func resetSummary() {
+	actionCounts.Lock()
+	actionCounts.n = make(map[string]int)
+	actionCounts.Unlock()
+}
+
+// printSummary prints the counts of every label, even with --quiet.
+func printSummary() {
+	actionCounts.Lock()
+	defer actionCounts.Unlock()
+	parts := make([]string, 0, len(labelDone))
+	for _, label := range []string{"COPY", "MOVE", "EXPORT", labelSkip, labelFail} {
+		n := actionCounts.n[label]
+		switch {
+		case n == 0:
+		case c.Dry:
+			parts = append(parts, fmt.Sprintf("%d to %s", n, strings.ToLower(label)))
+		default:
+			parts = append(parts, fmt.Sprintf("%d %s", n, labelDone[label]))
+		}
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "nothing to do")
+	}
+	prefix := "finished"
+	if c.Dry {
+		prefix = "dry run"
+	}
+	fmt.Fprintf(os.Stderr, "%s: %s\n", prefix, strings.Join(parts, ", "))
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// corruptDir is where broken files are quarantined inside the destination.
+const corruptDir = "Corrupt"
+
+// decodableTypes are the formats the standard library can fully decode.
+var decodableTypes = map[string]bool{
+	"jpg":  true,
+	"jpeg": true,
+	"png":  true,
+	"gif":  true,
+}
+
+// checkCorrupt returns why a file is broken, or nil if it looks healthy.
+// Beyond the header, images that can be decoded are decoded completely so
+// truncated files are caught too.
+func checkCorrupt(file string) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return fmt.Errorf("zero-byte file")
+	}
+
+	if !decodableTypes[getFileExtension(file, false)] {
+		return nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, _, err = image.Decode(f); err != nil {
+		return fmt.Errorf("cannot decode image: %w", err)
+	}
+	return nil
+}
+
+// writeCorruptReport appends the quarantined files and their reasons to
+// the report in the quarantine folder.
+func writeCorruptReport(corrupt map[string]string) error {
+	files := make([]string, 0, len(corrupt))
+	for file := range corrupt {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	var b strings.Builder
+	for _, file := range files {
+		fmt.Fprintf(&b, "%s\t%s\n", file, corrupt[file])
+	}
+
+	reportPath := filepath.Join(c.Destination, corruptDir, "report.txt")
+	if err := createParentDir(filepath.Dir(reportPath)); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(reportPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(b.String())
+	if err == nil {
+		log.Infof("write corrupt file report: %s", reportPath)
+	}
+	return err
+}
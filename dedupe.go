@@ -0,0 +1,394 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// partialHashSize is how much of the head and tail of a file is hashed
+// before deciding whether a full hash is worth computing.
+const partialHashSize = 64 * 1024
+
+var dedupeCommand = &cli.Command{
+	Name:  "dedupe",
+	Usage: "find duplicate files in a directory",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:        "dir",
+			Aliases:     []string{"d"},
+			Destination: &c.Destination,
+			Usage:       "the directory to scan",
+			Required:    true,
+		},
+		&cli.StringFlag{
+			Name:        "config",
+			Aliases:     []string{"c"},
+			Destination: &c.ConfigPath,
+			Usage:       "yaml config file path",
+			DefaultText: "config.yaml",
+			Required:    false,
+		},
+		&cli.IntFlag{
+			Name:        "workers",
+			Aliases:     []string{"w"},
+			Destination: &c.Workers,
+			Usage:       "number of concurrent hashing workers",
+			Value:       4,
+		},
+		&cli.BoolFlag{
+			Name:        "hardlink",
+			Destination: &c.Hardlink,
+			Usage:       "replace every duplicate with a hardlink to the first file of its group, which then holds the data once",
+		},
+		&cli.StringFlag{
+			Name:        "format",
+			Destination: &c.Format,
+			Usage:       "also write the duplicates as a report of fclones or rmlint, for their cleanup tooling",
+		},
+		&cli.StringFlag{
+			Name:        "out",
+			Aliases:     []string{"o"},
+			Destination: &c.Target,
+			Usage:       "file the --format report is written to, - for stdout",
+			Value:       "-",
+		},
+	},
+	Action: dedupe,
+}
+
+type duplicateGroup struct {
+	Size  int64
+	Hash  string
+	Files []string
+	// Linked holds extra names of files in Files that are hardlinks to the
+	// same inode; they take no extra space and are not counted as savings.
+	Linked map[string][]string
+}
+
+// fileID identifies the data a path points to; hardlinks share one.
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+func dedupe(_ *cli.Context) error {
+	err := loadConfigFile()
+	if err != nil {
+		return err
+	}
+	if c.Format != "" && c.Format != "fclones" && c.Format != "rmlint" {
+		return fmt.Errorf("--format is fclones or rmlint, not %q", c.Format)
+	}
+	fileList, err := walkDirectory(c.Destination)
+	if err != nil {
+		return err
+	}
+
+	groups, err := findDuplicates(fileList, c.Workers)
+	if err != nil {
+		return err
+	}
+
+	var reclaimable int64
+	companions, ofPrimary := companionGroups(groups)
+	for i, group := range groups {
+		if _, ok := ofPrimary[i]; ok {
+			continue
+		}
+		logGroup("", "duplicate group", group)
+		reclaimable += group.Size * int64(len(group.Files)-1)
+		for _, j := range companions[i] {
+			logGroup("  ", "with", groups[j])
+			reclaimable += groups[j].Size * int64(len(groups[j].Files)-1)
+		}
+	}
+	log.Infof("found %d duplicate groups, %d bytes reclaimable", len(groups)-len(ofPrimary), reclaimable)
+	if c.Hardlink {
+		linked, reclaimed := hardlinkDuplicates(groups)
+		log.Infof("hardlinked %d duplicates, %d bytes reclaimed", linked, reclaimed)
+	}
+
+	if c.Format == "" {
+		return nil
+	}
+	out := io.Writer(os.Stdout)
+	if c.Target != "-" {
+		f, err := os.Create(c.Target)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	return writeDuplicateReport(out, groups)
+}
+
+func logGroup(indent, title string, group duplicateGroup) {
+	log.Infof("%s%s %s (%d bytes):", indent, title, group.Hash[:12], group.Size)
+	for _, file := range group.Files {
+		log.Infof("%s  %s", indent, file)
+		for _, link := range group.Linked[file] {
+			log.Infof("%s    = %s (hardlink)", indent, link)
+		}
+	}
+}
+
+// companionGroups finds the groups of RAW, gain map and other companion
+// files whose primaries are duplicates of each other too, so an asset is
+// one duplicate however many files it has. It returns the companion groups
+// by the index of their primary group, and that index by companion group.
+func companionGroups(groups []duplicateGroup) (map[int][]int, map[int]int) {
+	groupOf := make(map[string]int)
+	for i, group := range groups {
+		for _, file := range group.Files {
+			groupOf[file] = i
+		}
+	}
+	companions := make(map[int][]int)
+	ofPrimary := make(map[int]int)
+	for i, group := range groups {
+		primary := -1
+		for _, file := range group.Files {
+			j, ok := groupOf[primaryOf(file)]
+			if !ok || j == i || (primary != -1 && j != primary) {
+				primary = -1
+				break
+			}
+			primary = j
+		}
+		if primary != -1 {
+			companions[primary] = append(companions[primary], i)
+			ofPrimary[i] = primary
+		}
+	}
+	return companions, ofPrimary
+}
+
+// findDuplicates groups files by size, then by a partial hash of their head
+// and tail, and only computes full hashes for the groups that survive.
+func findDuplicates(files []string, workers int) ([]duplicateGroup, error) {
+	bySize := make(map[int64][]string)
+	seen := make(map[fileID]string)
+	linked := make(map[string][]string)
+	for _, file := range files {
+		info, err := os.Lstat(file)
+		if err != nil {
+			return nil, err
+		}
+		if !info.Mode().IsRegular() || info.Size() == 0 {
+			continue
+		}
+		// only one name per inode takes part in hashing
+		if id, ok := fileIdentity(info); ok {
+			if first, dup := seen[id]; dup {
+				linked[first] = append(linked[first], file)
+				continue
+			}
+			seen[id] = file
+		}
+		bySize[info.Size()] = append(bySize[info.Size()], file)
+	}
+	for first, links := range linked {
+		log.Debugf("file %s already shares its data with %d hardlinks", first, len(links))
+	}
+
+	groups := make([]duplicateGroup, 0)
+	for size, candidates := range bySize {
+		if len(candidates) < 2 {
+			continue
+		}
+
+		partial := hashFiles(candidates, workers, partialHash)
+		for hash, sameHead := range partial {
+			if len(sameHead) < 2 {
+				continue
+			}
+			// the partial hash already covered the whole file
+			if size <= 2*partialHashSize {
+				groups = append(groups, duplicateGroup{Size: size, Hash: hash, Files: sameHead})
+				continue
+			}
+			for full, same := range hashFiles(sameHead, workers, fullHash) {
+				if len(same) < 2 {
+					continue
+				}
+				groups = append(groups, duplicateGroup{Size: size, Hash: full, Files: same})
+			}
+		}
+	}
+
+	for i := range groups {
+		sort.Strings(groups[i].Files)
+		for _, file := range groups[i].Files {
+			if links, ok := linked[file]; ok {
+				if groups[i].Linked == nil {
+					groups[i].Linked = make(map[string][]string)
+				}
+				groups[i].Linked[file] = links
+			}
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Files[0] < groups[j].Files[0]
+	})
+	return groups, nil
+}
+
+// hashFiles hashes files concurrently and groups them by the resulting hash.
+// Files that cannot be read are logged and left out.
+func hashFiles(files []string, workers int, hashFn func(string) (string, error)) map[string][]string {
+	if workers < 1 {
+		workers = 1
+	}
+
+	type result struct {
+		file string
+		hash string
+	}
+
+	jobs := make(chan string)
+	results := make(chan result)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range jobs {
+				hash, err := hashFn(file)
+				if err != nil {
+					log.Errorf("error hashing %s: %v", file, err)
+					continue
+				}
+				results <- result{file: file, hash: hash}
+			}
+		}()
+	}
+
+	go func() {
+		for _, file := range files {
+			jobs <- file
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	grouped := make(map[string][]string)
+	for r := range results {
+		grouped[r.hash] = append(grouped[r.hash], r.file)
+	}
+	return grouped
+}
+
+func partialHash(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	if _, err = io.CopyN(h, f, partialHashSize); err != nil && err != io.EOF {
+		return "", err
+	}
+	if info.Size() > 2*partialHashSize {
+		if _, err = f.Seek(-partialHashSize, io.SeekEnd); err != nil {
+			return "", err
+		}
+		if _, err = io.Copy(h, f); err != nil {
+			return "", err
+		}
+	} else if info.Size() > partialHashSize {
+		if _, err = io.Copy(h, f); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func fullHash(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("error reading %s: %w", file, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hardlinkDuplicates replaces the duplicates of every group with hardlinks
+// to its first file, and returns how many were replaced and the bytes that
+// freed. A duplicate on another device than the first file stays a copy.
+func hardlinkDuplicates(groups []duplicateGroup) (int, int64) {
+	linked := 0
+	var reclaimed int64
+	for _, group := range groups {
+		keep := group.Files[0]
+		keepInfo, err := os.Stat(keep)
+		if err != nil {
+			log.Errorf("error reading %s: %v", keep, err)
+			continue
+		}
+		keepID, _ := fileIdentity(keepInfo)
+		for _, dup := range group.Files[1:] {
+			info, err := os.Stat(dup)
+			if err != nil {
+				log.Errorf("error reading %s: %v", dup, err)
+				continue
+			}
+			if id, ok := fileIdentity(info); !ok || id.dev != keepID.dev {
+				log.Warnf("keep %s, it is on another device than %s", dup, keep)
+				continue
+			}
+			// the hardlinks of the duplicate follow it, or they would
+			// keep its data
+			names := append([]string{dup}, group.Linked[dup]...)
+			replaced := 0
+			for _, name := range names {
+				if err = replaceWithLink(keep, name); err != nil {
+					log.Errorf("error linking %s to %s: %v", name, keep, err)
+					continue
+				}
+				log.Infof("  %s = %s", name, keep)
+				replaced++
+			}
+			linked += replaced
+			// names outside the directory still hold the data
+			if replaced == len(names) && hardlinkCount(info) == uint64(len(names)) {
+				reclaimed += group.Size
+			}
+		}
+	}
+	return linked, reclaimed
+}
+
+// replaceWithLink swaps dup for a hardlink to keep in one rename, so dup
+// never goes missing if linking fails half way.
+func replaceWithLink(keep, dup string) error {
+	tmp := dup + ".media_tool-link"
+	if err := os.Link(keep, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dup); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// detachTerminal starts cmd in a session of its own, without a controlling
+// terminal, so a password prompt in it reads standard input instead of
+// /dev/tty.
+func detachTerminal(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+var diffCommand = &cli.Command{
+	Name:  "diff",
+	Usage: "compare two library trees by content, failing when files of the left one are missing, differ or cannot be read",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:        "left",
+			Aliases:     []string{"a"},
+			Destination: &c.Source,
+			Usage:       "the tree to check, e.g. the old copy",
+			Required:    true,
+		},
+		&cli.StringFlag{
+			Name:        "right",
+			Aliases:     []string{"b"},
+			Destination: &c.Destination,
+			Usage:       "the tree to compare it with",
+			Required:    true,
+		},
+		&cli.StringFlag{
+			Name:        "config",
+			Aliases:     []string{"c"},
+			Destination: &c.ConfigPath,
+			Usage:       "yaml config file path",
+			DefaultText: "config.yaml",
+			Required:    false,
+		},
+		&cli.IntFlag{
+			Name:        "workers",
+			Aliases:     []string{"w"},
+			Destination: &c.Workers,
+			Usage:       "number of concurrent hashing workers",
+			Value:       4,
+		},
+	},
+	Action: diffTrees,
+}
+
+// treeHashes hashes every file of a tree, keyed by its slash separated path
+// relative to the root, and returns how many files could not be hashed.
+func treeHashes(root string, workers int) (map[string]string, int, error) {
+	files, err := walkDirectory(root)
+	if err != nil {
+		return nil, 0, err
+	}
+	hashes := make(map[string]string, len(files))
+	for hash, same := range hashFiles(files, workers, fullHash) {
+		for _, file := range same {
+			rel, err := filepath.Rel(root, file)
+			if err != nil {
+				return nil, 0, err
+			}
+			hashes[filepath.ToSlash(rel)] = hash
+		}
+	}
+	return hashes, len(files) - len(hashes), nil
+}
+
+func diffTrees(_ *cli.Context) error {
+	if err := loadConfigFile(); err != nil {
+		return err
+	}
+	left, leftUnread, err := treeHashes(c.Source, c.Workers)
+	if err != nil {
+		return err
+	}
+	right, rightUnread, err := treeHashes(c.Destination, c.Workers)
+	if err != nil {
+		return err
+	}
+	rightContent := make(map[string]string, len(right))
+	for rel, hash := range right {
+		rightContent[hash] = rel
+	}
+	leftContent := make(map[string]bool, len(left))
+	for _, hash := range left {
+		leftContent[hash] = true
+	}
+
+	paths := make([]string, 0, len(left))
+	for rel := range left {
+		paths = append(paths, rel)
+	}
+	sort.Strings(paths)
+
+	missing, differing, moved, extra := 0, 0, 0, 0
+	for _, rel := range paths {
+		hash := left[rel]
+		if right[rel] == hash {
+			continue
+		}
+		if _, ok := right[rel]; ok {
+			log.Warnf("differs: %s", rel)
+			differing++
+			continue
+		}
+		if other, ok := rightContent[hash]; ok {
+			log.Infof("moved: %s is at %s", rel, other)
+			moved++
+			continue
+		}
+		log.Warnf("missing: %s", rel)
+		missing++
+	}
+
+	extras := make([]string, 0)
+	for rel, hash := range right {
+		if !leftContent[hash] {
+			extras = append(extras, rel)
+		}
+	}
+	sort.Strings(extras)
+	for _, rel := range extras {
+		if _, ok := left[rel]; ok {
+			// already reported as differing
+			continue
+		}
+		log.Infof("extra: %s", rel)
+		extra++
+	}
+
+	unread := leftUnread + rightUnread
+	log.Infof("%d files compared: %d missing, %d differing, %d moved, %d extra, %d unreadable",
+		len(left), missing, differing, moved, extra, unread)
+	if missing > 0 || differing > 0 || unread > 0 {
+		return fmt.Errorf("the trees differ: %d missing, %d differing, %d unreadable", missing, differing, unread)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// imageSize returns the width and height of a picture, from its header for
+// the formats Go decodes and from the EXIF for the others, or zeros.
+func imageSize(file string) (int, int) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, 0
+	}
+	defer f.Close()
+	if config, _, err := image.DecodeConfig(f); err == nil {
+		return config.Width, config.Height
+	}
+
+	if _, err = f.Seek(0, 0); err != nil {
+		return 0, 0
+	}
+	x, _ := exif.Decode(f)
+	if x == nil {
+		return 0, 0
+	}
+	width, errW := x.Get(exif.PixelXDimension)
+	height, errH := x.Get(exif.PixelYDimension)
+	if errW != nil || errH != nil {
+		return 0, 0
+	}
+	w, errW := width.Int(0)
+	h, errH := height.Int(0)
+	if errW != nil || errH != nil {
+		return 0, 0
+	}
+	return w, h
+}
+
+// mediaSize returns the width and height of a picture or video, or zeros.
+func mediaSize(file string) (int, int) {
+	if videoTypes[getFileExtension(file, false)] {
+		w, h, err := videoDimensions(file)
+		if err != nil {
+			return 0, 0
+		}
+		return w, h
+	}
+	if picTypes[getFileExtension(file, false)] {
+		return imageSize(file)
+	}
+	return 0, 0
+}
+
+// megapixels is the resolution of meta in millions of pixels.
+func megapixels(meta *mediaMeta) float64 {
+	return float64(meta.Width) * float64(meta.Height) / 1e6
+}
+
+// sizeVars are the {mp}, e.g. "12.2", and {resolution}, e.g. "4032x3024",
+// of a file, empty when its size is not known.
+func sizeVars(meta *mediaMeta) map[string]string {
+	if meta.Width == 0 || meta.Height == 0 {
+		return map[string]string{"mp": "", "resolution": ""}
+	}
+	return map[string]string{
+		"mp":         strconv.FormatFloat(megapixels(meta), 'f', 1, 64),
+		"resolution": fmt.Sprintf("%dx%d", meta.Width, meta.Height),
+	}
+}
+
+// belowMinMP reports whether a picture is smaller than --min-mp. Pictures
+// of unknown size are kept.
+func belowMinMP(file string, meta *mediaMeta) bool {
+	if c.MinMP <= 0 || !picTypes[getFileExtension(file, false)] || meta.Width == 0 {
+		return false
+	}
+	return megapixels(meta) < c.MinMP
+}
+
+// videoVars are the {duration} of a video in whole seconds, e.g. "1m5s",
+// its {codec}, e.g. "hevc", and its {definition}, e.g. "4K" or "1080p", to
+// keep footage apart by quality. They are empty for pictures.
+func videoVars(meta *mediaMeta) map[string]string {
+	vars := map[string]string{"duration": "", "codec": meta.Codec, "definition": ""}
+	if meta.Duration > 0 {
+		vars["duration"] = meta.Duration.Round(time.Second).String()
+	}
+	if meta.Duration > 0 || meta.Codec != "" {
+		vars["definition"] = definition(meta.Width, meta.Height)
+	}
+	return vars
+}
+
+// definition names a video resolution by its short edge.
+func definition(width, height int) string {
+	short := width
+	if height < short {
+		short = height
+	}
+	switch {
+	case short == 0:
+		return ""
+	case short >= 4320:
+		return "8K"
+	case short >= 2160:
+		return "4K"
+	case short >= 1440:
+		return "1440p"
+	case short >= 1080:
+		return "1080p"
+	case short >= 720:
+		return "720p"
+	}
+	return "SD"
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// freeSpaceMargin is left free on the destination on top of the plan.
+const freeSpaceMargin = 256 << 20
+
+// checkFreeSpace makes sure the destination and the tier roots can take
+// every byte the items will write, so a run stops before it starts rather
+// than halfway through.
+func checkFreeSpace(items []planItem) error {
+	type filesystem struct {
+		root   string
+		free   uint64
+		needed uint64
+	}
+	filesystems := make(map[string]*filesystem)
+	unknown := make(map[string]bool)
+	for _, item := range items {
+		root := rootOf(item.Dest)
+		// overflow roots are chosen by their free space while planning
+		if len(c.Overflow.Value()) > 0 && !isTierRoot(root) {
+			continue
+		}
+		dir := existingParent(root)
+		destFS := filesystemOf(dir)
+		fs, ok := filesystems[destFS]
+		if !ok {
+			if unknown[destFS] {
+				continue
+			}
+			free, known := freeSpace(dir)
+			if !known {
+				log.Warnf("cannot tell the free space of %s, not checking it", root)
+				unknown[destFS] = true
+				continue
+			}
+			fs = &filesystem{root: root, free: free}
+			filesystems[destFS] = fs
+		}
+		for _, f := range item.files() {
+			info, err := os.Stat(f.Source)
+			if err != nil {
+				continue
+			}
+			// a move within one filesystem is a rename
+			if c.Mode == "move" && filesystemOf(f.Source) == destFS {
+				continue
+			}
+			fs.needed += uint64(info.Size())
+		}
+	}
+	for _, fs := range filesystems {
+		if fs.needed+freeSpaceMargin > fs.free {
+			return fmt.Errorf("not enough space in %s: %s needed, %s free",
+				fs.root, formatBytes(fs.needed+freeSpaceMargin), formatBytes(fs.free))
+		}
+	}
+	return nil
+}
+
+// filesystemOf names the filesystem holding path: its device where the
+// platform tells it, else its volume name, else the path itself.
+func filesystemOf(path string) string {
+	if info, err := os.Stat(path); err == nil {
+		if id, ok := fileIdentity(info); ok {
+			return strconv.FormatUint(id.dev, 10)
+		}
+	}
+	if volume := filepath.VolumeName(absPath(path)); volume != "" {
+		return strings.ToUpper(volume)
+	}
+	return absPath(path)
+}
+
+// existingParent is the closest directory of path that already exists.
+func existingParent(path string) string {
+	path = absPath(path)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
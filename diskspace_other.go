@@ -0,0 +1,7 @@
+//go:build !linux && !darwin && !freebsd && !dragonfly && !windows
+
+package main
+
+func freeSpace(_ string) (uint64, bool) {
+	return 0, false
+}
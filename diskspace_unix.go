@@ -0,0 +1,16 @@
+//go:build linux || darwin || freebsd || dragonfly
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// freeSpace returns the bytes available to unprivileged users on the
+// filesystem holding path.
+func freeSpace(path string) (uint64, bool) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return 0, false
+	}
+	// negative on FreeBSD once the reserve is in use
+	return uint64(max(int64(st.Bavail), 0)) * uint64(st.Bsize), true
+}
@@ -0,0 +1,17 @@
+package main
+
+import "golang.org/x/sys/windows"
+
+// freeSpace returns the bytes available to the current user on the volume
+// holding path, which honours disk quotas.
+func freeSpace(path string) (uint64, bool) {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, false
+	}
+	var available, total, free uint64
+	if err = windows.GetDiskFreeSpaceEx(name, &available, &total, &free); err != nil {
+		return 0, false
+	}
+	return available, true
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+const classDocument = "document"
+
+// defaultDocumentTemplate keeps scans out of the photos unless the config
+// routes the document class elsewhere.
+const defaultDocumentTemplate = "Documents/{year}/{month}/{name}"
+
+var docTypes = map[string]bool{
+	"pdf": true,
+}
+
+// pdfScanSize is how much of the start and end of a PDF is searched for its
+// info dictionary, which writers put at either end.
+const pdfScanSize = 1 << 20
+
+var (
+	pdfCreationDate = regexp.MustCompile(`/CreationDate\s*\(D:(\d{4})(\d{2})?(\d{2})?(\d{2})?(\d{2})?(\d{2})?`)
+	// names given by scanner apps, e.g. "Scan 2023-01-01 12.34.56",
+	// "CamScanner 01-31-2023 12.34" or "Scanned_20230101-1234"
+	docNamePatterns = []struct {
+		Pattern *regexp.Regexp
+		Layout  string
+	}{
+		{regexp.MustCompile(`\d{4}-\d{2}-\d{2}[ _]\d{2}\.\d{2}\.\d{2}`), "2006-01-02 15.04.05"},
+		{regexp.MustCompile(`\d{2}-\d{2}-\d{4} \d{2}\.\d{2}`), "01-02-2006 15.04"},
+		{regexp.MustCompile(`\d{8}-\d{4}`), "20060102-1504"},
+		{regexp.MustCompile(`\d{4}-\d{2}-\d{2}`), "2006-01-02"},
+	}
+)
+
+// matchDocument dates a document from its PDF metadata, then from the name
+// a scanner app gave it.
+func matchDocument(file string) *mediaMeta {
+	if tm, ok := pdfDate(file); ok {
+		return &mediaMeta{Time: tm}
+	}
+	base := filepath.Base(file)
+	for _, p := range docNamePatterns {
+		match := p.Pattern.FindString(base)
+		if match == "" {
+			continue
+		}
+		if len(match) == len("2006-01-02_15.04.05") && match[10] == '_' {
+			match = match[:10] + " " + match[11:]
+		}
+		if tm, err := time.ParseInLocation(p.Layout, match, time.Local); err == nil {
+			return &mediaMeta{Time: tm}
+		}
+	}
+	return nil
+}
+
+func pdfDate(file string) (time.Time, bool) {
+	f, err := os.Open(file)
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	chunks := []int64{0}
+	if info.Size() > pdfScanSize {
+		chunks = append(chunks, info.Size()-pdfScanSize)
+	}
+	buf := make([]byte, pdfScanSize)
+	for _, offset := range chunks {
+		n, err := f.ReadAt(buf, offset)
+		if err != nil && err != io.EOF {
+			return time.Time{}, false
+		}
+		m := pdfCreationDate.FindSubmatch(buf[:n])
+		if m == nil {
+			continue
+		}
+		// missing parts of the date default to the start of the period
+		parts := []string{string(m[1]), "01", "01", "00", "00", "00"}
+		for i := 2; i <= 6; i++ {
+			if len(m[i]) > 0 {
+				parts[i-1] = string(m[i])
+			}
+		}
+		tm, err := time.ParseInLocation("20060102150405",
+			parts[0]+parts[1]+parts[2]+parts[3]+parts[4]+parts[5], time.Local)
+		if err == nil {
+			return tm, true
+		}
+	}
+	return time.Time{}, false
+}
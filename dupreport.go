@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// fclonesVersion is the fclones release whose text report is imitated, so
+// fclones remove, link and dedupe accept the report.
+const fclonesVersion = "0.29.3"
+
+// writeDuplicateReport writes the groups as a report of --format fclones or
+// rmlint to out, for the cleanup scripts built around those tools.
+func writeDuplicateReport(out io.Writer, groups []duplicateGroup) error {
+	switch c.Format {
+	case "fclones":
+		return writeFclonesReport(out, groups)
+	case "rmlint":
+		return writeRmlintReport(out, groups)
+	}
+	return fmt.Errorf("--format is fclones or rmlint, not %q", c.Format)
+}
+
+func writeFclonesReport(out io.Writer, groups []duplicateGroup) error {
+	var total, redundant, totalFiles, redundantFiles int64
+	for _, group := range groups {
+		n := int64(len(group.Files))
+		total += group.Size * n
+		totalFiles += n
+		redundant += group.Size * (n - 1)
+		redundantFiles += n - 1
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Report by fclones %s\n", fclonesVersion)
+	fmt.Fprintf(&b, "# Timestamp: %s\n", time.Now().Format("2006-01-02 15:04:05.000 -0700"))
+	fmt.Fprintf(&b, "# Command: %s\n", strings.Join(os.Args, " "))
+	fmt.Fprintf(&b, "# Base dir: %s\n", absPath(c.Destination))
+	fmt.Fprintf(&b, "# Total: %d B (%s) in %d files in %d groups\n", total, decimalSize(total), totalFiles, len(groups))
+	fmt.Fprintf(&b, "# Redundant: %d B (%s) in %d files\n", redundant, decimalSize(redundant), redundantFiles)
+	fmt.Fprintf(&b, "# Missing: 0 B (0 B) in 0 files\n")
+	for _, group := range groups {
+		// fclones hashes are 128 bits
+		fmt.Fprintf(&b, "%s, %d B (%s) * %d:\n", group.Hash[:32], group.Size, decimalSize(group.Size), len(group.Files))
+		for _, file := range group.Files {
+			fmt.Fprintf(&b, "    %s\n", absPath(file))
+		}
+	}
+	_, err := io.WriteString(out, b.String())
+	return err
+}
+
+// decimalSize formats a size the way fclones does, in powers of 1000.
+func decimalSize(size int64) string {
+	if size < 1000 {
+		return fmt.Sprintf("%d B", size)
+	}
+	n := float64(size)
+	unit := 0
+	for n >= 1000 && unit < 4 {
+		n /= 1000
+		unit++
+	}
+	return fmt.Sprintf("%.1f %cB", n, "KMGT"[unit-1])
+}
+
+// writeRmlintReport writes an rmlint.json: a header, one entry per file with
+// the first of each group as the original, and a footer, as rmlint --replay
+// reads it.
+func writeRmlintReport(out io.Writer, groups []duplicateGroup) error {
+	report := []interface{}{map[string]interface{}{
+		"description":   "rmlint json-dump of lint files",
+		"cwd":           absPath("."),
+		"args":          strings.Join(os.Args, " "),
+		"version":       "2.10.2",
+		"rev":           "",
+		"progress":      0,
+		"checksum_type": "sha256",
+	}}
+	var files, duplicates, lint int64
+	id := 0
+	for _, group := range groups {
+		for i, file := range group.Files {
+			id++
+			entry := map[string]interface{}{
+				"id":          id,
+				"type":        "duplicate_file",
+				"progress":    100,
+				"checksum":    group.Hash,
+				"path":        absPath(file),
+				"size":        group.Size,
+				"depth":       strings.Count(absPath(file), string(os.PathSeparator)),
+				"is_original": i == 0,
+			}
+			if info, err := os.Stat(file); err == nil {
+				entry["mtime"] = float64(info.ModTime().UnixNano()) / 1e9
+				if fid, ok := fileIdentity(info); ok {
+					entry["inode"], entry["disk_id"] = fid.ino, fid.dev
+				}
+			}
+			report = append(report, entry)
+			files++
+			if i > 0 {
+				duplicates++
+				lint += group.Size
+			}
+		}
+	}
+	report = append(report, map[string]interface{}{
+		"aborted":         false,
+		"progress":        100,
+		"total_files":     files,
+		"ignored_files":   0,
+		"ignored_folders": 0,
+		"duplicates":      duplicates,
+		"duplicate_sets":  len(groups),
+		"total_lint_size": lint,
+	})
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// Encrypted files are AES-256-GCM in chunks, so multi-gigabyte videos never
+// have to fit in memory: a magic, a random nonce prefix, then every chunk
+// sealed with the prefix and its number as nonce. The last chunk is sealed
+// as such, so a truncated file does not decrypt. The plaintext starts with
+// the path of the file in the library, which is how decrypt restores files
+// whose names were obfuscated.
+const (
+	encMagic     = "MTE1"
+	encExt       = ".enc"
+	encChunkSize = 1 << 20
+)
+
+var decryptCommand = &cli.Command{
+	Name:  "decrypt",
+	Usage: "restore the files an encrypted sync wrote to a backup",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:        "key",
+			Aliases:     []string{"k"},
+			Destination: &c.EncryptKey,
+			Usage:       "the key file the backup was encrypted with",
+			Required:    true,
+		},
+		&cli.StringFlag{
+			Name:        "source",
+			Aliases:     []string{"s"},
+			Destination: &c.Source,
+			Usage:       "the encrypted backup",
+			Required:    true,
+		},
+		&cli.StringFlag{
+			Name:        "dest",
+			Aliases:     []string{"d"},
+			Destination: &c.Destination,
+			Usage:       "where the library is restored to",
+			Required:    true,
+		},
+	},
+	Action: decryptBackup,
+}
+
+// encryptionKeys are derived from the key file, whatever its length: one
+// to encrypt with and one to obfuscate names with.
+type encryptionKeys struct {
+	data, names []byte
+}
+
+func loadEncryptionKeys(keyFile string) (*encryptionKeys, error) {
+	secret, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading key file: %w", err)
+	}
+	if len(secret) < 16 {
+		return nil, fmt.Errorf("key file %s is too short, use e.g. 32 random bytes", keyFile)
+	}
+	derive := func(purpose string) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(purpose))
+		return mac.Sum(nil)
+	}
+	return &encryptionKeys{data: derive("media_tool data"), names: derive("media_tool names")}, nil
+}
+
+func (k *encryptionKeys) mac(parts ...string) string {
+	mac := hmac.New(sha256.New, k.names)
+	mac.Write([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// encryptingTarget encrypts files before another target stores them.
+type encryptingTarget struct {
+	inner     syncTarget
+	keys      *encryptionKeys
+	obfuscate bool
+}
+
+func newEncryptingTarget(inner syncTarget) (syncTarget, error) {
+	keys, err := loadEncryptionKeys(c.EncryptKey)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptingTarget{inner: inner, keys: keys, obfuscate: c.ObfuscateNames}, nil
+}
+
+// Put encrypts file into the library before handing it on. The encrypted
+// copy is named after the version of the file and kept until it is stored,
+// so a broken transfer resumes with the same bytes.
+func (t *encryptingTarget) Put(rel, file string) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	staged := filepath.Join(c.Destination, metaDirName, "encrypted",
+		t.keys.mac(rel, strconv.FormatInt(info.Size(), 10), strconv.FormatInt(info.ModTime().UnixNano(), 10))+encExt)
+	if !fileExists(staged) {
+		if err = encryptFile(t.keys, rel, file, staged); err != nil {
+			return err
+		}
+	}
+	stored := rel + encExt
+	if t.obfuscate {
+		// two levels keep folders of a large library small
+		name := t.keys.mac(rel)
+		stored = path.Join(name[:2], name+encExt)
+	}
+	if err = t.inner.Put(stored, staged); err != nil {
+		return err
+	}
+	return os.Remove(staged)
+}
+
+func encryptFile(keys *encryptionKeys, rel, file, dest string) error {
+	if _, err := createDestinationDir(dest); err != nil {
+		return err
+	}
+	source, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	tmp := dest + partSuffix
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	w := bufio.NewWriter(out)
+
+	block, err := aes.NewCipher(keys.data)
+	if err != nil {
+		return err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, len(encMagic)+8)
+	copy(header, encMagic)
+	if _, err = rand.Read(header[len(encMagic):]); err != nil {
+		return err
+	}
+	if _, err = w.Write(header); err != nil {
+		return err
+	}
+
+	name := make([]byte, 2, 2+len(rel))
+	binary.BigEndian.PutUint16(name, uint16(len(rel)))
+	plain := io.MultiReader(strings.NewReader(string(append(name, rel...))), source)
+
+	current := make([]byte, encChunkSize)
+	next := make([]byte, encChunkSize)
+	n, err := io.ReadFull(plain, current)
+	for counter := uint32(0); ; counter++ {
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		// a full chunk is only the last one if nothing follows it
+		last := err != nil
+		var m int
+		if !last {
+			m, err = io.ReadFull(plain, next)
+			last = m == 0 && err == io.EOF
+		}
+		sealed := gcm.Seal(nil, chunkNonce(header, counter), current[:n], chunkAAD(header, last))
+		if _, werr := w.Write(sealed); werr != nil {
+			return werr
+		}
+		if last {
+			break
+		}
+		current, next = next, current
+		n = m
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
+
+func chunkNonce(header []byte, counter uint32) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, header[len(encMagic):])
+	binary.BigEndian.PutUint32(nonce[8:], counter)
+	return nonce
+}
+
+func chunkAAD(header []byte, last bool) []byte {
+	aad := append([]byte{}, header...)
+	if last {
+		return append(aad, 1)
+	}
+	return append(aad, 0)
+}
+
+// decryptFile writes the plaintext of an encrypted file below root, under
+// the path stored in it, and returns that path.
+func decryptFile(keys *encryptionKeys, file, root string) (string, error) {
+	in, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	r := bufio.NewReader(in)
+
+	header := make([]byte, len(encMagic)+8)
+	if _, err = io.ReadFull(r, header); err != nil || string(header[:len(encMagic)]) != encMagic {
+		return "", fmt.Errorf("%s is not encrypted by media_tool", file)
+	}
+	block, err := aes.NewCipher(keys.data)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	var out *os.File
+	var w *bufio.Writer
+	var dest string
+	pending := make([]byte, 0, 2)
+	sealed := make([]byte, encChunkSize+gcm.Overhead())
+	for counter := uint32(0); ; counter++ {
+		n, err := io.ReadFull(r, sealed)
+		if err == io.EOF {
+			return "", fmt.Errorf("%s is truncated", file)
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return "", err
+		}
+		last := err == io.ErrUnexpectedEOF
+		if !last {
+			_, err = r.Peek(1)
+			last = err == io.EOF
+		}
+		plain, err := gcm.Open(nil, chunkNonce(header, counter), sealed[:n], chunkAAD(header, last))
+		if err != nil {
+			return "", fmt.Errorf("%s does not decrypt with this key or is damaged", file)
+		}
+
+		if out == nil {
+			// the stored path comes first, possibly across chunks
+			pending = append(pending, plain...)
+			if len(pending) < 2 || len(pending) < 2+int(binary.BigEndian.Uint16(pending)) {
+				if last {
+					return "", fmt.Errorf("%s is truncated", file)
+				}
+				continue
+			}
+			size := int(binary.BigEndian.Uint16(pending))
+			rel := filepath.FromSlash(string(pending[2 : 2+size]))
+			if !filepath.IsLocal(rel) {
+				return "", fmt.Errorf("%s stores the unsafe path %q", file, rel)
+			}
+			dest = filepath.Join(root, rel)
+			if _, err = createDestinationDir(dest); err != nil {
+				return "", err
+			}
+			if out, err = os.Create(dest + partSuffix); err != nil {
+				return "", err
+			}
+			defer out.Close()
+			w = bufio.NewWriter(out)
+			plain = pending[2+size:]
+		}
+		if _, err = w.Write(plain); err != nil {
+			return "", err
+		}
+		if last {
+			break
+		}
+	}
+	if err = w.Flush(); err != nil {
+		return "", err
+	}
+	if err = out.Close(); err != nil {
+		return "", err
+	}
+	return dest, os.Rename(dest+partSuffix, dest)
+}
+
+func decryptBackup(_ *cli.Context) error {
+	keys, err := loadEncryptionKeys(c.EncryptKey)
+	if err != nil {
+		return err
+	}
+	files, err := walkDirectory(c.Source)
+	if err != nil {
+		return err
+	}
+	restored, failed := 0, 0
+	for _, file := range files {
+		if !strings.HasSuffix(file, encExt) {
+			continue
+		}
+		dest, err := decryptFile(keys, file, c.Destination)
+		if err != nil {
+			log.Errorf("error decrypting %s: %v", file, err)
+			failed++
+			continue
+		}
+		log.Debugf("decrypted %s -> %s", file, dest)
+		restored++
+	}
+	log.Infof("restored %d files to %s, %d failed", restored, c.Destination, failed)
+	if failed > 0 {
+		return errors.New("some files were not restored")
+	}
+	return nil
+}
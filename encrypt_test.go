@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testKeys(t *testing.T) *encryptionKeys {
+	t.Helper()
+	keyFile := filepath.Join(t.TempDir(), "key")
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, secret, 0600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := loadEncryptionKeys(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+// encryptTestFile encrypts size random bytes stored as rel and returns the
+// plaintext and the encrypted file.
+func encryptTestFile(t *testing.T, keys *encryptionKeys, rel string, size int) ([]byte, string) {
+	t.Helper()
+	dir := t.TempDir()
+	plain := make([]byte, size)
+	if _, err := rand.Read(plain); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "plain")
+	if err := os.WriteFile(file, plain, 0644); err != nil {
+		t.Fatal(err)
+	}
+	encrypted := filepath.Join(dir, "out", "file"+encExt)
+	if err := encryptFile(keys, rel, file, encrypted); err != nil {
+		t.Fatal(err)
+	}
+	return plain, encrypted
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	keys := testKeys(t)
+	rel := "2020/05/IMG_0001.JPG"
+	// the stored path takes the first bytes of the first chunk
+	header := 2 + len(rel)
+	sizes := map[string]int{
+		"empty":                 0,
+		"small":                 100,
+		"one full chunk":        encChunkSize - header,
+		"one byte over a chunk": encChunkSize - header + 1,
+		"several chunks":        3*encChunkSize + 17,
+	}
+	for name, size := range sizes {
+		t.Run(name, func(t *testing.T) {
+			plain, encrypted := encryptTestFile(t, keys, rel, size)
+			root := t.TempDir()
+			dest, err := decryptFile(keys, encrypted, root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := filepath.Join(root, filepath.FromSlash(rel)); dest != want {
+				t.Errorf("restored to %s, want %s", dest, want)
+			}
+			got, err := os.ReadFile(dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Errorf("restored %d bytes differ from the %d bytes encrypted", len(got), len(plain))
+			}
+		})
+	}
+}
+
+func TestDecryptDamaged(t *testing.T) {
+	keys := testKeys(t)
+	rel := "2021/clip.mp4"
+	sealedChunk := encChunkSize + 16
+
+	damage := map[string]func([]byte) []byte{
+		// a flipped byte in the tag of the last chunk
+		"tampered last chunk": func(data []byte) []byte {
+			data[len(data)-1] ^= 1
+			return data
+		},
+		"tampered first chunk": func(data []byte) []byte {
+			data[len(encMagic)+8+10] ^= 1
+			return data
+		},
+		// without its last chunk the one before claims to be the last
+		"last chunk dropped": func(data []byte) []byte {
+			return data[:len(encMagic)+8+2*sealedChunk]
+		},
+		"cut in the last chunk": func(data []byte) []byte {
+			return data[:len(data)-5]
+		},
+	}
+	for name, fn := range damage {
+		t.Run(name, func(t *testing.T) {
+			_, encrypted := encryptTestFile(t, keys, rel, 2*encChunkSize+1000)
+			data, err := os.ReadFile(encrypted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = os.WriteFile(encrypted, fn(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			root := t.TempDir()
+			if _, err = decryptFile(keys, encrypted, root); err == nil {
+				t.Fatal("a damaged file decrypted")
+			}
+			if fileExists(filepath.Join(root, filepath.FromSlash(rel))) {
+				t.Error("a damaged file was restored")
+			}
+		})
+	}
+}
+
+func TestDecryptWrongKey(t *testing.T) {
+	_, encrypted := encryptTestFile(t, testKeys(t), "a.jpg", 100)
+	if _, err := decryptFile(testKeys(t), encrypted, t.TempDir()); err == nil {
+		t.Fatal("decrypted with another key")
+	}
+}
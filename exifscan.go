@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	tagModel            = 0x0110
+	tagExifIFD          = 0x8769
+	tagDateTimeOriginal = 0x9003
+)
+
+var exifDatePattern = regexp.MustCompile(`\d{4}:\d{2}:\d{2} \d{2}:\d{2}:\d{2}`)
+
+// scanExif is a forgiving reader for the JPEGs goexif gives up on, such as
+// ones with broken maker notes. It walks the IFDs itself, passing over the
+// entries it cannot read, and as a last resort takes the earliest date
+// written anywhere in the EXIF segment.
+func scanExif(file string) *mediaMeta {
+	tiff := jpegExifSegment(file)
+	if len(tiff) < 8 {
+		return nil
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil
+	}
+
+	meta := &mediaMeta{}
+	ifd0 := readIFD(tiff, order, order.Uint32(tiff[4:8]))
+	meta.Model = ifd0.text(tiff, order, tagModel)
+	if ptr, ok := ifd0[tagExifIFD]; ok {
+		exifIFD := readIFD(tiff, order, order.Uint32(ptr.value[:]))
+		if tm, err := time.Parse(layout, exifIFD.text(tiff, order, tagDateTimeOriginal)); err == nil {
+			meta.Time = tm
+		}
+	}
+	if meta.Time.IsZero() {
+		for _, found := range exifDatePattern.FindAll(tiff, -1) {
+			tm, err := time.Parse(layout, string(found))
+			if err == nil && tm.Year() > 1900 && (meta.Time.IsZero() || tm.Before(meta.Time)) {
+				meta.Time = tm
+			}
+		}
+	}
+	if meta.Time.IsZero() {
+		return nil
+	}
+	return meta
+}
+
+// jpegExifSegment returns the TIFF data of the EXIF APP1 segment of a JPEG.
+func jpegExifSegment(file string) []byte {
+	var tiff []byte
+	walkJPEG(file, func(marker byte, data []byte) bool {
+		if marker == 0xe1 && strings.HasPrefix(string(data), "Exif\x00\x00") {
+			tiff = data[6:]
+			return true
+		}
+		return false
+	})
+	return tiff
+}
+
+// walkJPEG calls fn with every segment of a JPEG before the image data,
+// until fn returns true.
+func walkJPEG(file string, fn func(marker byte, data []byte) (stop bool)) {
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	_ = eachJpegSegment(f, func(marker byte, offset, size int64) bool {
+		data := make([]byte, size)
+		if _, err := f.ReadAt(data, offset); err != nil {
+			return true
+		}
+		return fn(marker, data)
+	})
+}
+
+type ifdEntry struct {
+	typ   uint16
+	count uint32
+	value [4]byte
+}
+
+type ifd map[uint16]ifdEntry
+
+// readIFD reads the entries of the IFD at offset, as many as fit in data.
+func readIFD(data []byte, order binary.ByteOrder, offset uint32) ifd {
+	entries := make(ifd)
+	if int64(offset)+2 > int64(len(data)) {
+		return entries
+	}
+	count := int(order.Uint16(data[offset:]))
+	for i := 0; i < count; i++ {
+		at := int(offset) + 2 + i*12
+		if at+12 > len(data) {
+			break
+		}
+		var entry ifdEntry
+		entry.typ = order.Uint16(data[at+2:])
+		entry.count = order.Uint32(data[at+4:])
+		copy(entry.value[:], data[at+8:at+12])
+		entries[order.Uint16(data[at:])] = entry
+	}
+	return entries
+}
+
+// text returns an ASCII value of the IFD, or "" if it is missing or out of
+// bounds.
+func (d ifd) text(data []byte, order binary.ByteOrder, tag uint16) string {
+	entry, ok := d[tag]
+	if !ok || entry.typ != 2 {
+		return ""
+	}
+	value := entry.value[:]
+	if entry.count > 4 {
+		offset := int64(order.Uint32(entry.value[:]))
+		if offset+int64(entry.count) > int64(len(data)) {
+			return ""
+		}
+		value = data[offset : offset+int64(entry.count)]
+	} else {
+		value = value[:entry.count]
+	}
+	return strings.TrimSpace(strings.TrimRight(string(value), "\x00"))
+}
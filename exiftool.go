@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// exiftoolDateTags are tried in order for the capture time.
+var exiftoolDateTags = []string{"DateTimeOriginal", "CreateDate", "MediaCreateDate"}
+
+func exiftoolPath() string {
+	if y.Exiftool != "" {
+		return y.Exiftool
+	}
+	return "exiftool"
+}
+
+func runExiftool(args ...string) ([]byte, error) {
+	path, err := exec.LookPath(exiftoolPath())
+	if err != nil {
+		return nil, fmt.Errorf("exiftool not found: %w", err)
+	}
+	return exec.Command(path, args...).Output()
+}
+
+// readExiftool asks exiftool for the model and capture time of a file,
+// for the formats goexif cannot read.
+func readExiftool(file string) *mediaMeta {
+	args := []string{"-json", "-Model", "-SubSecTimeOriginal", "-SerialNumber", "-LensSerialNumber"}
+	for _, tag := range exiftoolDateTags {
+		args = append(args, "-"+tag)
+	}
+	output, err := runExiftool(append(args, file)...)
+	if err != nil {
+		log.Debugf("error running exiftool on %s: %v", file, err)
+		return nil
+	}
+
+	// numbers are kept as written, a serial of digits is no float
+	var results []map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(output))
+	dec.UseNumber()
+	if err = dec.Decode(&results); err != nil || len(results) == 0 {
+		log.Debugf("error parsing exiftool output for %s: %v", file, err)
+		return nil
+	}
+	fields := results[0]
+
+	for _, tag := range exiftoolDateTags {
+		value, ok := fields[tag].(string)
+		// values may carry sub-seconds or a zone after the fixed part
+		if !ok || len(value) < len(layout) {
+			continue
+		}
+		tm, err := time.Parse(layout, value[:len(layout)])
+		if err != nil || tm.Year() < 1900 {
+			continue
+		}
+		if subSec, ok := fields["SubSecTimeOriginal"]; ok && tag == "DateTimeOriginal" {
+			tm = tm.Add(parseSubSec(fmt.Sprint(subSec)))
+		}
+		meta := &mediaMeta{Time: tm}
+		if model, ok := fields["Model"]; ok {
+			meta.Model = fmt.Sprint(model)
+		}
+		if serial, ok := fields["SerialNumber"]; ok {
+			meta.Serial = fmt.Sprint(serial)
+		}
+		if serial, ok := fields["LensSerialNumber"]; ok {
+			meta.LensSerial = fmt.Sprint(serial)
+		}
+		return meta
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// rewriteExifTime replaces the capture time strings in the EXIF block of a
+// JPEG in place. EXIF dates are fixed width, so nothing else has to move.
+func rewriteExifTime(file string, from, to time.Time) error {
+	f, err := os.OpenFile(file, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	offset, size, err := findExifSegment(f)
+	if err != nil {
+		return err
+	}
+	segment := make([]byte, size)
+	if _, err = f.ReadAt(segment, offset); err != nil {
+		return err
+	}
+
+	old := []byte(from.Format(layout))
+	if !bytes.Contains(segment, old) {
+		return fmt.Errorf("capture time %s not found in EXIF", from.Format(layout))
+	}
+	segment = bytes.ReplaceAll(segment, old, []byte(to.Format(layout)))
+
+	if _, err = f.WriteAt(segment, offset); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// findExifSegment returns the offset and size of the APP1 Exif segment
+// payload of a JPEG.
+func findExifSegment(r io.ReaderAt) (int64, int64, error) {
+	return findJpegSegment(r, 0xE1, "Exif\x00\x00")
+}
+
+// findJpegSegment returns the offset and size of the payload of the first
+// segment with the given marker whose payload starts with prefix.
+func findJpegSegment(r io.ReaderAt, segmentMarker byte, prefix string) (int64, int64, error) {
+	var found, length int64
+	segmentHeader := make([]byte, len(prefix))
+	err := eachJpegSegment(r, func(marker byte, offset, size int64) bool {
+		if marker != segmentMarker {
+			return false
+		}
+		if _, err := r.ReadAt(segmentHeader, offset); err == nil && string(segmentHeader) == prefix {
+			found, length = offset, size
+			return true
+		}
+		return false
+	})
+	return found, length, err
+}
+
+// eachJpegSegment calls fn with the marker and the offset and size of the
+// payload of every segment of a JPEG before the image data, until fn
+// returns true. It fails when fn never does.
+func eachJpegSegment(r io.ReaderAt, fn func(marker byte, offset, size int64) (stop bool)) error {
+	header := make([]byte, 2)
+	if _, err := r.ReadAt(header, 0); err != nil || header[0] != 0xFF || header[1] != 0xD8 {
+		return fmt.Errorf("not a JPEG file")
+	}
+
+	marker := make([]byte, 4)
+	pos := int64(2)
+	for {
+		if _, err := r.ReadAt(marker, pos); err != nil {
+			return fmt.Errorf("segment not found: %w", err)
+		}
+		if marker[0] != 0xFF {
+			return fmt.Errorf("malformed JPEG segment at %d", pos)
+		}
+		// start of scan: no metadata segments follow
+		if marker[1] == 0xDA || marker[1] == 0xD9 {
+			return fmt.Errorf("segment not found")
+		}
+		length := int64(binary.BigEndian.Uint16(marker[2:]))
+		if length < 2 {
+			return fmt.Errorf("malformed JPEG segment at %d", pos)
+		}
+		if fn(marker[1], pos+4, length-2) {
+			return nil
+		}
+		pos += 2 + length
+	}
+}
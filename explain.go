@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+var explainCommand = &cli.Command{
+	Name:      "explain",
+	Usage:     "show every date strategy tried on a file and where the file would go",
+	ArgsUsage: "<file>",
+	Flags:     fileFlagsWithout("mode", "source", "files-from"),
+	Action:    explain,
+}
+
+func explain(ctx *cli.Context) error {
+	file := ctx.Args().First()
+	if file == "" {
+		return fmt.Errorf("explain needs a file")
+	}
+	if !fileExists(file) {
+		return fmt.Errorf("%s does not exist", file)
+	}
+	if err := setupConsole(); err != nil {
+		return err
+	}
+	if err := loadConfigFile(); err != nil {
+		return err
+	}
+	if len(c.GPX.Value()) > 0 {
+		var err error
+		if track, err = loadGPX(c.GPX.Value()); err != nil {
+			return err
+		}
+	}
+
+	if !isMediaFile(file) {
+		log.Infof("%s is not a media file and would not be scanned", file)
+	}
+	if primary := primaryOf(file); primary != "" {
+		log.Infof("%s travels with %s and is named after it", file, primary)
+		return nil
+	}
+	order := strategyOrder()
+	for _, s := range order {
+		if s.Applies != nil && !s.Applies(file) {
+			log.Infof("%-8s does not apply", s.Name)
+			continue
+		}
+		meta := s.Match(file)
+		if meta == nil {
+			log.Infof("%-8s no date", s.Name)
+			continue
+		}
+		log.Infof("%-8s %s%s", s.Name, meta.Time.Format(time.DateTime), describeMeta(meta))
+	}
+	for _, s := range dateStrategies {
+		if _, used := findStrategy(order, s.Name); !used {
+			log.Infof("%-8s not used", s.Name)
+		}
+	}
+
+	newPath, meta, err := processImage(file)
+	if err != nil {
+		log.Infof("decision: not placed, %v", err)
+		return nil
+	}
+	newPath = filepath.Join(meta.Root, newPath)
+	log.Infof("decision: dated %s by %s%s", meta.Time.Format(time.DateTime), meta.Strategy, describeMeta(meta))
+	if !meta.OriginalTime.IsZero() {
+		log.Infof("  clock corrected by %s from %s", meta.Time.Sub(meta.OriginalTime), meta.OriginalTime.Format(time.DateTime))
+	}
+	log.Infof("  -> %s", newPath)
+	if fileExists(newPath) {
+		log.Infof("  which already exists")
+	}
+	return nil
+}
+
+// describeMeta lists what else than the date a strategy learned.
+func describeMeta(meta *mediaMeta) string {
+	var s string
+	if meta.Model != "" {
+		s += ", model " + meta.Model
+	}
+	if meta.Event != "" {
+		s += ", event " + meta.Event
+	}
+	if meta.App != "" {
+		s += ", app " + meta.App
+	}
+	if meta.Class != "" {
+		s += ", class " + meta.Class
+	}
+	return s
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"os/exec"
+)
+
+// exportPreset describes the lightweight copy made by the export mode.
+type exportPreset struct {
+	// LongEdge is the maximum size of the longer side of an image.
+	LongEdge int `yaml:"long_edge"`
+	Quality  int `yaml:"quality"`
+	// FfmpegArgs are the output options used to transcode videos; videos
+	// are copied as they are when empty.
+	FfmpegArgs []string `yaml:"ffmpeg_args"`
+	Ffmpeg     string   `yaml:"ffmpeg"`
+}
+
+func currentPreset() (exportPreset, error) {
+	preset, ok := y.Presets[c.Preset]
+	if !ok {
+		return exportPreset{}, fmt.Errorf("unknown export preset %q", c.Preset)
+	}
+	return preset, nil
+}
+
+// exportFile writes a resized or transcoded version of src to dst,
+// falling back to a plain copy for formats it cannot convert.
+func exportFile(src, dst string) error {
+	preset, err := currentPreset()
+	if err != nil {
+		return err
+	}
+
+	ext := getFileExtension(src, false)
+	switch {
+	case decodableTypes[ext] && preset.LongEdge > 0:
+		return exportImage(src, dst, preset)
+	case videoTypes[ext] && len(preset.FfmpegArgs) > 0:
+		return exportVideo(src, dst, preset)
+	}
+	return copyFile(src, dst)
+}
+
+func exportImage(src, dst string, preset exportPreset) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return fmt.Errorf("error reading source file: %w", err)
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("error decoding %s: %w", src, err)
+	}
+	img = fitLongEdge(img, preset.LongEdge)
+
+	quality := preset.Quality
+	if quality == 0 {
+		quality = jpeg.DefaultQuality
+	}
+
+	var out bytes.Buffer
+	switch format {
+	case "jpeg":
+		if err = jpeg.Encode(&out, img, &jpeg.Options{Quality: quality}); err != nil {
+			return err
+		}
+		// keep the capture date and camera of the original
+		if offset, size, err := findExifSegment(bytes.NewReader(data)); err == nil {
+			encoded := out.Bytes()
+			segment := data[offset-4 : offset+size]
+			withExif := make([]byte, 0, len(encoded)+len(segment))
+			withExif = append(withExif, encoded[:2]...)
+			withExif = append(withExif, segment...)
+			withExif = append(withExif, encoded[2:]...)
+			out = *bytes.NewBuffer(withExif)
+		}
+	case "png":
+		err = png.Encode(&out, img)
+	case "gif":
+		err = gif.Encode(&out, img, nil)
+	default:
+		err = fmt.Errorf("unsupported image format %s", format)
+	}
+	if err != nil {
+		return fmt.Errorf("error encoding %s: %w", dst, err)
+	}
+	return os.WriteFile(dst, out.Bytes(), 0644)
+}
+
+// fitLongEdge scales img down, averaging every source pixel into the
+// destination pixel it falls into, so its longer side is at most longEdge.
+func fitLongEdge(img image.Image, longEdge int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= longEdge && h <= longEdge {
+		return img
+	}
+	dw, dh := longEdge, h*longEdge/w
+	if h > w {
+		dw, dh = w*longEdge/h, longEdge
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for dy := 0; dy < dh; dy++ {
+		y0, y1 := dy*h/dh, (dy+1)*h/dh
+		for dx := 0; dx < dw; dx++ {
+			x0, x1 := dx*w/dw, (dx+1)*w/dw
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride+x0*4 : sy*src.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					sum[0] += int(row[i])
+					sum[1] += int(row[i+1])
+					sum[2] += int(row[i+2])
+					sum[3] += int(row[i+3])
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			p := dst.Pix[dy*dst.Stride+dx*4:]
+			for i := 0; i < 4; i++ {
+				p[i] = uint8(sum[i] / n)
+			}
+		}
+	}
+	return dst
+}
+
+func exportVideo(src, dst string, preset exportPreset) error {
+	ffmpeg := preset.Ffmpeg
+	if ffmpeg == "" {
+		ffmpeg = "ffmpeg"
+	}
+	args := append([]string{"-y", "-loglevel", "error", "-i", src}, preset.FfmpegArgs...)
+	output, err := exec.Command(ffmpeg, append(args, dst)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("error transcoding %s: %w: %s", src, err, bytes.TrimSpace(output))
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// failure is an item that could not be placed, kept so a later run can
+// retry just these files with --from-failures.
+type failure struct {
+	Source string `json:"source"`
+	Dest   string `json:"dest,omitempty"`
+	Reason string `json:"reason"`
+}
+
+var failures = struct {
+	sync.Mutex
+	items []failure
+}{}
+
+func failuresPath() string {
+	return filepath.Join(c.Destination, metaDirName, "failures.json")
+}
+
+func recordFailure(source, dest string, err error) {
+	action(labelFail, "%s: %v", source, err)
+	status.failed()
+	item := failure{Source: absPath(source), Reason: err.Error()}
+	if dest != "" {
+		item.Dest = absPath(dest)
+	}
+	failures.Lock()
+	failures.items = append(failures.items, item)
+	failures.Unlock()
+}
+
+// writeFailures saves the failures of this run, or removes the file of an
+// earlier run when nothing failed.
+func writeFailures(path string) error {
+	failures.Lock()
+	defer failures.Unlock()
+	if len(failures.items) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err := createParentDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(failures.items, "", "  ")
+	if err != nil {
+		return err
+	}
+	log.Warnf("%d files failed, retry them with --from-failures %s", len(failures.items), path)
+	return os.WriteFile(path, data, 0644)
+}
+
+func readFailures(path string) ([]failure, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]failure, 0)
+	if err = json.Unmarshal(data, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// streamFailures sends the sources of earlier failures that still exist.
+func streamFailures(items []failure) (<-chan string, func() error) {
+	files := make(chan string)
+	go func() {
+		defer close(files)
+		for _, item := range items {
+			if !fileExists(item.Source) {
+				log.Warnf("skip %s: it no longer exists", item.Source)
+				continue
+			}
+			files <- item.Source
+		}
+	}()
+	return files, func() error {
+		return nil
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// faultRate is the share of filesystem operations --simulate-errors fails.
+var faultRate float64
+
+// injectFault is where writes, verifications, renames and deletions may
+// fail on purpose before doing their work, so the retry and rollback paths
+// can be exercised. It fails with an I/O error like a dying card would.
+var injectFault = func(op, path string) error {
+	if faultRate == 0 || rand.Float64() >= faultRate {
+		return nil
+	}
+	return &os.PathError{Op: "simulated " + op, Path: path, Err: syscall.EIO}
+}
+
+// checkSimulateErrors reads --simulate-errors, a percentage such as 1% or a
+// fraction such as 0.01.
+func checkSimulateErrors() error {
+	if c.SimulateErrors == "" {
+		return nil
+	}
+	rate, err := strconv.ParseFloat(strings.TrimSuffix(c.SimulateErrors, "%"), 64)
+	if strings.HasSuffix(c.SimulateErrors, "%") {
+		rate /= 100
+	}
+	if err != nil || rate < 0 || rate > 1 {
+		return fmt.Errorf("--simulate-errors is a rate such as 1%% or 0.01, not %q", c.SimulateErrors)
+	}
+	faultRate = rate
+	log.Warnf("simulating errors in %s of file operations", c.SimulateErrors)
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+func fileIdentity(_ os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
+
+func hardlinkCount(_ os.FileInfo) uint64 {
+	return 1
+}
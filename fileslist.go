@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// readFileList reads a list of files, one per line or NUL separated as
+// written by find -print0, from path or from stdin when path is "-".
+func readFileList(path string) ([]string, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sep := []byte("\n")
+	if bytes.IndexByte(data, 0) >= 0 {
+		sep = []byte{0}
+	}
+	files := make([]string, 0)
+	for _, line := range bytes.Split(data, sep) {
+		line = bytes.TrimRight(line, "\r")
+		if len(line) > 0 {
+			files = append(files, string(line))
+		}
+	}
+	return files, nil
+}
+
+// streamFileList sends the media files of a list; anything else is skipped.
+func streamFileList(path string) (<-chan string, func() error) {
+	files := make(chan string)
+	done := make(chan error, 1)
+	go func() {
+		defer close(files)
+		list, err := readFileList(path)
+		if err != nil {
+			done <- fmt.Errorf("error reading file list %s: %w", path, err)
+			return
+		}
+		for _, file := range list {
+			if !isMediaFile(file) {
+				log.Infof("skip file: %s", file)
+				continue
+			}
+			files <- file
+		}
+		done <- nil
+	}()
+	return files, func() error {
+		return <-done
+	}
+}
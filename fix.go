@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// opMoved is journaled for files moved within a library by fix.
+const opMoved = "moved"
+
+var fixCommand = &cli.Command{
+	Name:  "fix",
+	Usage: "move misfiled files of an organized library to where they belong",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:        "dir",
+			Aliases:     []string{"d"},
+			Destination: &c.Destination,
+			Usage:       "the library to fix",
+			Required:    true,
+		},
+		&cli.StringFlag{
+			Name:        "config",
+			Aliases:     []string{"c"},
+			Destination: &c.ConfigPath,
+			Usage:       "yaml config file path",
+			DefaultText: "config.yaml",
+			Required:    false,
+		},
+		&cli.BoolFlag{
+			Name:        "dry",
+			Destination: &c.Dry,
+			Usage:       "only show the moves",
+		},
+		&cli.BoolFlag{
+			Name:        "yes",
+			Aliases:     []string{"y"},
+			Destination: &c.Yes,
+			Usage:       "do not ask before each move",
+		},
+	},
+	Action: fix,
+}
+
+func fix(_ *cli.Context) error {
+	if err := loadConfigFile(); err != nil {
+		return err
+	}
+	issues, _, err := misfiledFiles(c.Destination)
+	if err != nil {
+		return err
+	}
+	if len(issues) == 0 {
+		log.Infoln("nothing to fix")
+		return nil
+	}
+	if c.Dry {
+		for _, issue := range issues {
+			log.Infof("file %s -> %s", issue.Source, issue.Dest)
+		}
+		return nil
+	}
+
+	catalog, err = loadIndex(indexPath())
+	if err != nil {
+		return err
+	}
+	j, err := openJournal(journalPath())
+	if err != nil {
+		return err
+	}
+	defer j.Close()
+
+	moved := 0
+	for _, issue := range issues {
+		item := planItem{Source: issue.Source, Dest: issue.Dest}
+		// sidecars always follow their image inside a library
+		companions := assetCompanions(item.Source)
+		if sidecar := findSidecar(item.Source); sidecar != "" {
+			companions = append(companions, sidecar)
+		}
+		for _, companion := range companions {
+			item.Companions = append(item.Companions, planItem{Source: companion, Dest: companionDest(item.Source, companion, item.Dest)})
+		}
+		if err = fixItem(j, item); err != nil {
+			log.Errorf("error fixing %s: %v", item.Source, err)
+			continue
+		}
+		moved++
+	}
+	log.Infof("moved %d of %d misfiled files", moved, len(issues))
+	return catalog.save(indexPath())
+}
+
+func fixItem(j *journal, item planItem) error {
+	for _, f := range item.files() {
+		if fileExists(f.Dest) {
+			return fmt.Errorf("%s already exists", f.Dest)
+		}
+	}
+	if !c.Yes {
+		hit := fmt.Sprintf("Are you sure you want to move\n%s\n->\n%s?\n", item.Source, item.Dest)
+		if !askForConfirmation(hit) {
+			return fmt.Errorf("not confirmed")
+		}
+	}
+	for _, f := range item.files() {
+		if _, err := createDestinationDir(f.Dest); err != nil {
+			return err
+		}
+		if err := moveFile(f.Source, f.Dest); err != nil {
+			j.record(opFailed, f.Source, f.Dest, err)
+			return err
+		}
+		j.record(opMoved, f.Source, f.Dest, nil)
+		catalog.move(f.Source, f.Dest)
+		log.Infof("moved %s -> %s", f.Source, f.Dest)
+	}
+	// the folder it left is only removed when nothing else is in it
+	_ = os.Remove(filepath.Dir(item.Source))
+	return nil
+}
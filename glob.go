@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// isGlob reports whether a source is a pattern such as
+// /mnt/sd/DCIM/**/*.JPG rather than a path that exists.
+func isGlob(source string) bool {
+	if _, err := os.Stat(source); err == nil {
+		return false
+	}
+	return strings.ContainsAny(source, "*?[")
+}
+
+// globRoot is the directory a pattern starts in: its components up to the
+// first one with a wildcard.
+func globRoot(pattern string) string {
+	parts := strings.Split(filepath.ToSlash(pattern), "/")
+	root := make([]string, 0, len(parts))
+	for _, part := range parts[:len(parts)-1] {
+		if strings.ContainsAny(part, "*?[") {
+			break
+		}
+		root = append(root, part)
+	}
+	if len(root) == 0 {
+		return "."
+	}
+	if len(root) == 1 && root[0] == "" {
+		return "/"
+	}
+	return filepath.FromSlash(strings.Join(root, "/"))
+}
+
+// globRegexp translates a pattern to a regular expression matching slash
+// separated paths: ** spans folders, * and ? stay within one.
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	pattern = filepath.ToSlash(filepath.Clean(pattern))
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch ch := pattern[i]; ch {
+		case '*':
+			if strings.HasPrefix(pattern[i:], "**/") {
+				b.WriteString("(?:.*/)?")
+				i += 2
+			} else if strings.HasPrefix(pattern[i:], "**") {
+				b.WriteString(".*")
+				i++
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed [ in %s", pattern)
+			}
+			class := pattern[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end
+		default:
+			b.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// streamGlob walks the root of a pattern and sends the media files that
+// match it.
+func streamGlob(pattern string) (<-chan string, func() error) {
+	files := make(chan string)
+	done := make(chan error, 1)
+	go func() {
+		defer close(files)
+		re, err := globRegexp(pattern)
+		if err != nil {
+			done <- err
+			return
+		}
+		media, wait := streamMediaFiles(globRoot(pattern))
+		for file := range media {
+			if re.MatchString(filepath.ToSlash(filepath.Clean(file))) {
+				files <- file
+			}
+		}
+		done <- wait()
+	}()
+	return files, func() error {
+		return <-done
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// gpxMaxGap is how far a photo may be from the nearest track point and
+// still be placed on the track.
+const gpxMaxGap = 10 * time.Minute
+
+type gpsPoint struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+type trackPoint struct {
+	Time time.Time
+	gpsPoint
+}
+
+// gpxTrack is every point of the loaded GPX files, in time order.
+type gpxTrack []trackPoint
+
+var track gpxTrack
+
+func loadGPX(paths []string) (gpxTrack, error) {
+	points := make(gpxTrack, 0)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var doc struct {
+			Points []struct {
+				Lat  string `xml:"lat,attr"`
+				Lon  string `xml:"lon,attr"`
+				Time string `xml:"time"`
+			} `xml:"trk>trkseg>trkpt"`
+		}
+		if err = xml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", path, err)
+		}
+		for _, p := range doc.Points {
+			tm, err := time.Parse(time.RFC3339, p.Time)
+			if err != nil {
+				continue
+			}
+			lat, errLat := strconv.ParseFloat(p.Lat, 64)
+			lon, errLon := strconv.ParseFloat(p.Lon, 64)
+			if errLat != nil || errLon != nil {
+				continue
+			}
+			points = append(points, trackPoint{Time: tm, gpsPoint: gpsPoint{Lat: lat, Lon: lon}})
+		}
+		log.Infof("load gpx track: %s", path)
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].Time.Before(points[j].Time)
+	})
+	return points, nil
+}
+
+// locate places a capture time on the track, interpolating between the two
+// points around it. Capture times carry no zone and are taken as local.
+func (t gpxTrack) locate(captured time.Time) *gpsPoint {
+	if len(t) == 0 {
+		return nil
+	}
+	tm := time.Date(captured.Year(), captured.Month(), captured.Day(),
+		captured.Hour(), captured.Minute(), captured.Second(), captured.Nanosecond(), time.Local)
+
+	i := sort.Search(len(t), func(i int) bool {
+		return !t[i].Time.Before(tm)
+	})
+	switch {
+	case i == 0:
+		return t.near(0, tm)
+	case i == len(t):
+		return t.near(len(t)-1, tm)
+	}
+	before, after := t[i-1], t[i]
+	span := after.Time.Sub(before.Time)
+	if span > 2*gpxMaxGap {
+		// a hole in the track, only trust a point close by
+		if tm.Sub(before.Time) < after.Time.Sub(tm) {
+			return t.near(i-1, tm)
+		}
+		return t.near(i, tm)
+	}
+	f := 0.0
+	if span > 0 {
+		f = float64(tm.Sub(before.Time)) / float64(span)
+	}
+	return &gpsPoint{
+		Lat: before.Lat + (after.Lat-before.Lat)*f,
+		Lon: before.Lon + (after.Lon-before.Lon)*f,
+	}
+}
+
+func (t gpxTrack) near(i int, tm time.Time) *gpsPoint {
+	gap := tm.Sub(t[i].Time)
+	if gap < 0 {
+		gap = -gap
+	}
+	if gap > gpxMaxGap {
+		return nil
+	}
+	p := t[i].gpsPoint
+	return &p
+}
+
+// writeGPS stores derived coordinates in a file's EXIF through exiftool.
+func writeGPS(file string, p gpsPoint) error {
+	latRef, lonRef := "N", "E"
+	if p.Lat < 0 {
+		latRef = "S"
+	}
+	if p.Lon < 0 {
+		lonRef = "W"
+	}
+	out, err := runExiftool("-overwrite_original", "-q",
+		fmt.Sprintf("-GPSLatitude=%f", abs(p.Lat)), "-GPSLatitudeRef="+latRef,
+		fmt.Sprintf("-GPSLongitude=%f", abs(p.Lon)), "-GPSLongitudeRef="+lonRef,
+		file)
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, out)
+	}
+	return nil
+}
+
+func abs(f float64) float64 {
+	if f < 0 {
+		return -f
+	}
+	return f
+}
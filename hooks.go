@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// hookConfig holds commands run after events, each given as its arguments
+// so file names with spaces need no quoting. Arguments may use the template
+// variables plus {source}, {dest}, {dir} and {destination}; on_run_complete
+// gets {destination}, {source} and {count}.
+type hookConfig struct {
+	OnFileImported []string `yaml:"on_file_imported"`
+	OnRunComplete  []string `yaml:"on_run_complete"`
+}
+
+// importedCount is how many files reached their destination this run.
+var importedCount int
+
+func fileImportedHook(item planItem) {
+	importedCount++
+	if len(y.Hooks.OnFileImported) == 0 {
+		return
+	}
+	vars := map[string]string{}
+	if item.Meta != nil {
+		vars = templateVars(item.Source, item.Meta)
+	}
+	vars["source"] = item.Source
+	vars["dest"] = item.Dest
+	vars["dir"] = filepath.Dir(item.Dest)
+	vars["destination"] = c.Destination
+	runHook("on_file_imported", y.Hooks.OnFileImported, vars)
+}
+
+func runCompleteHook() {
+	if len(y.Hooks.OnRunComplete) == 0 {
+		return
+	}
+	runHook("on_run_complete", y.Hooks.OnRunComplete, map[string]string{
+		"source":      c.Source,
+		"destination": c.Destination,
+		"count":       strconv.Itoa(importedCount),
+	})
+}
+
+func runHook(name string, argv []string, vars map[string]string) {
+	args := make([]string, len(argv))
+	for i, arg := range argv {
+		args[i] = expandVars(arg, vars)
+	}
+	log.Debugf("run %s hook: %s", name, strings.Join(args, " "))
+	out, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		log.Errorf("%s hook failed: %v: %s", name, err, strings.TrimSpace(string(out)))
+	}
+}
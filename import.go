@@ -0,0 +1,38 @@
+package main
+
+import "github.com/urfave/cli/v2"
+
+// importCommand is the file command with safe defaults for new users:
+// copies that are verified, identical files skipped and nothing deleted.
+var importCommand = &cli.Command{
+	Name:  "import",
+	Usage: "copy media into a library with safe defaults",
+	Flags: append(fileFlagsWithout("mode"), &cli.BoolFlag{
+		Name:        "wizard",
+		Destination: &c.Wizard,
+		Usage:       "pick among the mounted cards and drives and import them one after the other, labelled after their volume",
+	}),
+	Action: importMedia,
+}
+
+// fileFlagsWithout returns the flags of the file command but the named ones,
+// for commands built on top of it.
+func fileFlagsWithout(names ...string) []cli.Flag {
+	flags := make([]cli.Flag, 0, len(fileCommand.Flags))
+	for _, flag := range fileCommand.Flags {
+		if !contains(names, flag.Names()[0]) {
+			flags = append(flags, flag)
+		}
+	}
+	return flags
+}
+
+func importMedia(ctx *cli.Context) error {
+	c.Mode = "copy"
+	c.Verify = true
+	c.SkipIdentical = true
+	if c.Wizard {
+		return importWizard(ctx)
+	}
+	return mediaTool(ctx)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// indexEntry describes one file of the organized library.
+type indexEntry struct {
+	Source string `json:"source,omitempty"`
+	// Root is the overflow root holding the file, empty for the destination.
+	Root string `json:"root,omitempty"`
+	// OriginalName is the source file name when it was not kept.
+	OriginalName string    `json:"original_name,omitempty"`
+	Size         int64     `json:"size"`
+	Taken        time.Time `json:"taken"`
+	// ClockCorrected is set once the camera offset was written into the
+	// EXIF of the file, which then must not be corrected again.
+	ClockCorrected bool      `json:"clock_corrected,omitempty"`
+	Model          string    `json:"model,omitempty"`
+	Serial         string    `json:"serial,omitempty"`
+	Imported       time.Time `json:"imported"`
+	// Duration is the length of a video in seconds and Codec its codec.
+	Duration float64 `json:"duration,omitempty"`
+	Codec    string  `json:"codec,omitempty"`
+	// Session is the run that imported the file and SessionSource what
+	// --session-source said it came from.
+	Session       string `json:"session,omitempty"`
+	SessionSource string `json:"session_source,omitempty"`
+	// Label names the device the file came from.
+	Label string `json:"label,omitempty"`
+	// Archive is the cold storage volume the file was packed into.
+	Archive string `json:"archive,omitempty"`
+}
+
+// libraryIndex is the catalog of a destination, keyed by slash separated
+// paths relative to the destination and, for files under an overflow or
+// tier root, by their whole path, so the same name in two roots is two
+// entries.
+type libraryIndex struct {
+	sync.Mutex
+	entries map[string]*indexEntry
+}
+
+var catalog *libraryIndex
+
+func indexPath() string {
+	return filepath.Join(c.Destination, metaDirName, "index.json")
+}
+
+func loadIndex(path string) (*libraryIndex, error) {
+	index := &libraryIndex{entries: make(map[string]*indexEntry)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &index.entries); err != nil {
+		log.Warnf("ignore unreadable index %s: %v", path, err)
+		index.entries = make(map[string]*indexEntry)
+	}
+	// earlier versions keyed files of every root relative to the root
+	for key, entry := range index.entries {
+		if full := filepath.ToSlash(entry.path(key)); entry.Root != "" && key != full {
+			delete(index.entries, key)
+			index.entries[full] = entry
+		}
+	}
+	return index, nil
+}
+
+func (ix *libraryIndex) save(path string) error {
+	if err := createParentDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+	ix.Lock()
+	defer ix.Unlock()
+	data, err := json.MarshalIndent(ix.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// path returns where the file of an entry lives.
+func (e *indexEntry) path(key string) string {
+	root := c.Destination
+	if e.Root != "" {
+		root = e.Root
+	}
+	return filepath.Join(root, filepath.FromSlash(e.rel(key)))
+}
+
+// rel returns the slash separated path of an entry relative to its root.
+func (e *indexEntry) rel(key string) string {
+	if e.Root == "" {
+		return key
+	}
+	return strings.TrimPrefix(key, strings.TrimSuffix(filepath.ToSlash(e.Root), "/")+"/")
+}
+
+// name is the path of an entry in a volume or a backup: relative to its
+// root, and under the name of the root for a file not in the destination.
+func (e *indexEntry) name(key string) string {
+	if e.Root == "" {
+		return key
+	}
+	return path.Join(filepath.Base(e.Root), e.rel(key))
+}
+
+func indexKey(file string) string {
+	root := rootOf(file)
+	if root != c.Destination {
+		return filepath.ToSlash(absPath(file))
+	}
+	rel, err := filepath.Rel(absPath(root), absPath(file))
+	if err != nil {
+		return filepath.ToSlash(file)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// add records a file that was just placed at dest.
+func (ix *libraryIndex) add(source, dest string, meta *mediaMeta) {
+	entry := &indexEntry{Source: absPath(source), Imported: time.Now(), Session: session, SessionSource: c.SessionSource, Label: c.Label}
+	if filepath.Base(source) != filepath.Base(dest) {
+		entry.OriginalName = filepath.Base(source)
+	}
+	if root := rootOf(dest); root != c.Destination {
+		entry.Root = absPath(root)
+	}
+	if info, err := os.Stat(dest); err == nil {
+		entry.Size = info.Size()
+	}
+	if meta != nil {
+		entry.Taken = meta.Time
+		entry.Model = meta.Model
+		entry.Serial = meta.Serial
+		entry.Duration = meta.Duration.Seconds()
+		entry.Codec = meta.Codec
+	}
+	ix.Lock()
+	ix.entries[indexKey(dest)] = entry
+	ix.Unlock()
+}
+
+// markCorrected records that the corrected capture time was written into
+// the file at dest.
+func (ix *libraryIndex) markCorrected(dest string) {
+	ix.Lock()
+	defer ix.Unlock()
+	if entry, ok := ix.entries[indexKey(dest)]; ok {
+		entry.ClockCorrected = true
+	}
+}
+
+// clockCorrected reports whether the catalog knows file to carry its
+// corrected capture time already.
+func clockCorrected(file string) bool {
+	if catalog == nil {
+		return false
+	}
+	catalog.Lock()
+	defer catalog.Unlock()
+	entry, ok := catalog.entries[indexKey(file)]
+	return ok && entry.ClockCorrected
+}
+
+// loadCatalog loads the catalog of the library for commands that plan its
+// files again, unless it is loaded already.
+func loadCatalog() error {
+	if catalog != nil {
+		return nil
+	}
+	var err error
+	catalog, err = loadIndex(indexPath())
+	return err
+}
+
+// move follows a file that moved inside the library.
+func (ix *libraryIndex) move(from, to string) {
+	ix.Lock()
+	defer ix.Unlock()
+	entry, ok := ix.entries[indexKey(from)]
+	if !ok {
+		return
+	}
+	delete(ix.entries, indexKey(from))
+	entry.Root = ""
+	if root := rootOf(to); root != c.Destination {
+		entry.Root = absPath(root)
+	}
+	ix.entries[indexKey(to)] = entry
+}
+
+// remove forgets a file deleted from the library.
+func (ix *libraryIndex) remove(file string) {
+	ix.Lock()
+	delete(ix.entries, indexKey(file))
+	ix.Unlock()
+}
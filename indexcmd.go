@@ -0,0 +1,388 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+var indexCommand = &cli.Command{
+	Name:  "index",
+	Usage: "work with the catalog a library keeps in " + metaDirName + "/index.json",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "export",
+			Usage: "export the catalog for spreadsheets or DuckDB",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:        "dir",
+					Aliases:     []string{"d"},
+					Destination: &c.Destination,
+					Usage:       "the library whose catalog is exported",
+					Required:    true,
+				},
+				&cli.StringFlag{
+					Name:        "format",
+					Destination: &c.Format,
+					Usage:       "csv, jsonl or parquet",
+					Value:       "csv",
+				},
+				&cli.StringFlag{
+					Name:        "out",
+					Aliases:     []string{"o"},
+					Destination: &c.Target,
+					Usage:       "file to write, - for stdout",
+					Value:       "-",
+				},
+				&cli.StringFlag{
+					Name:        "session",
+					Destination: &c.Session,
+					Usage:       "only export the files imported by this session",
+				},
+			},
+			Action: exportIndex,
+		},
+		{
+			Name:   "sessions",
+			Usage:  "list the import sessions of the library with where they came from",
+			Flags:  []cli.Flag{libraryFlag()},
+			Action: listSessions,
+		},
+		{
+			Name:   "rebuild",
+			Usage:  "rescan the library and write its catalog again, keeping what only the catalog knows",
+			Flags:  []cli.Flag{libraryFlag(), configFlag()},
+			Action: rebuildIndex,
+		},
+		{
+			Name:   "check",
+			Usage:  "list catalog entries whose files are missing or changed, and files the catalog misses",
+			Flags:  []cli.Flag{libraryFlag(), configFlag()},
+			Action: checkIndex,
+		},
+	},
+}
+
+func libraryFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:        "dir",
+		Aliases:     []string{"d"},
+		Destination: &c.Destination,
+		Usage:       "the library",
+		Required:    true,
+	}
+}
+
+func configFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:        "config",
+		Aliases:     []string{"c"},
+		Destination: &c.ConfigPath,
+		Usage:       "yaml config file path",
+		DefaultText: "config.yaml",
+	}
+}
+
+var indexColumns = []string{"path", "root", "source", "original_name", "size", "taken", "model", "serial", "imported", "archive", "session", "session_source", "label", "duration", "codec"}
+
+func exportIndex(_ *cli.Context) error {
+	switch c.Format {
+	case "csv", "jsonl", "parquet":
+	default:
+		return fmt.Errorf("--format is csv, jsonl or parquet, not %q", c.Format)
+	}
+	index, err := loadIndex(indexPath())
+	if err != nil {
+		return err
+	}
+
+	out := io.Writer(os.Stdout)
+	if c.Target != "-" {
+		f, err := os.Create(c.Target)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	keys := make([]string, 0, len(index.entries))
+	for key, e := range index.entries {
+		if c.Session == "" || e.Session == c.Session {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	switch c.Format {
+	case "parquet":
+		if err = exportParquet(out, index, keys); err != nil {
+			return err
+		}
+	case "jsonl":
+		enc := json.NewEncoder(out)
+		for _, key := range keys {
+			row := struct {
+				Path string `json:"path"`
+				*indexEntry
+			}{index.entries[key].rel(key), index.entries[key]}
+			if err = enc.Encode(row); err != nil {
+				return err
+			}
+		}
+	default:
+		w := csv.NewWriter(out)
+		if err = w.Write(indexColumns); err != nil {
+			return err
+		}
+		for _, key := range keys {
+			e := index.entries[key]
+			err = w.Write([]string{e.rel(key), e.Root, e.Source, e.OriginalName, strconv.FormatInt(e.Size, 10),
+				formatTime(e.Taken), e.Model, e.Serial, formatTime(e.Imported), e.Archive, e.Session, e.SessionSource, e.Label,
+				formatSeconds(e.Duration), e.Codec})
+			if err != nil {
+				return err
+			}
+		}
+		w.Flush()
+		if err = w.Error(); err != nil {
+			return err
+		}
+	}
+	if c.Target != "-" {
+		log.Infof("exported %d entries to %s", len(keys), c.Target)
+	}
+	return nil
+}
+
+// exportParquet writes the entries of keys with the columns of csv, taken
+// and imported as timestamps.
+func exportParquet(out io.Writer, index *libraryIndex, keys []string) error {
+	columns := make([]*parquetColumn, len(indexColumns))
+	for i, name := range indexColumns {
+		switch name {
+		case "size":
+			columns[i] = int64Column(name)
+		case "taken", "imported":
+			columns[i] = timestampColumn(name)
+		case "duration":
+			columns[i] = doubleColumn(name)
+		default:
+			columns[i] = stringColumn(name)
+		}
+	}
+	for _, key := range keys {
+		e := index.entries[key]
+		for _, col := range columns {
+			switch col.name {
+			case "path":
+				col.addString(e.rel(key))
+			case "root":
+				col.addString(e.Root)
+			case "source":
+				col.addString(e.Source)
+			case "original_name":
+				col.addString(e.OriginalName)
+			case "size":
+				col.addInt64(e.Size)
+			case "taken":
+				col.addTime(e.Taken)
+			case "model":
+				col.addString(e.Model)
+			case "serial":
+				col.addString(e.Serial)
+			case "imported":
+				col.addTime(e.Imported)
+			case "archive":
+				col.addString(e.Archive)
+			case "session":
+				col.addString(e.Session)
+			case "session_source":
+				col.addString(e.SessionSource)
+			case "label":
+				col.addString(e.Label)
+			case "duration":
+				col.addDouble(e.Duration)
+			case "codec":
+				col.addString(e.Codec)
+			default:
+				return fmt.Errorf("no parquet value for column %s", col.name)
+			}
+		}
+	}
+	return writeParquet(out, columns, len(keys))
+}
+
+// libraryFiles returns the files of every root of the library that belong
+// in the catalog, keyed like it.
+func libraryFiles() (map[string]string, error) {
+	files := make(map[string]string)
+	for _, root := range destinationRoots() {
+		if !fileExists(root) {
+			continue
+		}
+		list, err := walkDirectory(root)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range list {
+			if isMediaFile(file) || getFileExtension(file, false) == "xmp" {
+				files[indexKey(file)] = file
+			}
+		}
+	}
+	return files, nil
+}
+
+func rebuildIndex(_ *cli.Context) error {
+	if err := loadConfigFile(); err != nil {
+		return err
+	}
+	old, err := loadIndex(indexPath())
+	if err != nil {
+		return err
+	}
+	files, err := libraryFiles()
+	if err != nil {
+		return err
+	}
+
+	index := &libraryIndex{entries: make(map[string]*indexEntry, len(files))}
+	for key, file := range files {
+		entry, ok := old.entries[key]
+		if !ok {
+			entry = &indexEntry{Imported: time.Now()}
+		}
+		if root := rootOf(file); root != c.Destination {
+			entry.Root = absPath(root)
+		}
+		if info, err := os.Stat(file); err == nil {
+			entry.Size = info.Size()
+		}
+		if meta, _ := dateFile(file); meta != nil {
+			entry.Taken, entry.Model, entry.Serial = meta.Time, meta.Model, meta.Serial
+		}
+		index.entries[key] = entry
+	}
+	dropped := 0
+	for key, entry := range old.entries {
+		if _, ok := index.entries[key]; ok {
+			continue
+		}
+		// packed files may have left the disk for cold storage
+		if entry.Archive != "" {
+			index.entries[key] = entry
+			continue
+		}
+		log.Infof("drop %s, it is gone", key)
+		dropped++
+	}
+	if err = index.save(indexPath()); err != nil {
+		return err
+	}
+	log.Infof("rebuilt index of %d entries, %d dropped", len(index.entries), dropped)
+	return nil
+}
+
+func checkIndex(_ *cli.Context) error {
+	if err := loadConfigFile(); err != nil {
+		return err
+	}
+	index, err := loadIndex(indexPath())
+	if err != nil {
+		return err
+	}
+	files, err := libraryFiles()
+	if err != nil {
+		return err
+	}
+
+	var missing, changed, unindexed int
+	for key, entry := range index.entries {
+		info, err := os.Stat(entry.path(key))
+		switch {
+		case err != nil && entry.Archive != "":
+			// packed and removed, the volume still has it
+		case err != nil:
+			log.Warnf("missing: %s", key)
+			missing++
+		case info.Size() != entry.Size:
+			log.Warnf("changed: %s is %d bytes, the index says %d", key, info.Size(), entry.Size)
+			changed++
+		}
+	}
+	for key := range files {
+		if _, ok := index.entries[key]; !ok {
+			log.Warnf("unindexed: %s", key)
+			unindexed++
+		}
+	}
+	log.Infof("%d entries checked: %d missing, %d changed, %d files not in the index", len(index.entries), missing, changed, unindexed)
+	if missing+changed+unindexed > 0 {
+		return fmt.Errorf("the index is out of date, run index rebuild")
+	}
+	return nil
+}
+
+// formatTime writes times for spreadsheets, leaving unknown ones empty.
+func formatTime(tm time.Time) string {
+	if tm.IsZero() {
+		return ""
+	}
+	return tm.Format(time.DateTime)
+}
+
+// formatSeconds writes a video duration with millisecond precision.
+func formatSeconds(seconds float64) string {
+	if seconds == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(seconds, 'f', 3, 64)
+}
+
+// listSessions prints every import session with its source, when it ran and
+// how many files of it the catalog holds.
+func listSessions(_ *cli.Context) error {
+	index, err := loadIndex(indexPath())
+	if err != nil {
+		return err
+	}
+	type sessionInfo struct {
+		source string
+		first  time.Time
+		files  int
+	}
+	sessions := make(map[string]*sessionInfo)
+	for _, e := range index.entries {
+		if e.Session == "" {
+			continue
+		}
+		s, ok := sessions[e.Session]
+		if !ok {
+			s = &sessionInfo{source: e.SessionSource, first: e.Imported}
+			sessions[e.Session] = s
+		}
+		if e.Imported.Before(s.first) {
+			s.first = e.Imported
+		}
+		s.files++
+	}
+	ids := make([]string, 0, len(sessions))
+	for id := range sessions {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		s := sessions[id]
+		fmt.Printf("%s\t%s\t%d files\t%s\n", id, formatTime(s.first), s.files, s.source)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/binary"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	markerAPP13   = 0xed
+	markerComment = 0xfe
+	// iptcResource is the Photoshop image resource holding IPTC records.
+	iptcResource = 0x0404
+)
+
+// commentDates are the dates recognized in a JPEG comment, T separators
+// being read as spaces.
+var commentDates = []struct {
+	pattern *regexp.Regexp
+	layout  string
+}{
+	{regexp.MustCompile(`\d{4}:\d{2}:\d{2} \d{2}:\d{2}:\d{2}`), "2006:01:02 15:04:05"},
+	{regexp.MustCompile(`\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}`), "2006-01-02 15:04:05"},
+	{regexp.MustCompile(`\d{4}-\d{2}-\d{2}`), "2006-01-02"},
+}
+
+// readJPEGComments dates a JPEG whose EXIF was stripped by an editor from
+// the IPTC DateCreated and TimeCreated it kept, or else from a date in its
+// comment segment.
+func readJPEGComments(file string) *mediaMeta {
+	var iptc, comment *mediaMeta
+	walkJPEG(file, func(marker byte, data []byte) bool {
+		switch {
+		case marker == markerAPP13 && iptc == nil:
+			iptc = parseIPTC(data)
+		case marker == markerComment && comment == nil:
+			comment = parseCommentDate(string(data))
+		}
+		return iptc != nil
+	})
+	if iptc != nil {
+		return iptc
+	}
+	return comment
+}
+
+func isJPEG(file string) bool {
+	ext := getFileExtension(mediaExt(file), false)
+	return strings.EqualFold(ext, "jpg") || strings.EqualFold(ext, "jpeg")
+}
+
+// parseIPTC reads the date of the IPTC records in the Photoshop resources
+// of an APP13 segment.
+func parseIPTC(data []byte) *mediaMeta {
+	if records := iptcRecords(data); records != nil {
+		return iptcDate(records)
+	}
+	return nil
+}
+
+// iptcRecords returns the IPTC records in the Photoshop resources of an
+// APP13 segment, or nil.
+func iptcRecords(data []byte) []byte {
+	const header = "Photoshop 3.0\x00"
+	if !strings.HasPrefix(string(data), header) {
+		return nil
+	}
+	data = data[len(header):]
+	for len(data) >= 12 && string(data[:4]) == "8BIM" {
+		id := binary.BigEndian.Uint16(data[4:6])
+		// a pascal string name, padded to an even length
+		nameLen := int(data[6]) + 1
+		nameLen += nameLen % 2
+		at := 6 + nameLen
+		if at+4 > len(data) {
+			return nil
+		}
+		size := int(binary.BigEndian.Uint32(data[at : at+4]))
+		at += 4
+		if size < 0 || at+size > len(data) {
+			return nil
+		}
+		if id == iptcResource {
+			return data[at : at+size]
+		}
+		// the padding of the last resource may be missing
+		data = data[min(at+size+size%2, len(data)):]
+	}
+	return nil
+}
+
+// eachIPTCRecord calls fn with every record:dataset and its value.
+func eachIPTCRecord(records []byte, fn func(record, dataset byte, value []byte)) {
+	for len(records) >= 5 && records[0] == 0x1c {
+		size := int(binary.BigEndian.Uint16(records[3:5]))
+		if 5+size > len(records) {
+			return
+		}
+		fn(records[1], records[2], records[5:5+size])
+		records = records[5+size:]
+	}
+}
+
+// iptcDate combines the DateCreated (2:55) and TimeCreated (2:60) records.
+func iptcDate(records []byte) *mediaMeta {
+	var date, clock string
+	eachIPTCRecord(records, func(record, dataset byte, value []byte) {
+		if record == 2 && dataset == 55 {
+			date = string(value)
+		} else if record == 2 && dataset == 60 {
+			clock = string(value)
+		}
+	})
+	if len(date) != 8 {
+		return nil
+	}
+	layout, value := "20060102", date
+	// TimeCreated is HHMMSS followed by the zone, +HHMM
+	if len(clock) >= 6 {
+		layout, value = "20060102150405", date+clock[:6]
+		if len(clock) >= 11 {
+			layout, value = "20060102150405-0700", date+clock[:11]
+		}
+	}
+	tm, err := time.Parse(layout, value)
+	if err != nil {
+		return nil
+	}
+	if len(clock) >= 11 {
+		// like EXIF times, the local time of the capture counts
+		tm = time.Date(tm.Year(), tm.Month(), tm.Day(), tm.Hour(), tm.Minute(), tm.Second(), 0, time.UTC)
+	}
+	return &mediaMeta{Time: tm}
+}
+
+// readIPTCKeywords returns the IPTC Keywords (2:25) of a JPEG, which
+// editors write next to or instead of the XMP dc:subject.
+func readIPTCKeywords(file string) []string {
+	var keywords []string
+	walkJPEG(file, func(marker byte, data []byte) bool {
+		if marker != markerAPP13 {
+			return false
+		}
+		records := iptcRecords(data)
+		if records == nil {
+			return false
+		}
+		eachIPTCRecord(records, func(record, dataset byte, value []byte) {
+			if record == 2 && dataset == 25 {
+				if keyword := strings.TrimSpace(iptcString(value)); keyword != "" {
+					keywords = append(keywords, keyword)
+				}
+			}
+		})
+		return true
+	})
+	return keywords
+}
+
+// iptcString decodes a record written in UTF-8 or, by older software,
+// Latin-1.
+func iptcString(value []byte) string {
+	if utf8.Valid(value) {
+		return string(value)
+	}
+	runes := make([]rune, len(value))
+	for i, b := range value {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+func parseCommentDate(comment string) *mediaMeta {
+	for _, d := range commentDates {
+		found := d.pattern.FindString(comment)
+		if found == "" {
+			continue
+		}
+		tm, err := time.Parse(d.layout, strings.Replace(found, "T", " ", 1))
+		if err == nil && tm.Year() > 1900 {
+			return &mediaMeta{Time: tm}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// metaDirName is the directory inside a destination where media_tool keeps
+// its own bookkeeping; it is never scanned as media.
+const metaDirName = ".media_tool"
+
+const (
+	opCopied        = "copied"
+	opPendingDelete = "pending_delete"
+	opDeleted       = "deleted"
+	opFailed        = "failed"
+	// opRolledBack records a copy removed again because its batch failed.
+	opRolledBack = "rolled_back"
+	// opChunk records how much of a resumable copy reached the disk.
+	opChunk = "chunk"
+	// opSnapshot records the snapshot taken of a destination before a run.
+	opSnapshot = "snapshot"
+)
+
+type journalEntry struct {
+	Time   time.Time `json:"time"`
+	Op     string    `json:"op"`
+	Source string    `json:"source"`
+	Dest   string    `json:"dest"`
+	Error  string    `json:"error,omitempty"`
+	// Offset is the number of bytes of a chunk entry that are synced, Size
+	// and ModTime what the source looked like when they were copied.
+	Offset  int64 `json:"offset,omitempty"`
+	Size    int64 `json:"size,omitempty"`
+	ModTime int64 `json:"mtime,omitempty"`
+	// Snapshot is the ZFS snapshot or btrfs snapshot path of a snapshot
+	// entry.
+	Snapshot string `json:"snapshot,omitempty"`
+}
+
+// journal is an append-only JSON lines log of file operations, written so a
+// crashed run can be understood and finished later.
+type journal struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+func journalPath() string {
+	return filepath.Join(c.Destination, metaDirName, "journal.jsonl")
+}
+
+func openJournal(path string) (*journal, error) {
+	if err := createParentDir(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &journal{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (j *journal) record(op, source, dest string, err error) {
+	// paths are stored absolute so a later run from elsewhere can use them
+	entry := journalEntry{Time: time.Now(), Op: op, Source: absPath(source), Dest: absPath(dest)}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	j.write(entry)
+}
+
+// write appends entry to the journal and syncs it to disk.
+func (j *journal) write(entry journalEntry) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.enc.Encode(entry); err != nil {
+		log.Errorf("error writing journal: %v", err)
+		return
+	}
+	_ = j.f.Sync()
+}
+
+func absPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return abs
+}
+
+// recordChunk notes that the first offset bytes of a copy of source, as it
+// is described by info, are synced.
+func (j *journal) recordChunk(source, part string, info os.FileInfo, offset int64) {
+	j.write(journalEntry{Time: time.Now(), Op: opChunk, Source: absPath(source), Dest: absPath(part), Offset: offset,
+		Size: info.Size(), ModTime: info.ModTime().UnixNano()})
+}
+
+// recordSnapshot notes the snapshot taken of dest; its name is not a path
+// on ZFS and is kept as it is.
+func (j *journal) recordSnapshot(dest, snapshot string) {
+	j.write(journalEntry{Time: time.Now(), Op: opSnapshot, Dest: absPath(dest), Snapshot: snapshot})
+}
+
+func (j *journal) Close() error {
+	return j.f.Close()
+}
+
+// readJournal returns every entry of the journal at path; a missing journal
+// has no entries.
+func readJournal(path string) ([]journalEntry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := make([]journalEntry, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.Warnf("skip unreadable journal line: %v", err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// pendingDeletions returns the sources a previous run marked for deletion
+// but never recorded as deleted.
+func pendingDeletions(entries []journalEntry) map[string]string {
+	pending := make(map[string]string)
+	for _, entry := range entries {
+		switch entry.Op {
+		case opPendingDelete:
+			pending[entry.Source] = entry.Dest
+		case opDeleted:
+			delete(pending, entry.Source)
+		}
+	}
+	return pending
+}
+
+// copiedChunk is how much of a partial file was synced, from which
+// version of which source.
+type copiedChunk struct {
+	Source        string
+	Offset        int64
+	Size, ModTime int64
+}
+
+// copiedChunks returns, for every partial file a previous run left, how many
+// of its bytes were synced; a finished or failed copy starts over.
+func copiedChunks(entries []journalEntry) map[string]copiedChunk {
+	chunks := make(map[string]copiedChunk)
+	for _, entry := range entries {
+		switch entry.Op {
+		case opChunk:
+			chunks[entry.Dest] = copiedChunk{Source: entry.Source, Offset: entry.Offset, Size: entry.Size, ModTime: entry.ModTime}
+		case opCopied:
+			delete(chunks, entry.Dest+partSuffix)
+		}
+	}
+	return chunks
+}
+
+// resumes reports whether a copy of source, as info describes it, carries on
+// from chunk: it was left by the same source, unchanged since.
+func (chunk copiedChunk) resumes(source string, info os.FileInfo) bool {
+	return chunk.Source == absPath(source) && chunk.Size == info.Size() && chunk.ModTime == info.ModTime().UnixNano()
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// removableMounts are where desktops mount cards, in a folder named after
+// the volume label.
+var removableMounts = []string{"/media/", "/run/media/", "/Volumes/"}
+
+// volumeLabel returns the label of the removable volume holding path, such
+// as the EOS_DIGITAL of a camera card, or "" when it has none. Only volumes
+// mounted under removableMounts are labelled, not the system disk.
+func volumeLabel(path string) string {
+	path = absPath(path)
+	mount, device := mountOf(path)
+	for _, prefix := range removableMounts {
+		if mount != "" && strings.HasPrefix(mount, prefix) {
+			if label := deviceLabel(device); device != "" && label != "" {
+				return label
+			}
+			return filepath.Base(mount)
+		}
+		// without /proc, e.g. on macOS, the path shows the mount
+		if mount == "" && strings.HasPrefix(path, prefix) {
+			rest := strings.Split(strings.TrimPrefix(path, prefix), string(filepath.Separator))
+			// /media/user/LABEL on Linux, /Volumes/LABEL on macOS
+			if prefix != "/Volumes/" && len(rest) > 1 {
+				rest = rest[1:]
+			}
+			return rest[0]
+		}
+	}
+	return ""
+}
+
+// mountOf returns the mount point holding path and the device mounted
+// there, from /proc/self/mountinfo.
+func mountOf(path string) (mount, device string) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		sep := -1
+		for i, field := range fields {
+			if field == "-" {
+				sep = i
+				break
+			}
+		}
+		if len(fields) < 5 || sep < 0 || sep+2 >= len(fields) {
+			continue
+		}
+		point := unescapeMount(fields[4])
+		if within(path, point) && len(point) > len(mount) {
+			mount, device = point, fields[sep+2]
+		}
+	}
+	return mount, device
+}
+
+// unescapeMount decodes the octal escapes of mountinfo, such as \040.
+func unescapeMount(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// deviceLabel looks device up in /dev/disk/by-label, whose names escape
+// spaces as \x20.
+func deviceLabel(device string) string {
+	target, err := filepath.EvalSymlinks(device)
+	if err != nil {
+		return ""
+	}
+	entries, err := os.ReadDir("/dev/disk/by-label")
+	if err != nil {
+		return ""
+	}
+	for _, entry := range entries {
+		linked, err := filepath.EvalSymlinks(filepath.Join("/dev/disk/by-label", entry.Name()))
+		if err == nil && linked == target {
+			label, err := strconv.Unquote(`"` + entry.Name() + `"`)
+			if err != nil {
+				return entry.Name()
+			}
+			return label
+		}
+	}
+	return ""
+}
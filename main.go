@@ -2,6 +2,8 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
@@ -10,6 +12,8 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/rwcarlsen/goexif/exif"
@@ -30,6 +34,7 @@ var picTypes = map[string]bool{
 	"bmp":  true,
 	"heic": true,
 	"arw":  true,
+	"dng":  true,
 }
 
 var AudioTypes = map[string]bool{
@@ -51,9 +56,72 @@ var videoTypes = map[string]bool{
 }
 
 type configFile struct {
-	ModelMap map[string]string `yaml:"model_map"`
-	SkipDir  []string          `yaml:"skip_dir"`
-	SkipFile []string          `yaml:"skip_file"`
+	ModelMap map[string]string       `yaml:"model_map"`
+	SkipDir  []string                `yaml:"skip_dir"`
+	SkipFile []string                `yaml:"skip_file"`
+	Template string                  `yaml:"template"`
+	Cameras  map[string]cameraRule   `yaml:"cameras"`
+	Exiftool string                  `yaml:"exiftool"`
+	Presets  map[string]exportPreset `yaml:"presets"`
+	// Album is the template naming the album of uploaded files.
+	Album      string           `yaml:"album"`
+	Immich     immichConfig     `yaml:"immich"`
+	PhotoPrism photoprismConfig `yaml:"photoprism"`
+	Synology   synologyConfig   `yaml:"synology"`
+	SevenZip   string           `yaml:"seven_zip"`
+	Hooks      hookConfig       `yaml:"hooks"`
+	// Apps route chat app exports, keyed by app name such as "wechat".
+	Apps map[string]routeRule `yaml:"apps"`
+	// Classes route screenshots and screen recordings.
+	Classes map[string]routeRule `yaml:"classes"`
+	Tiers   []tierRule           `yaml:"tiers"`
+	// Strategies orders the date strategies by name, leaving out the
+	// ones not listed.
+	Strategies []string        `yaml:"strategies"`
+	Ownership  ownershipConfig `yaml:"ownership"`
+	// SkipProfiles picks the vendor skip profiles, all by default or none
+	// with [none].
+	SkipProfiles []string       `yaml:"skip_profiles"`
+	Sanitize     sanitizeConfig `yaml:"sanitize"`
+}
+
+// cameraRule overrides how files of one camera model are handled.
+type cameraRule struct {
+	Alias    string `yaml:"alias"`
+	Template string `yaml:"template"`
+	// Offset is added to the capture time, e.g. "-1h3m".
+	Offset string `yaml:"offset"`
+	// Offsets apply instead of Offset to photos taken within their range.
+	Offsets []offsetRule `yaml:"offsets"`
+	Skip    bool         `yaml:"skip"`
+}
+
+// offsetRule corrects the clock of a camera between two dates, inclusive.
+type offsetRule struct {
+	From   string `yaml:"from"`
+	To     string `yaml:"to"`
+	Offset string `yaml:"offset"`
+}
+
+// offsetAt returns the correction for a photo the camera stamped with tm.
+func (r cameraRule) offsetAt(tm time.Time) (time.Duration, error) {
+	for _, rule := range r.Offsets {
+		from, err := time.Parse("2006-01-02", rule.From)
+		if err != nil {
+			return 0, err
+		}
+		to, err := time.Parse("2006-01-02", rule.To)
+		if err != nil {
+			return 0, err
+		}
+		if !tm.Before(from) && tm.Before(to.AddDate(0, 0, 1)) {
+			return time.ParseDuration(rule.Offset)
+		}
+	}
+	if r.Offset == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(r.Offset)
 }
 
 // time regex to time layout
@@ -63,17 +131,92 @@ var regexTime = map[string]string{
 }
 
 type Config struct {
-	Source      string
-	Destination string
-	Dry         bool
-	Rename      bool
-	NoSkip      bool
-	OverWrite   bool
-	Yes         bool
-	Together    bool
-	Debug       bool
-	Mode        string
-	ConfigPath  string
+	Source          string
+	Destination     string
+	Dry             bool
+	Rename          bool
+	NoSkip          bool
+	OverWrite       bool
+	Yes             bool
+	Together        bool
+	Debug           bool
+	Mode            string
+	ConfigPath      string
+	Workers         int
+	Preserve        cli.StringSlice
+	TwoPhase        bool
+	ClashReport     string
+	DirDate         bool
+	CachePath       string
+	WriteExif       bool
+	CheckCorrupt    bool
+	VerifyVideo     bool
+	Exiftool        bool
+	Xmp             bool
+	MinRating       int
+	MinMP           float64
+	MinDuration     time.Duration
+	Keywords        cli.StringSlice
+	Preset          string
+	Upload          string
+	CatalogSafe     bool
+	RelinkCSV       string
+	Synology        bool
+	ArchivePassword string
+	Plan            string
+	ConfirmOver     int
+	Overflow        cli.StringSlice
+	Heartbeat       time.Duration
+	StatusFile      string
+	FromFailures    string
+	Manifest        bool
+	FilesFrom       string
+	Settle          time.Duration
+	NormalizeExt    string
+	Posters         string
+	GPX             cli.StringSlice
+	WriteGPS        bool
+	Target          string
+	VolumeSize      string
+	Verify          bool
+	SkipIdentical   bool
+	Quiet           bool
+	Verbose         bool
+	DatePolicy      string
+	// MaxConcurrentWrites limits the files written at once to each
+	// destination root; 0 means no limit.
+	MaxConcurrentWrites int
+	Trashed             string
+	Stats               string
+	Format              string
+	Layout              string
+	SkipOrganized       bool
+	Order               string
+	SessionSource       string
+	SessionXmp          bool
+	Session             string
+	MetaSidecar         string
+	PlanReport          string
+	PreviewTree         bool
+	SnapshotBefore      bool
+	MaxFiles            int
+	MaxDuration         time.Duration
+	User                string
+	Label               string
+	SimulateErrors      string
+	Hardlink            bool
+	Wizard              bool
+	UseOSTrash          bool
+	FixExt              bool
+	Template            string
+	UpdateCheck         bool
+	UpdateForce         bool
+	PublicKey           string
+	EncryptKey          string
+	ObfuscateNames      bool
+	AppleEdits          string
+	BurstPolicy         string
+	BurstKeep           string
 }
 
 var c = Config{}
@@ -88,12 +231,105 @@ var fileCommand = &cli.Command{
 			Destination: &c.Dry,
 			Usage:       "dry run",
 		},
+		&cli.BoolFlag{
+			Name:        "verify",
+			Destination: &c.Verify,
+			Usage:       "compare every copy with its source",
+		},
+		&cli.BoolFlag{
+			Name:        "skip-identical",
+			Destination: &c.SkipIdentical,
+			Usage:       "skip files already at their destination and keep both when the content differs",
+		},
 		&cli.StringFlag{
 			Name:        "source",
 			Aliases:     []string{"s"},
 			Destination: &c.Source,
-			Usage:       "source directory",
-			Required:    true,
+			Usage:       "source directory, a glob such as \"DCIM/**/*.JPG\", or a zip/tar/7z archive",
+		},
+		&cli.StringSliceFlag{
+			Name:        "gpx",
+			Destination: &c.GPX,
+			Usage:       "geotag photos without GPS from these gpx tracks, exposed as {lat} and {lon}",
+		},
+		&cli.BoolFlag{
+			Name:        "write-gps",
+			Destination: &c.WriteGPS,
+			Usage:       "write coordinates taken from --gpx into the EXIF of placed files, needs exiftool",
+		},
+		&cli.StringFlag{
+			Name:        "posters",
+			Destination: &c.Posters,
+			Usage:       "grab a frame of every planned video into this folder with a review.html, needs ffmpeg",
+		},
+		&cli.StringFlag{
+			Name:        "plan-report",
+			Destination: &c.PlanReport,
+			Usage:       "write the plan to this standalone html file with thumbnails, by destination folder and with conflicts highlighted, e.g. with --dry",
+		},
+		&cli.BoolFlag{
+			Name:        "preview-tree",
+			Destination: &c.PreviewTree,
+			Usage:       "show the destination folders the plan fills, with their file counts, before placing anything, implies --together",
+		},
+		&cli.BoolFlag{
+			Name:        "snapshot-before",
+			Destination: &c.SnapshotBefore,
+			Usage:       "snapshot the btrfs subvolumes or ZFS datasets of the destination, overflow and tier roots before the run and record them in the journal, to roll back",
+		},
+		&cli.IntFlag{
+			Name:        "workers",
+			Aliases:     []string{"w"},
+			Destination: &c.Workers,
+			Usage:       "number of files read and placed at once: EXIF is decoded ahead of planning and files are copied and verified on their own worker, then finished one at a time",
+			Value:       1,
+		},
+		&cli.IntFlag{
+			Name:        "max-concurrent-writes",
+			Destination: &c.MaxConcurrentWrites,
+			Usage:       "write at most this many files at once to each destination, e.g. 1 for an SMR disk, whatever --workers is",
+		},
+		&cli.StringFlag{
+			Name:        "trashed",
+			Destination: &c.Trashed,
+			Usage:       "what to do with files in the Android trash: skip, restore them among the others or separate them into " + trashedDir + "/",
+			DefaultText: "skip",
+		},
+		&cli.StringFlag{
+			Name:        "stats",
+			Destination: &c.Stats,
+			Usage:       "after the import write a stats.json, or stats.md with md, into every year folder it touched",
+		},
+		&cli.StringFlag{
+			Name:        "layout",
+			Destination: &c.Layout,
+			Usage:       "tree, cas to store files once by content under objects/ and link the tree to them, or plex to name home videos for Plex and Jellyfin with an nfo",
+			DefaultText: layoutTree,
+		},
+		&cli.BoolFlag{
+			Name:        "skip-organized",
+			Destination: &c.SkipOrganized,
+			Usage:       "skip source files whose path already has the shape of the template, without reading them",
+		},
+		&cli.StringFlag{
+			Name:        "date-policy",
+			Destination: &c.DatePolicy,
+			Usage:       "when dates disagree take the earliest, or prefer exif-first or filename-first, instead of the strategy order",
+		},
+		&cli.StringFlag{
+			Name:        "normalize-ext",
+			Destination: &c.NormalizeExt,
+			Usage:       "write extensions in lower or upper case",
+		},
+		&cli.DurationFlag{
+			Name:        "settle",
+			Destination: &c.Settle,
+			Usage:       "skip files modified within this period, e.g. 30s, and files that change before they are placed",
+		},
+		&cli.StringFlag{
+			Name:        "files-from",
+			Destination: &c.FilesFrom,
+			Usage:       "read the files to import from this list, - for stdin, one per line or NUL separated",
 		},
 		&cli.StringFlag{
 			Name:        "dest",
@@ -106,7 +342,7 @@ var fileCommand = &cli.Command{
 			Name:        "mode",
 			Aliases:     []string{"mo"},
 			Destination: &c.Mode,
-			Usage:       "copy or move?",
+			Usage:       "copy, move or export?",
 			Required:    true,
 		},
 		&cli.StringFlag{
@@ -133,6 +369,17 @@ var fileCommand = &cli.Command{
 			Destination: &c.Debug,
 			Usage:       "set log level to debug",
 		},
+		&cli.BoolFlag{
+			Name:        "quiet",
+			Aliases:     []string{"q"},
+			Destination: &c.Quiet,
+			Usage:       "only print errors, failed files and the summary",
+		},
+		&cli.BoolFlag{
+			Name:        "verbose",
+			Destination: &c.Verbose,
+			Usage:       "explain how every file was dated and placed",
+		},
 		&cli.BoolFlag{
 			Name:        "yes",
 			Aliases:     []string{"y"},
@@ -145,6 +392,217 @@ var fileCommand = &cli.Command{
 			Destination: &c.Together,
 			Usage:       "process files together",
 		},
+		&cli.BoolFlag{
+			Name:        "manifest",
+			Destination: &c.Manifest,
+			Usage:       "hash the source before the run and check afterwards that every file is still in the source or the destination",
+		},
+		&cli.StringFlag{
+			Name:        "from-failures",
+			Destination: &c.FromFailures,
+			Usage:       "only retry the files listed in a failures.json of an earlier run",
+		},
+		&cli.DurationFlag{
+			Name:        "heartbeat",
+			Destination: &c.Heartbeat,
+			Usage:       "log progress and the current file at this interval, e.g. 1m",
+		},
+		&cli.StringFlag{
+			Name:        "status-file",
+			Destination: &c.StatusFile,
+			Usage:       "rewrite this json file with the progress at every heartbeat",
+		},
+		&cli.StringSliceFlag{
+			Name:        "overflow",
+			Destination: &c.Overflow,
+			Usage:       "more destination roots, each used once the previous one is full",
+		},
+		&cli.BoolFlag{
+			Name:        "fix-ext",
+			Destination: &c.FixExt,
+			Usage:       "name files after the type of their content when their extension says otherwise, e.g. a PNG named .jpg",
+		},
+		&cli.StringFlag{
+			Name:        "apple-edits",
+			Destination: &c.AppleEdits,
+			Usage:       "for iPhone originals with an IMG_E edit keep both, only the original or only the edited file",
+			Value:       appleEditsBoth,
+		},
+		&cli.StringFlag{
+			Name:        "burst-policy",
+			Destination: &c.BurstPolicy,
+			Usage:       "which file stands for a Top Shot burst, motion photo or ~2 copies: cover, largest or first",
+			Value:       burstCover,
+		},
+		&cli.StringFlag{
+			Name:        "burst-keep",
+			Destination: &c.BurstKeep,
+			Usage:       "place all files of a burst or motion photo next to each other, or only the primary",
+			Value:       burstKeepAll,
+		},
+		&cli.BoolFlag{
+			Name:        "use-os-trash",
+			Destination: &c.UseOSTrash,
+			Usage:       "put moved sources and files replaced by --overwrite into the trash of the desktop instead of deleting them",
+		},
+		&cli.StringFlag{
+			Name:        "simulate-errors",
+			Destination: &c.SimulateErrors,
+			Usage:       "fail this share of file operations on purpose, e.g. 1%, to try out the failure handling; with --dry planned files fail instead",
+		},
+		&cli.StringFlag{
+			Name:        "label",
+			Destination: &c.Label,
+			Usage:       "name the device the files come from, e.g. \"Dad's iPhone\", for {label} in the template and the index",
+			DefaultText: "the volume label of the source",
+		},
+		&cli.StringFlag{
+			Name:        "user",
+			Destination: &c.User,
+			Usage:       "whose files these are, for {user} in the template and --dest",
+			DefaultText: "the current user",
+		},
+		&cli.IntFlag{
+			Name:        "max-files",
+			Destination: &c.MaxFiles,
+			Usage:       "stop after placing this many files, the next run continues with the rest",
+		},
+		&cli.DurationFlag{
+			Name:        "max-duration",
+			Destination: &c.MaxDuration,
+			Usage:       "stop placing files after this long, e.g. 2h, the next run continues with the rest",
+		},
+		&cli.IntFlag{
+			Name:        "confirm-over",
+			Destination: &c.ConfirmOver,
+			Usage:       "only ask for confirmation when more than this many files are planned, implies --together",
+		},
+		&cli.StringFlag{
+			Name:        "order",
+			Destination: &c.Order,
+			Usage:       "place files by size (smallest first), date (newest first) or path, implies --together",
+		},
+		&cli.StringSliceFlag{
+			Name:        "preserve",
+			Destination: &c.Preserve,
+			Usage:       "what to preserve when copying: xattrs, sparse",
+		},
+		&cli.BoolFlag{
+			Name:        "two-phase",
+			Destination: &c.TwoPhase,
+			Usage:       "in move mode, copy and verify all files before deleting any source",
+		},
+		&cli.StringFlag{
+			Name:        "clash-report",
+			Destination: &c.ClashReport,
+			Usage:       "write destinations generated for several different files to this file",
+		},
+		&cli.BoolFlag{
+			Name:        "dir-date",
+			Destination: &c.DirDate,
+			Usage:       "take dates and event names from parent directory names like '2019-07 Paris'",
+		},
+		&cli.BoolFlag{
+			Name:        "rename",
+			Aliases:     []string{"r"},
+			Destination: &c.Rename,
+			Usage:       "rename files to YYYYMMDD_HHMMSS_nnn, continuing the counter of each destination folder",
+		},
+		&cli.StringFlag{
+			Name:        "cache",
+			Destination: &c.CachePath,
+			Usage:       "cache extracted EXIF metadata in this file across runs",
+		},
+		&cli.BoolFlag{
+			Name:        "write-exif",
+			Destination: &c.WriteExif,
+			Usage:       "write clock corrected capture times into the EXIF of imported JPEGs",
+		},
+		&cli.BoolFlag{
+			Name:        "check-corrupt",
+			Destination: &c.CheckCorrupt,
+			Usage:       "quarantine zero-byte and undecodable files in " + corruptDir + "/",
+		},
+		&cli.BoolFlag{
+			Name:        "verify-video",
+			Destination: &c.VerifyVideo,
+			Usage:       "in move mode, check copied videos are complete before deleting the source",
+		},
+		&cli.BoolFlag{
+			Name:        "exiftool",
+			Destination: &c.Exiftool,
+			Usage:       "fall back to exiftool for files goexif cannot read",
+		},
+		&cli.BoolFlag{
+			Name:        "xmp",
+			Destination: &c.Xmp,
+			Usage:       "read dates and ratings from .xmp sidecars, preferring them over EXIF",
+		},
+		&cli.IntFlag{
+			Name:        "min-rating",
+			Destination: &c.MinRating,
+			Usage:       "only import files rated at least this much in XMP",
+		},
+		&cli.Float64Flag{
+			Name:        "min-mp",
+			Destination: &c.MinMP,
+			Usage:       "skip pictures below this many megapixels, such as thumbnails",
+		},
+		&cli.DurationFlag{
+			Name:        "min-duration",
+			Destination: &c.MinDuration,
+			Usage:       "skip videos shorter than this, e.g. 3s for clips recorded by accident",
+		},
+		&cli.StringSliceFlag{
+			Name:        "keyword",
+			Destination: &c.Keywords,
+			Usage:       "only import files tagged with this XMP or IPTC keyword, repeat to require several",
+		},
+		&cli.StringFlag{
+			Name:        "preset",
+			Destination: &c.Preset,
+			Usage:       "export preset from the config used by the export mode",
+		},
+		&cli.StringFlag{
+			Name:        "upload",
+			Destination: &c.Upload,
+			Usage:       "also upload imported files to immich or photoprism",
+		},
+		&cli.BoolFlag{
+			Name:        "catalog-safe",
+			Destination: &c.CatalogSafe,
+			Usage:       "keep .xmp sidecars next to their image and never place one without the other",
+		},
+		&cli.StringFlag{
+			Name:        "relink-csv",
+			Destination: &c.RelinkCSV,
+			Usage:       "write old and new paths of every placed file to this csv for catalog re-linking",
+		},
+		&cli.BoolFlag{
+			Name:        "synology",
+			Destination: &c.Synology,
+			Usage:       "skip @eaDir folders and add imported files to the Synology media index",
+		},
+		&cli.StringFlag{
+			Name:        "archive-password",
+			Destination: &c.ArchivePassword,
+			Usage:       "password of an encrypted source archive, " + archivePasswordEnv + " is used otherwise",
+		},
+		&cli.StringFlag{
+			Name:        "session-source",
+			Destination: &c.SessionSource,
+			Usage:       "describe where this import comes from, e.g. 'red SD card', recorded in the index with the session",
+		},
+		&cli.StringFlag{
+			Name:        "meta-sidecar",
+			Destination: &c.MetaSidecar,
+			Usage:       "describe every imported file with its date, camera, gps, source and hash in a json next to it with file, or in a " + folderSidecarName + " per folder with folder",
+		},
+		&cli.BoolFlag{
+			Name:        "session-xmp",
+			Destination: &c.SessionXmp,
+			Usage:       "also tag the xmp sidecar of every imported file with the session, creating one if needed",
+		},
 	},
 	Action: mediaTool,
 }
@@ -174,154 +632,789 @@ func main() {
 	mediaToolApp := &cli.App{
 		Name:    "media tool",
 		Usage:   "a tool to mange media files",
-		Version: "v0.0.1",
+		Version: version,
 		Commands: []*cli.Command{
 			fileCommand,
+			importCommand,
+			explainCommand,
 			extensionCommand,
+			dedupeCommand,
+			auditCommand,
+			fixCommand,
+			mergeFoldersCommand,
+			diffCommand,
+			syncCommand,
+			decryptCommand,
+			packCommand,
+			indexCommand,
+			configCommand,
+			versionCommand,
+			selfUpdateCommand,
 		},
 	}
 	if err := mediaToolApp.Run(os.Args); err != nil {
 		log.Fatal(err)
 	}
-
+
+}
+
+func scanExtension(_ *cli.Context) error {
+	fileList, err := walkDirectory(c.Destination)
+	if err != nil {
+		return err
+	}
+	extensionList := make([]string, 0)
+	for _, file := range fileList {
+		extension := getFileExtension(file, true)
+		if !contains(extensionList, extension) {
+			log.Infof("file %s ,extension: %s", file, extension)
+			extensionList = append(extensionList, extension)
+		}
+	}
+
+	for _, extension := range extensionList {
+		log.Infoln(extension)
+	}
+	return nil
+}
+
+func contains[T comparable](elems []T, v T) bool {
+	for _, e := range elems {
+		if v == e {
+			return true
+		}
+	}
+	return false
+}
+
+func loadConfigFile() error {
+	if c.ConfigPath == "" {
+		c.ConfigPath = defaultConfigPath
+	}
+	log.Infof("load config file: %s\n", c.ConfigPath)
+	yamlFile, err := os.ReadFile(c.ConfigPath)
+	if err != nil {
+		return err
+	}
+	err = yaml.Unmarshal(yamlFile, &y)
+	if err != nil {
+		panic(err)
+	}
+	if err = checkSkipProfiles(y.SkipProfiles); err != nil {
+		return err
+	}
+	if err = checkTiers(y.Tiers); err != nil {
+		return err
+	}
+	return checkStrategies(y.Strategies)
+}
+
+func mediaTool(_ *cli.Context) (err error) {
+	if err = setupConsole(); err != nil {
+		return err
+	}
+	err = loadConfigFile()
+	if err != nil {
+		return err
+	}
+	if c.Source == "" && c.FilesFrom == "" {
+		return fmt.Errorf("either --source or --files-from is needed")
+	}
+	c.Destination = expandVars(c.Destination, hostVars())
+	if c.Label == "" && c.Source != "" {
+		source := c.Source
+		if isGlob(source) {
+			source = globRoot(source)
+		}
+		if c.Label = volumeLabel(source); c.Label != "" {
+			log.Infof("label files %q after their volume", c.Label)
+		}
+	}
+	// with the list on stdin there is nobody left to answer a prompt
+	if c.FilesFrom == "-" && !c.Yes && !c.Dry {
+		return fmt.Errorf("--files-from - needs --yes or --dry")
+	}
+	if c.NormalizeExt != "" && c.NormalizeExt != "lower" && c.NormalizeExt != "upper" {
+		return fmt.Errorf("--normalize-ext is lower or upper, not %q", c.NormalizeExt)
+	}
+	if err = checkTrashed(); err != nil {
+		return err
+	}
+	if err = checkStats(); err != nil {
+		return err
+	}
+	if err = checkLayout(); err != nil {
+		return err
+	}
+	if err = checkOrder(); err != nil {
+		return err
+	}
+	if err = checkOwnership(); err != nil {
+		return err
+	}
+	if err = checkMetaSidecar(); err != nil {
+		return err
+	}
+	if err = checkAppleEdits(); err != nil {
+		return err
+	}
+	if err = checkBurst(); err != nil {
+		return err
+	}
+	if err = checkSimulateErrors(); err != nil {
+		return err
+	}
+	if err = checkPreserve(); err != nil {
+		return err
+	}
+	if c.SkipOrganized {
+		organizedShapes = templateShapes()
+	}
+	if c.Mode == "export" {
+		if _, err = currentPreset(); err != nil {
+			return err
+		}
+	}
+	if c.Upload != "" {
+		activeUploader, err = newUploader(c.Upload)
+		if err != nil {
+			return err
+		}
+	}
+	twoPhase := c.Mode == "move" && c.TwoPhase
+	if twoPhase {
+		// deletions only happen once the whole batch is verified
+		c.Together = true
+		if !c.Dry {
+			finishPendingDeletions()
+		}
+	}
+	if c.ConfirmOver > 0 || c.Order != "" || c.PreviewTree {
+		// the plan has to be complete before its size is known, it can be
+		// sorted or previewed
+		c.Together = true
+	}
+	if len(c.GPX.Value()) > 0 {
+		if track, err = loadGPX(c.GPX.Value()); err != nil {
+			return err
+		}
+	}
+	if c.CachePath != "" {
+		exifCache, err = loadMetaCache(c.CachePath)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := exifCache.save(c.CachePath); err != nil {
+				log.Errorf("error saving cache %s: %v", c.CachePath, err)
+			}
+		}()
+	}
+	if c.SnapshotBefore {
+		if err = snapshotBefore(); err != nil {
+			return err
+		}
+	}
+	if !c.Dry {
+		catalog, err = loadIndex(indexPath())
+		if err != nil {
+			return err
+		}
+		log.Infof("import session %s", session)
+		defer func() {
+			if err := catalog.save(indexPath()); err != nil {
+				log.Errorf("error saving index: %v", err)
+			}
+		}()
+	}
+	if c.Heartbeat > 0 || c.StatusFile != "" {
+		every := c.Heartbeat
+		if every <= 0 {
+			every = time.Minute
+		}
+		defer startHeartbeat(every, c.StatusFile)()
+	}
+	if c.Manifest && !c.Dry && (c.WriteExif || c.WriteGPS || c.Mode == "export" || isArchive(c.Source)) {
+		return fmt.Errorf("--manifest cannot be combined with --write-exif, --write-gps, export mode or an archive source")
+	}
+	var mediaFiles <-chan string
+	var waitScan func() error
+	if c.FromFailures != "" {
+		items, err := readFailures(c.FromFailures)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", c.FromFailures, err)
+		}
+		log.Infof("retry %d failed files from %s", len(items), c.FromFailures)
+		mediaFiles, waitScan = streamFailures(items)
+	} else if c.FilesFrom != "" {
+		mediaFiles, waitScan = streamFileList(c.FilesFrom)
+	} else if isGlob(c.Source) {
+		pattern := c.Source
+		c.Source = globRoot(pattern)
+		excludeDestinations(c.Source)
+		mediaFiles, waitScan = streamGlob(pattern)
+	} else if isArchive(c.Source) {
+		archive, staging := c.Source, stagingDir(c.Source)
+		defer os.RemoveAll(staging)
+		// extracted files are temporary, so they are always moved into place
+		if c.Mode == "copy" {
+			c.Mode = "move"
+		}
+		c.Source = staging
+		mediaFiles, waitScan = streamArchive(archive, staging)
+	} else {
+		excludeDestinations(c.Source)
+		mediaFiles, waitScan = streamMediaFiles(c.Source)
+	}
+	var manifest map[string]string
+	if c.Manifest && !c.Dry {
+		// the files the run is given, as they are before any of them moves
+		if manifest, mediaFiles, err = snapshotSource(mediaFiles, waitScan, c.Workers); err != nil {
+			return err
+		}
+		waitScan = func() error { return nil }
+	}
+	resetCounters()
+	todo := make([]planItem, 0)
+	generated := make(map[string][]string)
+	placed := make(map[string]string)
+	planned := make(plannedNames)
+	corrupt := make(map[string]string)
+	wrongExtensions := 0
+	places := startPlacer(c.Workers)
+	defer places.wait()
+
+	for file := range prefetchMeta(mediaFiles, c.Workers) {
+		status.working(file)
+		status.scanned()
+		if !settled(file) || skipAndroid(file) {
+			continue
+		}
+		// what is over the budget is still drained from the scan
+		if overBudget(file) {
+			continue
+		}
+		if sniffed := wrongExtension(file); sniffed != "" {
+			log.Warnf("file %s holds a %s, not what its extension says", file, sniffed)
+			wrongExtensions++
+		}
+		if c.SkipOrganized && organized(file) {
+			action(labelSkip, "%s is already organized", file)
+			continue
+		}
+		// companions are planned together with their primary image
+		if primary := primaryOf(file); primary != "" {
+			log.Debugf("file %s travels with %s", file, primary)
+			continue
+		}
+		var newPath string
+		var meta *mediaMeta
+		if reason := checkCorruptIfEnabled(file); reason != nil {
+			log.Warnf("file %s is corrupt: %v", file, reason)
+			corrupt[file] = reason.Error()
+			newPath = filepath.Join(corruptDir, filepath.Base(file))
+		} else {
+			newPath, meta, err = processImage(file)
+			if errors.Is(err, errNoRoom) {
+				return err
+			}
+			if err != nil {
+				continue
+			}
+			// the edit is named and checked for conflicts in its own right
+			file, newPath = useAppleEdit(file, newPath)
+			if state, _, _ := androidState(file); state == "trashed" && c.Trashed == "separate" {
+				newPath = filepath.Join(trashedDir, newPath)
+			}
+		}
+		if newPath != "" {
+			root := ""
+			if meta != nil {
+				root = meta.Root
+			} else if root, err = placeRoot(file, nil); err != nil {
+				return err
+			}
+			newPath = filepath.Join(root, newPath)
+		}
+		generated[newPath] = append(generated[newPath], file)
+		if sameFile(file, newPath) {
+			action(labelSkip, "%s is already in place", file)
+			continue
+		}
+		generatedPath := newPath
+		newPath, err = resolveExisting(file, newPath, meta)
+		if err != nil {
+			continue
+		}
+		conflict := ""
+		if newPath != generatedPath {
+			conflict = "renamed, " + generatedPath + " exists"
+		}
+		var renamed string
+		newPath, renamed, err = planned.claim(file, newPath)
+		if err != nil {
+			continue
+		}
+		if renamed != "" {
+			conflict = renamed
+		}
+
+		item := planItem{Source: file, Dest: newPath, Meta: meta}
+		placed[file] = newPath
+		if err = planCompanions(&item); err != nil {
+			action(labelSkip, "%s: %v", file, err)
+			continue
+		}
+
+		if c.Posters != "" {
+			addPoster(item)
+		}
+		if c.PlanReport != "" {
+			recordPlanRow(item, generatedPath, conflict)
+		}
+		if c.PreviewTree {
+			recordPreview(item)
+		}
+		spendBudget()
+		if c.Dry {
+			if err = injectFault(c.Mode, newPath); err != nil {
+				action(labelFail, "%s: %v", file, err)
+				continue
+			}
+			takeCounters(meta)
+			action(modeLabel(), "%s -> %s", item.Source, item.Dest)
+			if meta != nil && !meta.OriginalTime.IsZero() {
+				log.Infof("  taken %s, corrected by %s to %s", meta.OriginalTime.Format(time.DateTime),
+					meta.Time.Sub(meta.OriginalTime), meta.Time.Format(time.DateTime))
+			}
+			for _, companion := range item.Companions {
+				decide("file %s travels along to %s", companion.Source, companion.Dest)
+			}
+			continue
+		}
+		if c.Together {
+			decide("will %s file %s -> %s later", c.Mode, file, newPath)
+			takeCounters(meta)
+			todo = append(todo, item)
+		} else {
+			if !c.Yes {
+				hit := fmt.Sprintf("Are you sure you want to %s\n%s\n->\n%s?\n", c.Mode, item.Source, item.Dest)
+				if !askForConfirmation(hit) {
+					continue
+				}
+			}
+			if err = checkFreeSpace([]planItem{item}); err != nil {
+				return err
+			}
+			takeCounters(meta)
+			places.place(item)
+		}
+	}
+	places.wait()
+
+	if err = waitScan(); err != nil {
+		return err
+	}
+
+	if c.Posters != "" {
+		if err = writeReviewPage(c.Posters); err != nil {
+			log.Errorf("error writing review page: %v", err)
+		}
+	}
+	clashes := findNameClashes(generated, placed)
+	err = reportNameClashes(clashes, c.ClashReport)
+	if err != nil {
+		log.Errorf("error writing name clash report: %v", err)
+	}
+	if c.PlanReport != "" {
+		if err = writePlanReport(c.PlanReport, clashes); err != nil {
+			log.Errorf("error writing plan report: %v", err)
+		}
+	}
+	if c.PreviewTree {
+		printPreviewTree(previewDests)
+	}
+	if wrongExtensions > 0 && !c.FixExt {
+		log.Warnf("%d files have the extension of another type, --fix-ext corrects it in their destination name", wrongExtensions)
+	}
+	if len(corrupt) > 0 {
+		log.Warnf("%d corrupt files will be quarantined in %s", len(corrupt), corruptDir)
+		if !c.Dry {
+			if err = writeCorruptReport(corrupt); err != nil {
+				log.Errorf("error writing corrupt file report: %v", err)
+			}
+		}
+	}
+
+	if c.Together && !c.Dry && len(todo) > 0 {
+		if err = checkFreeSpace(todo); err != nil {
+			return err
+		}
+		if c.ConfirmOver > 0 && len(todo) <= c.ConfirmOver {
+			log.Infof("%d files planned, no confirmation needed", len(todo))
+		} else if !c.Yes {
+			if todo = confirmPlan(todo); len(todo) == 0 {
+				return nil
+			}
+		}
+		sortPlan(todo)
+		if twoPhase {
+			commitMoves(todo)
+		} else {
+			processFiles(todo)
+		}
+	}
+
+	if c.RelinkCSV != "" && !c.Dry {
+		if err = writeRelinkCSV(c.RelinkCSV); err != nil {
+			log.Errorf("error writing relink csv: %v", err)
+		}
+	}
+	if activeUploader != nil && !c.Dry {
+		if err = activeUploader.Finish(); err != nil {
+			log.Errorf("error finishing upload: %v", err)
+		}
+	}
+
+	if !c.Dry {
+		if err = writeFailures(failuresPath()); err != nil {
+			log.Errorf("error writing failures: %v", err)
+		}
+		if c.Stats != "" {
+			writeYearStats()
+		}
+		if c.MetaSidecar == sidecarPerFolder {
+			writeFolderSidecars()
+		}
+		runCompleteHook()
+	}
+	if manifest != nil {
+		if err = verifyManifest(manifest); err != nil {
+			return err
+		}
+	}
+
+	if left := budgetLeftOver(); left > 0 {
+		log.Infof("%d files left for the next run", left)
+	}
+	printSummary()
+
+	return nil
+}
+
+// stdin is shared by every prompt so none of them reads ahead into the
+// answers meant for the next one.
+var stdin = bufio.NewReader(os.Stdin)
+
+func askForConfirmation(prompt string) bool {
+	for {
+		fmt.Printf("%s [y/n]: ", prompt)
+
+		response, err := stdin.ReadString('\n')
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		response = strings.ToLower(strings.TrimSpace(response))
+
+		if response == "y" || response == "yes" {
+			return true
+		} else if response == "n" || response == "no" {
+			return false
+		}
+	}
+}
+
+func filtering() bool {
+	return c.MinRating > 0 || len(c.Keywords.Value()) > 0
+}
+
+// matchesFilters reports whether a file passes the rating and keyword
+// filters; keywords are compared case-insensitively and all must be present.
+func matchesFilters(meta *mediaMeta) bool {
+	if meta.Rating < c.MinRating {
+		return false
+	}
+	for _, keyword := range c.Keywords.Value() {
+		found := false
+		for _, k := range meta.Keywords {
+			if strings.EqualFold(k, keyword) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func checkCorruptIfEnabled(file string) error {
+	if !c.CheckCorrupt {
+		return nil
+	}
+	return checkCorrupt(file)
+}
+
+// planItem is one file of the plan and where it is going.
+type planItem struct {
+	Source string
+	Dest   string
+	Meta   *mediaMeta
+	// Companions are placed together with the file, e.g. its sidecar.
+	Companions []planItem
+}
+
+func processFiles(items []planItem) {
+	places := startPlacer(c.Workers)
+	for i, item := range items {
+		if outOfTime(len(items) - i) {
+			break
+		}
+		places.place(item)
+	}
+	places.wait()
+}
+
+// finishFile runs the optional steps that follow a file reaching its
+// destination.
+func finishFile(item planItem) {
+	action(modeLabel(), "%s -> %s", item.Source, item.Dest)
+	status.placed(item.Dest)
+	// the file is written to first, so the index and sidecars see its
+	// final size and hash
+	corrected := false
+	if c.WriteExif && item.Meta != nil && !item.Meta.OriginalTime.IsZero() {
+		err := rewriteExifTime(item.Dest, item.Meta.OriginalTime, item.Meta.Time)
+		if err != nil {
+			log.Errorf("error writing corrected time to %s: %v", item.Dest, err)
+		}
+		corrected = err == nil
+	}
+	if c.WriteGPS && item.Meta != nil && item.Meta.GPSDerived {
+		if err := writeGPS(item.Dest, *item.Meta.GPS); err != nil {
+			log.Errorf("error writing gps to %s: %v", item.Dest, err)
+		}
+	}
+	for _, f := range item.files() {
+		if catalog != nil {
+			catalog.add(f.Source, f.Dest, f.Meta)
+		}
+		if c.Manifest {
+			recordPlaced(f.Source, f.Dest)
+		}
+		if c.MetaSidecar != "" {
+			recordMetaSidecar(f.Source, f.Dest, item.Meta)
+		}
+		if ownershipSet() {
+			applyOwnership(f.Dest)
+		}
+	}
+	if corrected && catalog != nil {
+		catalog.markCorrected(item.Dest)
+	}
+	if c.Stats != "" {
+		recordYear(item.Dest, item.Meta)
+	}
+	if c.Layout == layoutPlex && item.Meta != nil && plexVideoTemplate(item.Source, item.Meta) != "" {
+		if err := writePlexNfo(item.Dest, item.Meta.Time); err != nil {
+			log.Errorf("error writing nfo of %s: %v", item.Dest, err)
+		}
+	}
+	if c.SessionXmp {
+		if err := tagSessionSidecar(item.Dest); err != nil {
+			log.Errorf("error tagging %s with the session: %v", item.Dest, err)
+		}
+	}
+	if c.Synology {
+		indexSynology(item.Dest)
+	}
+	if activeUploader != nil {
+		if err := activeUploader.Upload(item.Dest, albumFor(item), item.Meta); err != nil {
+			log.Errorf("error uploading %s: %v", item.Dest, err)
+		}
+	}
+	fileImportedHook(item)
+}
+
+// commitMoves moves files in two phases: everything is copied and verified
+// first, and sources are only deleted once the whole batch verified. A batch
+// that fails is rolled back, its copies removed, so a later run finds the
+// library as it was. Each step is written to the journal so an interrupted
+// run leaves a record.
+func commitMoves(items []planItem) {
+	j, err := openJournal(journalPath())
+	if err != nil {
+		log.Errorf("error opening journal: %v", err)
+		return
+	}
+	defer j.Close()
+
+	copied := make([]planItem, 0, len(items))
+	// every file copied, also those of an item that failed halfway
+	written := make([]planItem, 0, len(items))
+	for i, item := range items {
+		// the sources of the files copied so far are still deleted
+		if outOfTime(len(items) - i) {
+			items = items[:i]
+			break
+		}
+		status.working(item.Source)
+		failed := false
+		for _, f := range item.files() {
+			err := unchanged(f.Source)
+			if err == nil {
+				err = copyAndVerify(f.Source, f.Dest)
+			}
+			if err != nil {
+				recordFailure(f.Source, f.Dest, err)
+				j.record(opFailed, f.Source, f.Dest, err)
+				failed = true
+				break
+			}
+			j.record(opCopied, f.Source, f.Dest, nil)
+			written = append(written, f)
+		}
+		if !failed {
+			copied = append(copied, item)
+		}
+	}
+	if len(copied) < len(items) {
+		log.Errorf("%d of %d files failed, no source was deleted, remove the copies", len(items)-len(copied), len(items))
+		for _, f := range written {
+			if err := os.Remove(f.Dest); err != nil {
+				log.Errorf("error removing copy %s: %v", f.Dest, err)
+				continue
+			}
+			j.record(opRolledBack, f.Source, f.Dest, nil)
+		}
+		return
+	}
+
+	for _, item := range copied {
+		for _, f := range item.files() {
+			j.record(opPendingDelete, f.Source, f.Dest, nil)
+		}
+	}
+	for _, item := range copied {
+		for _, f := range item.files() {
+			err := injectFault("remove", f.Source)
+			if err == nil {
+				err = removeFile(f.Source)
+			}
+			if err != nil {
+				log.Errorf("error deleting source %s: %v", f.Source, err)
+				j.record(opFailed, f.Source, f.Dest, err)
+				continue
+			}
+			j.record(opDeleted, f.Source, f.Dest, nil)
+			recordRelink(f.Source, f.Dest)
+		}
+		finishFile(item)
+	}
 }
 
-func scanExtension(_ *cli.Context) error {
-	fileList, err := walkDirectory(c.Destination)
+// finishPendingDeletions completes deletions an earlier two-phase run
+// recorded but never finished, as long as the copy still verifies.
+func finishPendingDeletions() {
+	entries, err := readJournal(journalPath())
 	if err != nil {
-		return err
+		log.Errorf("error reading journal: %v", err)
+		return
 	}
-	extensionList := make([]string, 0)
-	for _, file := range fileList {
-		extension := getFileExtension(file, true)
-		if !contains(extensionList, extension) {
-			log.Infof("file %s ,extension: %s", file, extension)
-			extensionList = append(extensionList, extension)
-		}
+	pending := pendingDeletions(entries)
+	if len(pending) == 0 {
+		return
 	}
 
-	for _, extension := range extensionList {
-		log.Infoln(extension)
+	j, err := openJournal(journalPath())
+	if err != nil {
+		log.Errorf("error opening journal: %v", err)
+		return
 	}
-	return nil
-}
+	defer j.Close()
 
-func contains[T comparable](elems []T, v T) bool {
-	for _, e := range elems {
-		if v == e {
-			return true
+	log.Warnf("found %d pending deletions from a previous run", len(pending))
+	for s, d := range pending {
+		if !fileExists(s) {
+			j.record(opDeleted, s, d, nil)
+			continue
+		}
+		if err := verifyCopy(s, d); err != nil {
+			log.Errorf("keep source %s: %v", s, err)
+			continue
 		}
+		if err := removeFile(s); err != nil {
+			log.Errorf("error deleting source %s: %v", s, err)
+			continue
+		}
+		log.Infof("deleted source %s left over by a previous run", s)
+		j.record(opDeleted, s, d, nil)
 	}
-	return false
 }
 
-func loadConfigFile() error {
-	if c.ConfigPath == "" {
-		c.ConfigPath = defaultConfigPath
-	}
-	log.Infof("load config file: %s\n", c.ConfigPath)
-	yamlFile, err := os.ReadFile(c.ConfigPath)
+func copyAndVerify(source, dest string) error {
+	destinationFile, err := createDestinationDir(dest)
 	if err != nil {
 		return err
 	}
-	err = yaml.Unmarshal(yamlFile, &y)
+	if err = copyFile(source, destinationFile); err == nil {
+		err = verifyCopy(source, destinationFile)
+	}
 	if err != nil {
-		panic(err)
+		discardPartial(destinationFile)
+		return err
+	}
+	if c.VerifyVideo {
+		if err = checkVideo(destinationFile); err != nil {
+			return fmt.Errorf("video check of %s failed: %w", destinationFile, err)
+		}
 	}
 	return nil
 }
 
-func mediaTool(_ *cli.Context) (err error) {
-	if c.Debug {
-		log.SetLevel(log.DebugLevel)
+// discardPartial removes what a failed copy left at dest, so a retry does
+// not take it for a finished file. With --overwrite dest may have been
+// there before and is left alone.
+func discardPartial(dest string) {
+	if c.OverWrite {
+		return
 	}
-	err = loadConfigFile()
+	if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+		log.Errorf("error removing partial copy %s: %v", dest, err)
+	}
+}
+
+// verifyCopy checks that dst has the same size and content as src.
+func verifyCopy(src, dst string) error {
+	if err := injectFault("verify", dst); err != nil {
+		return err
+	}
+	srcInfo, err := os.Stat(src)
 	if err != nil {
 		return err
 	}
-	imageFileList, _, _, err := getMediaFileList(c.Source)
+	dstInfo, err := os.Stat(dst)
 	if err != nil {
 		return err
 	}
-	todoMap := make(map[string]string)
-
-	for _, file := range imageFileList {
-		newPath, err := processImage(file)
-		if err != nil {
-			continue
-		}
-		if newPath != "" {
-			newPath = filepath.Join(c.Destination, newPath)
-		}
-		newPath, err = checkExist(newPath)
-		if err != nil {
-			continue
-		}
-
-		if c.Dry {
-			log.Infof("file %s -> %s", file, newPath)
-			continue
-		}
-		if c.Together {
-			log.Infof("will %s file %s -> %s later", c.Mode, file, newPath)
-			todoMap[file] = newPath
-		} else {
-			if !c.Yes {
-				hit := fmt.Sprintf("Are you sure you want to %s\n%s\n->\n%s?\n", c.Mode, file, newPath)
-				if !askForConfirmation(hit) {
-					continue
-				}
-			}
-			err := processOneFile(file, newPath)
-			if err != nil {
-				continue
-			}
-		}
+	if srcInfo.Size() != dstInfo.Size() {
+		return fmt.Errorf("size mismatch: %s has %d bytes, %s has %d", src, srcInfo.Size(), dst, dstInfo.Size())
 	}
 
-	if c.Together && !c.Dry && len(todoMap) > 0 {
-		hit := fmt.Sprintf("Are you sure you want to %s all files?\n", c.Mode)
-		if !c.Yes {
-			if !askForConfirmation(hit) {
-				return nil
-			}
-		}
-		processFiles(todoMap)
+	srcHash, err := fullHash(src)
+	if err != nil {
+		return err
 	}
-
-	log.Infoln("finished")
-
-	return nil
-}
-
-func askForConfirmation(prompt string) bool {
-	reader := bufio.NewReader(os.Stdin)
-	for {
-		fmt.Printf("%s [y/n]: ", prompt)
-
-		response, err := reader.ReadString('\n')
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		response = strings.ToLower(strings.TrimSpace(response))
-
-		if response == "y" || response == "yes" {
-			return true
-		} else if response == "n" || response == "no" {
-			return false
-		}
+	dstHash, err := fullHash(dst)
+	if err != nil {
+		return err
 	}
-}
-
-func processFiles(m map[string]string) {
-	for s, d := range m {
-		err := processOneFile(s, d)
-		if err != nil {
-			log.Errorf("error processing %s: %v", s, err)
-			continue
-		}
+	if srcHash != dstHash {
+		return fmt.Errorf("content mismatch between %s and %s", src, dst)
 	}
+	return nil
 }
 
 func processOneFile(source, dest string) error {
@@ -330,17 +1423,42 @@ func processOneFile(source, dest string) error {
 		return err
 	}
 
+	if c.Layout == layoutCAS {
+		return placeObject(source, destinationFile)
+	}
+	// the file --overwrite displaces can be put back from the trash
+	if c.UseOSTrash && c.OverWrite && fileExists(destinationFile) {
+		if err = removeFile(destinationFile); err != nil {
+			return err
+		}
+	}
+
+	release := acquireWrite(destinationFile)
 	switch c.Mode {
 	case "copy":
-		err = copyFile(source, destinationFile)
+		err = copyOrLink(source, destinationFile)
+		release()
+		if err == nil && c.Verify {
+			err = verifyCopy(source, destinationFile)
+		}
 		if err != nil {
+			discardPartial(destinationFile)
 			return err
 		}
 	case "move":
 		err = moveFile(source, destinationFile)
+		release()
+		if err != nil {
+			return err
+		}
+	case "export":
+		err = exportFile(source, destinationFile)
+		release()
 		if err != nil {
 			return err
 		}
+	default:
+		release()
 	}
 
 	return nil
@@ -352,7 +1470,7 @@ func checkExist(dest string) (string, error) {
 			return dest, nil
 		}
 		if !c.NoSkip {
-			log.Infof("file %s already exists, skip", dest)
+			action(labelSkip, "%s already exists", dest)
 			return "", fmt.Errorf("%s already exists", dest)
 		}
 		return generateNewFileName(dest), nil
@@ -392,202 +1510,333 @@ func generateNewFileName(filename string) string {
 	return newFileName
 }
 
-func processImage(file string) (newPath string, err error) {
-	// Check if the file has any EXIF data
-	newPath = readExif(file)
-	if newPath != "" {
-		return
-	}
+// mediaMeta is what a naming strategy learned about a file.
+type mediaMeta struct {
+	Time  time.Time
+	Model string
+	// Event is a free text label such as the "Paris" of "2019-07 Paris".
+	Event string
+	// MonthOnly is set when only the year and month are known.
+	MonthOnly bool
+	// OriginalTime is the capture time before any clock correction.
+	OriginalTime time.Time
+	Rating       int
+	Keywords     []string
+	// App is the chat app the file was exported from, e.g. "wechat".
+	App string
+	// Class is "screenshot" or "screen_recording" for screen captures,
+	// "scan" for document scans and "document" for PDFs.
+	Class string
+	GPS   *gpsPoint
+	// GPSDerived is set when GPS comes from a GPX track, not the file.
+	GPSDerived bool
+	// Strategy names the date strategy that dated the file.
+	Strategy string
+	// Serial and LensSerial tell apart bodies and lenses of one model.
+	Serial     string
+	LensSerial string
+	// Width and Height are in pixels, zero when not known.
+	Width, Height int
+	// Duration and Codec describe the video track of a video.
+	Duration time.Duration
+	Codec    string
+	// Root is the destination root the file goes under and Counters the
+	// folder counters given to its name, by folder and pattern.
+	Root     string
+	Counters map[string]int
+}
 
-	// Check if the file matches the wxExport pattern
-	newPath = matchWxExport(file)
-	if newPath != "" {
-		return
+func processImage(file string) (newPath string, meta *mediaMeta, err error) {
+	var sidecar *mediaMeta
+	if c.Xmp || filtering() {
+		sidecar = readXmpSidecar(file)
 	}
 
-	// Check if the file matches any regex pattern
-	newPath = matchRegex(file)
-	if newPath != "" {
-		return
+	meta, strategy := dateFile(file)
+	if meta != nil {
+		meta.Strategy = strategy
 	}
 
-	//try fstat finally
-	newPath = getModifiedFilePath(file)
-	if newPath != "" {
-		return
+	// If no strategy knows the date, return an error
+	if meta == nil {
+		return "", nil, fmt.Errorf("failed to generate new file name for %s", file)
+	}
+	if sidecar == nil && filtering() {
+		sidecar = readEmbeddedXmp(file)
+	}
+	if sidecar != nil {
+		meta.Rating = sidecar.Rating
+		meta.Keywords = sidecar.Keywords
+	}
+	if filtering() && isJPEG(file) {
+		meta.Keywords = mergeKeywords(meta.Keywords, readIPTCKeywords(file))
+	}
+	if meta.App == "" {
+		meta.App = chatApp(file)
+	}
+	meta.Class = classify(file, meta)
+	meta.Width, meta.Height = mediaSize(file)
+	if belowMinMP(file, meta) {
+		action(labelSkip, "%s: %.2f megapixels", file, megapixels(meta))
+		return "", nil, fmt.Errorf("%s is below --min-mp", file)
+	}
+	if seconds, codec := videoInfo(file); seconds > 0 || codec != "" {
+		meta.Duration = time.Duration(seconds * float64(time.Second))
+		meta.Codec = codec
+	}
+	if c.MinDuration > 0 && meta.Duration > 0 && meta.Duration < c.MinDuration {
+		action(labelSkip, "%s: %s long", file, meta.Duration.Round(time.Millisecond))
+		return "", nil, fmt.Errorf("%s is shorter than --min-duration", file)
+	}
+	if !matchesFilters(meta) {
+		action(labelSkip, "%s: rating %d, keywords %v", file, meta.Rating, meta.Keywords)
+		return "", nil, fmt.Errorf("%s does not match the filters", file)
 	}
 
-	// If none of the conditions above are met, return an error
-	return "", fmt.Errorf("failed to generate new file name for %s", file)
+	if rule, ok := y.Cameras[meta.Model]; ok {
+		if rule.Skip {
+			action(labelSkip, "%s: camera %s is skipped", file, meta.Model)
+			return "", nil, fmt.Errorf("camera %s is skipped", meta.Model)
+		}
+		offset, err := rule.offsetAt(meta.Time)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid offset for camera %s: %w", meta.Model, err)
+		}
+		// a library file written with --write-exif is corrected already
+		if offset != 0 && !clockCorrected(file) {
+			meta.OriginalTime = meta.Time
+			meta.Time = meta.Time.Add(offset)
+		}
+	}
+	if meta.GPS == nil && len(track) > 0 {
+		if meta.GPS = track.locate(meta.Time); meta.GPS != nil {
+			meta.GPSDerived = true
+		}
+	}
+	decide("file %s dated %s by %s, class %q, app %q", file, meta.Time.Format(time.DateTime), meta.Strategy, meta.Class, meta.App)
+	if meta.Root, err = placeRoot(file, meta); err != nil {
+		return "", nil, err
+	}
+	return buildPath(file, meta), meta, nil
 }
 
-func getModifiedFilePath(file string) string {
+func getModifiedTime(file string) *mediaMeta {
 	fileInfo, err := os.Stat(file)
 	if err != nil {
 		log.Errorf("error getting file info for %s: %v", file, err)
-		return ""
+		return nil
 	}
-	tm := fileInfo.ModTime()
-	modTime := tm.Format("2006/01")
-	date := tm.Format("2006-01-02")
+	return &mediaMeta{Time: fileInfo.ModTime()}
+}
 
-	fileBase := filepath.Base(file)
+func readExif(file string) *mediaMeta {
+	return decodeExifCached(file)
+}
 
-	return filepath.Join(modTime, date, fileBase)
+func modelAlias(model string) string {
+	if rule, ok := y.Cameras[model]; ok && rule.Alias != "" {
+		return rule.Alias
+	}
+	alias := y.ModelMap[model]
+	if alias == "" {
+		alias = strings.Replace(model, " ", "-", -1)
+	}
+	return alias
 }
 
-func readExif(file string) string {
+// decodeExif reads the camera model and capture time of a file.
+func decodeExif(file string) *mediaMeta {
 	fileHandle, err := os.Open(file)
 	if err != nil {
-		return ""
+		return nil
 	}
+	defer fileHandle.Close()
 
-	exifData, err := exif.Decode(fileHandle)
-	if err != nil {
-		return ""
+	exifData, decodeErr := exif.Decode(fileHandle)
+	if decodeErr != nil && (exifData == nil || exif.IsCriticalError(decodeErr)) {
+		log.Debugf("error decoding exif of %s, scanning it instead: %v", file, decodeErr)
+		return scanExif(file)
 	}
 
 	modelInfo, err := exifData.Get("Model")
 	if err != nil {
-		return ""
+		return nil
 	}
 	model := getTagString(modelInfo)
 
-	modelAlias := y.ModelMap[model]
-	if modelAlias == "" {
-		modelAlias = strings.Replace(model, " ", "-", -1)
-	}
-
 	timeInfo, err := exifData.Get("DateTimeOriginal")
 	if err != nil {
-		return ""
+		// the EXIF sub-IFD holding it may be what failed to decode
+		if decodeErr != nil {
+			return scanExif(file)
+		}
+		return nil
 	}
 
 	tm, _ := time.Parse(layout, getTagString(timeInfo))
+	if subSec, err := exifData.Get(exif.SubSecTimeOriginal); err == nil {
+		tm = tm.Add(parseSubSec(getTagString(subSec)))
+	}
 
-	year := tm.Format("2006")
-	month := tm.Format("01")
-	date := tm.Format("2006-01-02")
-
-	fileBase := filepath.Base(file)
-
-	return filepath.Join(modelAlias, year, month, date, fileBase)
-}
-
-func getTagString(tag *tiff.Tag) string {
-	tagString := tag.String()
-	return strings.Trim(tagString, "\"")
+	meta := &mediaMeta{Time: tm, Model: model}
+	meta.Serial, meta.LensSerial = readSerials(exifData)
+	if lat, lon, err := exifData.LatLong(); err == nil {
+		meta.GPS = &gpsPoint{Lat: lat, Lon: lon}
+	}
+	return meta
 }
 
-func matchWxExport(filename string) string {
-	pattern := `mmexport(1\d{9})`
-	regex := regexp.MustCompile(pattern)
-	matches := regex.FindStringSubmatch(filename)
-
-	if len(matches) == 0 {
-		return ""
+// parseSubSec reads the digits of a SubSecTimeOriginal tag, the fraction of
+// the second a photo was taken at which tells the shots of a burst apart.
+func parseSubSec(digits string) time.Duration {
+	digits = strings.TrimSpace(digits)
+	if digits == "" || len(digits) > 9 {
+		return 0
 	}
-
-	timestamp := matches[1]
-	timestampInt, err := strconv.ParseInt(timestamp, 10, 64)
+	ns, err := strconv.Atoi(digits + strings.Repeat("0", 9-len(digits)))
 	if err != nil {
-		log.Errorf("error parsing timestamp %s: %v", timestamp, err)
-		return ""
+		return 0
 	}
+	return time.Duration(ns)
+}
 
-	tm := time.Unix(timestampInt, 0)
-	year := tm.Format("2006")
-	month := tm.Format("01")
-	date := tm.Format("2006-01-02")
-
-	fileBase := filepath.Base(filename)
-
-	return filepath.Join(year, month, date, fileBase)
+func getTagString(tag *tiff.Tag) string {
+	tagString := tag.String()
+	return strings.Trim(tagString, "\"")
 }
 
-func matchRegex(file string) string {
+func matchRegex(file string) *mediaMeta {
 	for pattern, layout := range regexTime {
 		regex := regexp.MustCompile(pattern)
 		matches := regex.FindStringSubmatch(file)
 		if len(matches) > 0 {
 			match := matches[0]
 			t, _ := time.Parse(layout, match)
-			year := t.Format("2006")
-			month := t.Format("01")
-			date := t.Format("2006-01-02")
-			fileBase := filepath.Base(file)
-			return filepath.Join(year, month, date, fileBase)
+			return &mediaMeta{Time: t}
 		}
 	}
-	return ""
+	return nil
 }
 
-func getMediaFileList(dir string) ([]string, []string, []string, error) {
-	imageFiles := make([]string, 0)
-	videoFiles := make([]string, 0)
-	audioFiles := make([]string, 0)
+var (
+	dirDayPattern   = regexp.MustCompile(`^(\d{4})[-_.]?(\d{2})[-_.]?(\d{2})(?:\D(.*))?$`)
+	dirMonthPattern = regexp.MustCompile(`^(\d{4})[-_.](\d{2})(?:\D(.*))?$`)
+)
 
-	fileList, err := walkDirectory(dir)
-	if err != nil {
-		return nil, nil, nil, err
+// matchParentDir looks for a date, and an optional event name after it, in
+// the names of the directories between the source root and the file,
+// starting with the closest one.
+func matchParentDir(file string) *mediaMeta {
+	rel, err := filepath.Rel(c.Source, filepath.Dir(file))
+	if err != nil || rel == "." {
+		return nil
+	}
+	dirs := strings.Split(rel, string(filepath.Separator))
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		name := dirs[i]
+		if matches := dirDayPattern.FindStringSubmatch(name); matches != nil {
+			t, err := time.Parse("2006-01-02", matches[1]+"-"+matches[2]+"-"+matches[3])
+			if err == nil {
+				return &mediaMeta{Time: t, Event: cleanEventName(matches[4])}
+			}
+		}
+		if matches := dirMonthPattern.FindStringSubmatch(name); matches != nil {
+			t, err := time.Parse("2006-01", matches[1]+"-"+matches[2])
+			if err == nil {
+				return &mediaMeta{Time: t, Event: cleanEventName(matches[3]), MonthOnly: true}
+			}
+		}
 	}
+	return nil
+}
 
-	for _, file := range fileList {
-		ext := getFileExtension(file, false)
+func cleanEventName(name string) string {
+	return strings.Trim(name, " -_.")
+}
 
-		if picTypes[ext] {
-			imageFiles = append(imageFiles, file)
+// streamMediaFiles streams the image and video files under dir. The
+// returned function must be called once the channel is drained and reports
+// any walk error.
+func streamMediaFiles(dir string) (<-chan string, func() error) {
+	files, wait := streamDirectory(dir)
+	mediaFiles := make(chan string)
+
+	go func() {
+		defer close(mediaFiles)
+		for file := range files {
+			if isMediaFile(file) {
+				mediaFiles <- file
+			}
 		}
+	}()
 
-		if videoTypes[ext] {
-			videoFiles = append(videoFiles, file)
-		}
+	return mediaFiles, wait
+}
 
-		if AudioTypes[ext] {
-			audioFiles = append(audioFiles, file)
-		}
+func isMediaFile(file string) bool {
+	ext := getFileExtension(file, false)
+	if ext == "" {
+		ext = sniffExtension(file)
 	}
-
-	return imageFiles, videoFiles, audioFiles, nil
+	return picTypes[ext] || videoTypes[ext] || docTypes[ext]
 }
 
 func walkDirectory(dirPath string) ([]string, error) {
-	log.Infof("scanning dir: %s", dirPath)
-
 	var fileList []string
-	if _, err := os.Stat(dirPath); os.IsNotExist(err) {
+	files, wait := streamDirectory(dirPath)
+	for file := range files {
+		fileList = append(fileList, file)
+	}
+	if err := wait(); err != nil {
 		return nil, err
 	}
+	return fileList, nil
+}
 
-	err := filepath.WalkDir(dirPath, func(path string, file fs.DirEntry, err error) error {
-		if err != nil {
-			return err
+// streamDirectory walks dirPath in the background and sends every file that
+// is not skipped, so callers never hold the whole tree in memory.
+func streamDirectory(dirPath string) (<-chan string, func() error) {
+	log.Infof("scanning dir: %s", dirPath)
+
+	files := make(chan string, 64)
+	done := make(chan error, 1)
+
+	go func() {
+		defer close(files)
+		if _, err := os.Stat(dirPath); os.IsNotExist(err) {
+			done <- err
+			return
 		}
-		if file.IsDir() {
-			log.Debugf("scanning dir: %s", path)
-			if contains(y.SkipDir, file.Name()) {
-				log.Infof("skip dir: %s", path)
-				return filepath.SkipDir
-			}
 
-		} else {
-			log.Debugf("scanning file: %s", path)
-			if contains(y.SkipFile, file.Name()) {
-				log.Infof("skip file: %s", path)
-				return nil
+		done <- filepath.WalkDir(dirPath, func(path string, file fs.DirEntry, err error) error {
+			if err != nil {
+				return err
 			}
-			fileList = append(fileList, path)
+			if file.IsDir() {
+				log.Debugf("scanning dir: %s", path)
+				if contains(y.SkipDir, file.Name()) || file.Name() == metaDirName || skipSynologyDir(file.Name()) || isObjectStore(path) || excludedDirs[absPath(path)] || profileSkipsDir(filepath.ToSlash(path)) {
+					log.Infof("skip dir: %s", path)
+					return filepath.SkipDir
+				}
 
-		}
+			} else {
+				log.Debugf("scanning file: %s", path)
+				if contains(y.SkipFile, file.Name()) || profileSkipsFile(file.Name()) {
+					log.Infof("skip file: %s", path)
+					return nil
+				}
+				files <- path
 
-		return nil
-	})
+			}
 
-	if err != nil {
-		return nil, err
-	}
+			return nil
+		})
+	}()
 
-	return fileList, nil
+	return files, func() error {
+		return <-done
+	}
 }
 
 func getFileExtension(path string, needDot bool) string {
@@ -599,7 +1848,67 @@ func getFileExtension(path string, needDot bool) string {
 }
 
 func moveFile(src, dst string) error {
-	return os.Rename(src, dst)
+	if err := injectFault("rename", src); err != nil {
+		return err
+	}
+	err := os.Rename(src, dst)
+	var linkErr *os.LinkError
+	if err == nil || !errors.As(err, &linkErr) || !errors.Is(linkErr.Err, syscall.EXDEV) {
+		return err
+	}
+
+	// across filesystems a move is a verified copy followed by a delete
+	if err = copyAndVerify(src, dst); err != nil {
+		return err
+	}
+	return removeFile(src)
+}
+
+// copiedLinks remembers where a hardlinked source inode was first copied to,
+// so its other names are linked to that copy instead of duplicated.
+var copiedLinks = struct {
+	sync.Mutex
+	m map[fileID]string
+}{m: make(map[fileID]string)}
+
+func copyOrLink(src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return fmt.Errorf("error reading source file: %w", err)
+	}
+	id, ok := fileIdentity(info)
+	if !ok || hardlinkCount(info) < 2 {
+		return copyFile(src, dst)
+	}
+
+	copiedLinks.Lock()
+	first, done := copiedLinks.m[id]
+	copiedLinks.Unlock()
+	if done {
+		if err = os.Link(first, dst); err == nil {
+			log.Infof("file %s is a hardlink of %s, linked %s -> %s", src, first, dst, first)
+			return nil
+		}
+		log.Debugf("error linking %s -> %s, copying instead: %v", dst, first, err)
+	}
+
+	if err = copyFile(src, dst); err != nil {
+		return err
+	}
+	copiedLinks.Lock()
+	copiedLinks.m[id] = dst
+	copiedLinks.Unlock()
+	return nil
+}
+
+// checkPreserve rejects --preserve values that would be ignored.
+func checkPreserve() error {
+	for _, what := range c.Preserve.Value() {
+		if what != "xattrs" && what != "sparse" {
+			return fmt.Errorf("--preserve is xattrs or sparse, not %q", what)
+		}
+	}
+	return nil
 }
 
 func copyFile(src, dst string) error {
@@ -615,7 +1924,14 @@ func copyFile(src, dst string) error {
 	}
 	defer destination.Close()
 
-	_, err = io.Copy(destination, source)
+	if contains(c.Preserve.Value(), "sparse") {
+		err = copySparse(destination, source)
+	} else {
+		_, err = io.Copy(destination, source)
+	}
+	if err == nil {
+		err = injectFault("write", dst)
+	}
 	if err != nil {
 		return fmt.Errorf("error copying file: %w", err)
 	}
@@ -625,5 +1941,50 @@ func copyFile(src, dst string) error {
 		return fmt.Errorf("error syncing destination file: %w", err)
 	}
 
+	if contains(c.Preserve.Value(), "xattrs") {
+		if err = copyXattrs(src, dst); err != nil {
+			log.Warnf("file %s copied without all of its xattrs: %v", src, err)
+		}
+	}
+
 	return nil
 }
+
+// copySparse copies src to dst, seeking over all-zero blocks instead of
+// writing them so the destination keeps the holes of a sparse source.
+func copySparse(dst *os.File, src *os.File) error {
+	const blockSize = 4096
+	buf := make([]byte, 64*blockSize)
+	zero := make([]byte, blockSize)
+	var size int64
+
+	for {
+		n, err := io.ReadFull(src, buf)
+		if n > 0 {
+			for start := 0; start < n; start += blockSize {
+				end := start + blockSize
+				if end > n {
+					end = n
+				}
+				block := buf[start:end]
+				if bytes.Equal(block, zero[:len(block)]) {
+					if _, err := dst.Seek(int64(len(block)), io.SeekCurrent); err != nil {
+						return err
+					}
+				} else if _, err := dst.Write(block); err != nil {
+					return err
+				}
+			}
+			size += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// a trailing hole is only materialized by setting the final size
+	return dst.Truncate(size)
+}
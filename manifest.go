@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// placedFiles maps every source placed this run to its destination, for
+// checking a manifest afterwards.
+var placedFiles = struct {
+	sync.Mutex
+	dests map[string]string
+}{dests: make(map[string]string)}
+
+func recordPlaced(source, dest string) {
+	placedFiles.Lock()
+	placedFiles.dests[absPath(source)] = dest
+	placedFiles.Unlock()
+}
+
+// snapshotSource hashes every file the scan finds before any is placed and
+// saves the result next to the journal. The files are handed on to be
+// planned once the scan is done.
+func snapshotSource(stream <-chan string, waitScan func() error, workers int) (map[string]string, <-chan string, error) {
+	files := make([]string, 0)
+	for file := range stream {
+		files = append(files, file)
+	}
+	if err := waitScan(); err != nil {
+		return nil, nil, err
+	}
+	replay := make(chan string, len(files))
+	for _, file := range files {
+		replay <- file
+	}
+	close(replay)
+
+	manifest := make(map[string]string, len(files))
+	for hash, same := range hashFiles(files, workers, fullHash) {
+		for _, file := range same {
+			manifest[absPath(file)] = hash
+		}
+	}
+	if len(manifest) < len(files) {
+		return nil, nil, fmt.Errorf("could only hash %d of %d source files", len(manifest), len(files))
+	}
+
+	path := filepath.Join(c.Destination, metaDirName, "manifest-"+time.Now().Format("20060102-150405")+".json")
+	if err := createParentDir(filepath.Dir(path)); err != nil {
+		return nil, nil, err
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, nil, err
+	}
+	log.Infof("write source manifest of %d files: %s", len(manifest), path)
+	return manifest, replay, os.WriteFile(path, data, 0644)
+}
+
+// verifyManifest checks that every file of the manifest is either still in
+// the source or was placed, with the content it had before the run.
+func verifyManifest(manifest map[string]string) error {
+	lost, altered := 0, 0
+	for source, hash := range manifest {
+		file := source
+		if !fileExists(file) {
+			placedFiles.Lock()
+			dest, ok := placedFiles.dests[source]
+			placedFiles.Unlock()
+			if !ok || !fileExists(dest) {
+				log.Errorf("file %s is gone from the source and not in the destination", source)
+				lost++
+				continue
+			}
+			file = dest
+		}
+		got, err := fullHash(file)
+		if err != nil || got != hash {
+			log.Errorf("file %s no longer matches the manifest of %s", file, source)
+			altered++
+		}
+	}
+	if lost > 0 || altered > 0 {
+		return fmt.Errorf("manifest check failed: %d lost, %d altered", lost, altered)
+	}
+	log.Infof("all %d files of the source manifest are accounted for", len(manifest))
+	return nil
+}
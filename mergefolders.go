@@ -0,0 +1,289 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+var mergeFoldersCommand = &cli.Command{
+	Name:  "merge-folders",
+	Usage: "merge folders the current template sees as one, such as 2021/7 and 2021/07",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:        "dir",
+			Aliases:     []string{"d"},
+			Destination: &c.Destination,
+			Usage:       "the library to merge folders of",
+			Required:    true,
+		},
+		&cli.StringFlag{
+			Name:        "config",
+			Aliases:     []string{"c"},
+			Destination: &c.ConfigPath,
+			Usage:       "yaml config file path",
+			DefaultText: "config.yaml",
+			Required:    false,
+		},
+		&cli.BoolFlag{
+			Name:        "dry",
+			Destination: &c.Dry,
+			Usage:       "only show the folders that would be merged",
+		},
+		&cli.BoolFlag{
+			Name:        "yes",
+			Aliases:     []string{"y"},
+			Destination: &c.Yes,
+			Usage:       "do not ask before merging",
+		},
+	},
+	Action: mergeFolders,
+}
+
+// folderMove is a file that goes along with its folder.
+type folderMove struct {
+	Source, Dest string
+}
+
+// runVars depend on who runs media_tool, where and with what, not on the
+// files, so they cannot tell where files already in a library belong.
+var runVars = []string{"{user}", "{hostname}", "{label}"}
+
+// checkMergeTemplates refuses templates using runVars: rendered for this
+// run, they would send every folder of another user or label to this one's.
+func checkMergeTemplates() error {
+	for _, t := range configuredTemplates() {
+		// the album names uploads, not folders
+		if t.name == "album" {
+			continue
+		}
+		for _, v := range runVars {
+			if strings.Contains(t.template, v) {
+				return fmt.Errorf("%s %q uses %s, which merge-folders cannot know for files already in the library", t.name, t.template, v)
+			}
+		}
+	}
+	return nil
+}
+
+func mergeFolders(_ *cli.Context) error {
+	if err := loadConfigFile(); err != nil {
+		return err
+	}
+	if err := checkMergeTemplates(); err != nil {
+		return err
+	}
+	root := c.Destination
+	// the library is its own source, so folder dates resolve against it
+	c.Source = root
+	files, err := walkDirectory(root)
+	if err != nil {
+		return err
+	}
+
+	// the catalog knows which files carry their camera offset already
+	if err = loadCatalog(); err != nil {
+		return err
+	}
+	targets := folderTargets(root, files)
+	merges := make(map[string]string)
+	moves := make([]folderMove, 0)
+	for _, file := range files {
+		dir := relDir(root, file)
+		newDir := mergedDir(targets, dir)
+		if newDir == dir {
+			continue
+		}
+		moves = append(moves, folderMove{Source: file, Dest: filepath.Join(root, filepath.FromSlash(newDir), filepath.Base(file))})
+		// name the outermost folder that changes, not every one below it
+		for folder := dir; folder != "."; folder = path.Dir(folder) {
+			target := mergedDir(targets, folder)
+			if path.Base(target) != path.Base(folder) || mergedDir(targets, path.Dir(folder)) != path.Dir(target) {
+				merges[folder] = target
+			}
+		}
+	}
+	if len(moves) == 0 {
+		log.Infoln("no folders to merge")
+		return nil
+	}
+
+	folders := make([]string, 0, len(merges))
+	for folder := range merges {
+		folders = append(folders, folder)
+	}
+	sort.Strings(folders)
+	for _, folder := range folders {
+		log.Infof("merge %s into %s", folder, merges[folder])
+	}
+	if c.Dry {
+		log.Infof("%d files would move", len(moves))
+		return nil
+	}
+	if !c.Yes && !askForConfirmation(fmt.Sprintf("Are you sure you want to merge these %d folders, moving %d files?\n", len(folders), len(moves))) {
+		return fmt.Errorf("not confirmed")
+	}
+
+	catalog, err = loadIndex(indexPath())
+	if err != nil {
+		return err
+	}
+	j, err := openJournal(journalPath())
+	if err != nil {
+		return err
+	}
+	defer j.Close()
+
+	sort.Slice(moves, func(a, b int) bool { return moves[a].Source < moves[b].Source })
+	moved, duplicates := 0, 0
+	left := make(map[string]bool)
+	for _, m := range moves {
+		dup, err := mergeFile(j, m)
+		if err != nil {
+			log.Errorf("error merging %s: %v", m.Source, err)
+			continue
+		}
+		if dup {
+			duplicates++
+		} else {
+			moved++
+		}
+		left[filepath.Dir(m.Source)] = true
+	}
+	removeEmptyDirs(root, left)
+	log.Infof("merged %d folders: moved %d files, removed %d duplicates", len(folders), moved, duplicates)
+	return catalog.save(indexPath())
+}
+
+// folderTargets works out, for every folder holding media, the folder the
+// current template puts its files in at the same depth. A folder whose files
+// disagree is left out: it is not a copy of another one, fix sorts it out.
+func folderTargets(root string, files []string) map[string]string {
+	targets := make(map[string]string)
+	split := make(map[string]bool)
+	for _, file := range files {
+		if !isMediaFile(file) || primaryOf(file) != "" {
+			continue
+		}
+		newPath, _, err := processImage(file)
+		if err != nil {
+			log.Debugf("skip file %s: %v", file, err)
+			continue
+		}
+		have := strings.Split(relDir(root, file), "/")
+		want := strings.Split(filepath.ToSlash(filepath.Dir(newPath)), "/")
+		if have[0] == "." {
+			continue
+		}
+		for depth := 1; depth <= len(have); depth++ {
+			folder := strings.Join(have[:depth], "/")
+			target := ""
+			if depth <= len(want) && want[0] != "." {
+				target = strings.Join(want[:depth], "/")
+			}
+			if known, ok := targets[folder]; target == "" || ok && known != target {
+				split[folder] = true
+			}
+			targets[folder] = target
+		}
+	}
+	for folder := range split {
+		delete(targets, folder)
+	}
+	return targets
+}
+
+// mergedDir is where the files of dir go: below the target of the deepest
+// folder containing dir that has one.
+func mergedDir(targets map[string]string, dir string) string {
+	for folder := dir; folder != "."; folder = path.Dir(folder) {
+		if target, ok := targets[folder]; ok {
+			return path.Join(target, strings.TrimPrefix(strings.TrimPrefix(dir, folder), "/"))
+		}
+	}
+	return dir
+}
+
+// relDir is the slash separated folder of file relative to root.
+func relDir(root, file string) string {
+	rel, err := filepath.Rel(root, filepath.Dir(file))
+	if err != nil {
+		return "."
+	}
+	return filepath.ToSlash(rel)
+}
+
+// mergeFile moves one file into its merged folder. A file already there
+// with the same content makes it a duplicate, which is removed; one with
+// other content keeps both under a numbered name.
+func mergeFile(j *journal, m folderMove) (duplicate bool, err error) {
+	dest := m.Dest
+	if fileExists(dest) {
+		sourceHash, err := fullHash(m.Source)
+		if err != nil {
+			return false, err
+		}
+		destHash, err := fullHash(dest)
+		if err != nil {
+			return false, err
+		}
+		if sourceHash == destHash {
+			if err = removeFile(m.Source); err != nil {
+				return false, err
+			}
+			j.record(opDeleted, m.Source, dest, nil)
+			catalog.remove(m.Source)
+			log.Infof("removed %s, the same as %s", m.Source, dest)
+			return true, nil
+		}
+		dest = freeName(dest)
+	}
+	if _, err = createDestinationDir(dest); err != nil {
+		return false, err
+	}
+	if err = moveFile(m.Source, dest); err != nil {
+		j.record(opFailed, m.Source, dest, err)
+		return false, err
+	}
+	j.record(opMoved, m.Source, dest, nil)
+	catalog.move(m.Source, dest)
+	log.Infof("moved %s -> %s", m.Source, dest)
+	return false, nil
+}
+
+// freeName numbers name until it does not exist: IMG_1.jpg, IMG_1_2.jpg.
+func freeName(name string) string {
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	for n := 2; ; n++ {
+		candidate := stem + "_" + strconv.Itoa(n) + ext
+		if !fileExists(candidate) {
+			return candidate
+		}
+	}
+}
+
+// removeEmptyDirs removes the folders files left, and their parents up to
+// root, as long as nothing else is in them.
+func removeEmptyDirs(root string, dirs map[string]bool) {
+	sorted := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		sorted = append(sorted, dir)
+	}
+	// deepest first
+	sort.Slice(sorted, func(a, b int) bool { return len(sorted[a]) > len(sorted[b]) })
+	for _, dir := range sorted {
+		for ; dir != root && dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	sidecarPerFile   = "file"
+	sidecarPerFolder = "folder"
+	// folderSidecarName describes every file of a folder with --meta-sidecar
+	// folder.
+	folderSidecarName = "metadata.json"
+)
+
+// fileSidecar is what --meta-sidecar writes about a placed file, so the
+// library describes itself without the index.
+type fileSidecar struct {
+	Taken  time.Time `json:"taken,omitempty"`
+	Model  string    `json:"model,omitempty"`
+	Serial string    `json:"serial,omitempty"`
+	GPS    *gpsPoint `json:"gps,omitempty"`
+	Source string    `json:"source"`
+	SHA256 string    `json:"sha256"`
+}
+
+// folderSidecars collects the files placed into every folder, keyed by
+// name, until writeFolderSidecars merges them into the folder's file.
+var folderSidecars = struct {
+	sync.Mutex
+	dirs map[string]map[string]fileSidecar
+}{dirs: make(map[string]map[string]fileSidecar)}
+
+func checkMetaSidecar() error {
+	switch c.MetaSidecar {
+	case "", sidecarPerFile, sidecarPerFolder:
+		return nil
+	}
+	return fmt.Errorf("--meta-sidecar is file or folder, not %q", c.MetaSidecar)
+}
+
+// recordMetaSidecar describes a placed file, in IMG_1.JPG.json next to it or
+// for the folder sidecar.
+func recordMetaSidecar(source, dest string, meta *mediaMeta) {
+	hash, err := fullHash(dest)
+	if err != nil {
+		log.Errorf("error hashing %s for its sidecar: %v", dest, err)
+		return
+	}
+	sidecar := fileSidecar{Source: absPath(source), SHA256: hash}
+	if meta != nil {
+		sidecar.Taken, sidecar.Model, sidecar.Serial, sidecar.GPS = meta.Time, meta.Model, meta.Serial, meta.GPS
+	}
+
+	if c.MetaSidecar == sidecarPerFolder {
+		dir := filepath.Dir(dest)
+		folderSidecars.Lock()
+		if folderSidecars.dirs[dir] == nil {
+			folderSidecars.dirs[dir] = make(map[string]fileSidecar)
+		}
+		folderSidecars.dirs[dir][filepath.Base(dest)] = sidecar
+		folderSidecars.Unlock()
+		return
+	}
+	data, err := json.MarshalIndent(sidecar, "", "  ")
+	if err == nil {
+		err = os.WriteFile(dest+".json", data, 0644)
+	}
+	if err != nil {
+		log.Errorf("error writing sidecar of %s: %v", dest, err)
+	}
+}
+
+// writeFolderSidecars merges the files placed during this run into the
+// sidecar of their folder, dropping entries whose file is gone.
+func writeFolderSidecars() {
+	folderSidecars.Lock()
+	defer folderSidecars.Unlock()
+	dirs := make([]string, 0, len(folderSidecars.dirs))
+	for dir := range folderSidecars.dirs {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		path := filepath.Join(dir, folderSidecarName)
+		files := make(map[string]fileSidecar)
+		if data, err := os.ReadFile(path); err == nil {
+			if err = json.Unmarshal(data, &files); err != nil {
+				log.Warnf("rewrite unreadable sidecar %s: %v", path, err)
+			}
+		}
+		for name := range files {
+			if !fileExists(filepath.Join(dir, name)) {
+				delete(files, name)
+			}
+		}
+		for name, sidecar := range folderSidecars.dirs[dir] {
+			files[name] = sidecar
+		}
+		data, err := json.MarshalIndent(files, "", "  ")
+		if err == nil {
+			err = os.WriteFile(path, data, 0644)
+		}
+		if err != nil {
+			log.Errorf("error writing sidecar %s: %v", path, err)
+		}
+	}
+}
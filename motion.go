@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// --burst-policy choices of which file of a group stands for the shot
+const (
+	burstCover   = "cover"
+	burstLargest = "largest"
+	burstFirst   = "first"
+)
+
+// --burst-keep choices
+const (
+	burstKeepAll     = "all"
+	burstKeepPrimary = "primary"
+)
+
+// Phones leave several files for one shot: Pixel Top Shot bursts
+// (00001IMG_00001_BURST20210714150405123_COVER.jpg, or
+// PXL_20210714_150405123.RAW-01.COVER.jpg on newer ones), motion photos
+// next to their plain still (PXL_20210714_150405123.MP.jpg) and the ~2
+// copies Samsung's gallery makes of a name that exists.
+var (
+	topShotName = regexp.MustCompile(`^(\d{5})IMG_\d{5}_BURST(\d+)(_COVER)?\.(?i:jpe?g)$`)
+	pixelName   = regexp.MustCompile(`^(PXL_\d{8}_\d{6,9})(?:\.RAW-(\d+))?(\.MP)?(\.COVER|\.ORIGINAL)?\.(?i:jpe?g)$`)
+	copyName    = regexp.MustCompile(`^(.+?)(\.MP)?(~\d+)?\.(?i:jpe?g)$`)
+	// shotMarkers are what the names above add to the name of the shot
+	shotMarkers = regexp.MustCompile(`(?:\.RAW-\d+)?(?:\.MP)?(?:~\d+)?(?:[._]COVER|\.ORIGINAL)?$`)
+)
+
+// shotFile is one file of a group of artifacts of one shot.
+type shotFile struct {
+	Path string
+	// Frame orders the frames of a burst, Copy the ~2 copies.
+	Frame, Copy          int
+	Burst, Cover, Motion bool
+}
+
+// artifact reports whether a file is more than a plain photo, which it
+// takes for a group to be a shot and not two photos sharing a name.
+func (f shotFile) artifact() bool {
+	return f.Burst || f.Cover || f.Motion || f.Copy > 0
+}
+
+// shotKey names the shot a file belongs to, or returns "" for a file that
+// is not a phone artifact.
+func shotKey(name string) (string, shotFile) {
+	if m := topShotName.FindStringSubmatch(name); m != nil {
+		frame, _ := strconv.Atoi(m[1])
+		return "BURST" + m[2], shotFile{Frame: frame, Burst: true, Cover: m[3] != ""}
+	}
+	if m := pixelName.FindStringSubmatch(name); m != nil {
+		frame, _ := strconv.Atoi(m[2])
+		return m[1], shotFile{Frame: frame, Burst: m[2] != "", Motion: m[3] != "", Cover: m[4] == ".COVER"}
+	}
+	if m := copyName.FindStringSubmatch(name); m != nil && (m[2] != "" || m[3] != "") {
+		n := 0
+		if m[3] != "" {
+			n, _ = strconv.Atoi(m[3][1:])
+		}
+		return m[1], shotFile{Copy: n, Motion: m[2] != ""}
+	}
+	return "", shotFile{}
+}
+
+// shotGroups caches the groups of each directory, by shot key; only groups
+// of more than one file are kept.
+var shotGroups = struct {
+	sync.Mutex
+	dirs map[string]map[string][]shotFile
+}{dirs: make(map[string]map[string][]shotFile)}
+
+func groupsOf(dir string) map[string][]shotFile {
+	shotGroups.Lock()
+	defer shotGroups.Unlock()
+	if groups, ok := shotGroups.dirs[dir]; ok {
+		return groups
+	}
+	groups := make(map[string][]shotFile)
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		name := entry.Name()
+		key, f := shotKey(name)
+		// a plain name joins the group its artifacts make
+		if key == "" {
+			key = strings.TrimSuffix(name, filepath.Ext(name))
+			if !primaryTypes[getFileExtension(name, false)] {
+				continue
+			}
+		}
+		f.Path = filepath.Join(dir, name)
+		groups[key] = append(groups[key], f)
+	}
+	for key, files := range groups {
+		artifacts := 0
+		for _, f := range files {
+			if f.artifact() {
+				artifacts++
+			}
+		}
+		if len(files) < 2 || artifacts == 0 {
+			delete(groups, key)
+		}
+	}
+	shotGroups.dirs[dir] = groups
+	return groups
+}
+
+// shotGroup returns the files of the shot file belongs to, primary first,
+// or nil when it stands alone.
+func shotGroup(file string) []shotFile {
+	name := filepath.Base(file)
+	key, _ := shotKey(name)
+	if key == "" {
+		key = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	files := groupsOf(filepath.Dir(file))[key]
+	if len(files) == 0 {
+		return nil
+	}
+	sorted := append([]shotFile{}, files...)
+	sort.SliceStable(sorted, func(i, j int) bool { return betterPrimary(sorted[i], sorted[j]) })
+	return sorted
+}
+
+// betterPrimary reports whether a should stand for the shot before b. By
+// default the cover Top Shot picked wins, then a motion photo as it holds
+// the still too, then the plain name over its copies.
+func betterPrimary(a, b shotFile) bool {
+	switch c.BurstPolicy {
+	case burstLargest:
+		if sa, sb := fileSize(a.Path), fileSize(b.Path); sa != sb {
+			return sa > sb
+		}
+	case burstFirst:
+		if a.Frame != b.Frame {
+			return a.Frame < b.Frame
+		}
+	default:
+		if a.Cover != b.Cover {
+			return a.Cover
+		}
+		if a.Motion != b.Motion {
+			return a.Motion
+		}
+	}
+	if a.Copy != b.Copy {
+		return a.Copy < b.Copy
+	}
+	if a.Frame != b.Frame {
+		return a.Frame < b.Frame
+	}
+	return a.Path < b.Path
+}
+
+func fileSize(file string) int64 {
+	info, err := os.Stat(file)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+func checkBurst() error {
+	switch c.BurstPolicy {
+	case "", burstCover, burstLargest, burstFirst:
+	default:
+		return fmt.Errorf("--burst-policy is cover, largest or first, not %q", c.BurstPolicy)
+	}
+	switch c.BurstKeep {
+	case "", burstKeepAll, burstKeepPrimary:
+		return nil
+	}
+	return fmt.Errorf("--burst-keep is all or primary, not %q", c.BurstKeep)
+}
+
+// shotPrimary returns the file standing for the shot of an artifact, or ""
+// when file is that one or stands alone.
+func shotPrimary(file string) string {
+	group := shotGroup(file)
+	if len(group) == 0 || group[0].Path == file {
+		return ""
+	}
+	return group[0].Path
+}
+
+// shotCompanions returns the other files of the shot a primary stands for,
+// unless only primaries are kept. Copies identical to a file kept are left
+// out.
+func shotCompanions(file string) []string {
+	group := shotGroup(file)
+	if len(group) == 0 || group[0].Path != file || c.BurstKeep == burstKeepPrimary {
+		return nil
+	}
+	kept := []string{file}
+	for _, f := range group[1:] {
+		copyOf := ""
+		for _, k := range kept {
+			if sameContent(k, f.Path) {
+				copyOf = k
+				break
+			}
+		}
+		if copyOf != "" {
+			log.Infof("skip %s, a copy of %s", f.Path, copyOf)
+			continue
+		}
+		kept = append(kept, f.Path)
+	}
+	return kept[1:]
+}
+
+func sameContent(a, b string) bool {
+	if fileSize(a) != fileSize(b) {
+		return false
+	}
+	ha, err := fullHash(a)
+	if err != nil {
+		return false
+	}
+	hb, err := fullHash(b)
+	return err == nil && ha == hb
+}
+
+// shotCompanionDest names another file of a shot after the destination of
+// its primary, less the marks of the primary, marked by what it is: _burst02
+// for a frame, .MP for a motion photo, ~2 for a copy and nothing for the
+// plain still. It returns "" for other companions.
+func shotCompanionDest(source, companion, dest string) string {
+	group := shotGroup(source)
+	if len(group) == 0 || group[0].Path != source {
+		return ""
+	}
+	for _, f := range group[1:] {
+		if f.Path != companion {
+			continue
+		}
+		stem := shotMarkers.ReplaceAllString(strings.TrimSuffix(dest, filepath.Ext(dest)), "")
+		ext := normalizeExt(filepath.Ext(companion))
+		switch {
+		case f.Burst:
+			return fmt.Sprintf("%s_burst%02d%s", stem, f.Frame, ext)
+		case f.Copy > 0:
+			return fmt.Sprintf("%s~%d%s", stem, f.Copy, ext)
+		case f.Motion:
+			return stem + ".MP" + ext
+		}
+		// a renamed motion photo has lost the mark that told the still apart
+		if strings.EqualFold(stem+ext, dest) {
+			return stem + "_still" + ext
+		}
+		return stem + ext
+	}
+	return ""
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// execution orders of --order
+const (
+	orderSize = "size"
+	orderDate = "date"
+	orderPath = "path"
+)
+
+func checkOrder() error {
+	switch c.Order {
+	case "", orderSize, orderDate, orderPath:
+		return nil
+	}
+	return fmt.Errorf("--order is size, date or path, not %q", c.Order)
+}
+
+// sortPlan orders the plan by --order: smallest files first, so they are
+// done before the huge videos, newest first, so recent photos show up
+// first, or by source path.
+func sortPlan(items []planItem) {
+	switch c.Order {
+	case orderSize:
+		sizes := make(map[string]int64, len(items))
+		for _, item := range items {
+			if info, err := os.Stat(item.Source); err == nil {
+				sizes[item.Source] = info.Size()
+			}
+		}
+		sort.SliceStable(items, func(i, j int) bool {
+			return sizes[items[i].Source] < sizes[items[j].Source]
+		})
+	case orderDate:
+		// quarantined corrupt files have no date and come last
+		sort.SliceStable(items, func(i, j int) bool {
+			a, b := items[i].Meta, items[j].Meta
+			if a == nil || b == nil {
+				return b == nil && a != nil
+			}
+			return a.Time.After(b.Time)
+		})
+	case orderPath:
+		sort.SliceStable(items, func(i, j int) bool {
+			return items[i].Source < items[j].Source
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// overflowRoots are the destination followed by the overflow roots, in the
+// order they are filled.
+func overflowRoots() []string {
+	return append([]string{c.Destination}, c.Overflow.Value()...)
+}
+
+// destinationRoots are the overflow roots and the roots of tier rules, every
+// root the library has files under.
+func destinationRoots() []string {
+	roots := overflowRoots()
+	for _, rule := range y.Tiers {
+		roots = append(roots, rule.Destination)
+	}
+	return roots
+}
+
+var errNoRoom = errors.New("no destination has room")
+
+// rootBudgets is what is left to plan into each root: its free space when
+// first used, less the margin and everything planned into it since.
+var (
+	rootBudgets = make(map[string]int64)
+	activeRoot  int
+)
+
+// placeRoot returns the root a file goes under: the root of its tier,
+// else the destination or, with overflow roots, the first one with room.
+func placeRoot(file string, meta *mediaMeta) (string, error) {
+	root, err := tierRoot(file, meta)
+	if err != nil || root != "" {
+		return root, err
+	}
+	if len(c.Overflow.Value()) > 0 {
+		return pickRoot(file)
+	}
+	return c.Destination, nil
+}
+
+// pickRoot returns the root a source file and its companions should go to,
+// moving on to the next root once the current one cannot take them.
+func pickRoot(source string) (string, error) {
+	var size int64
+	for _, file := range append([]string{source}, companionFiles(source)...) {
+		info, err := os.Stat(file)
+		if err != nil {
+			return "", err
+		}
+		size += info.Size()
+	}
+	// tier roots only take the files of their rules
+	roots := overflowRoots()
+	for activeRoot < len(roots) {
+		root := roots[activeRoot]
+		budget, ok := rootBudgets[root]
+		if !ok {
+			free, known := freeSpace(existingParent(root))
+			if !known {
+				log.Warnf("cannot tell the free space of %s, it takes every file", root)
+				rootBudgets[root] = math.MaxInt64
+				return root, nil
+			}
+			budget = int64(free) - freeSpaceMargin
+		}
+		if size <= budget {
+			rootBudgets[root] = budget - size
+			return root, nil
+		}
+		rootBudgets[root] = budget
+		if activeRoot+1 < len(roots) {
+			log.Infof("destination %s is full, continue in %s", root, roots[activeRoot+1])
+		}
+		activeRoot++
+	}
+	return "", fmt.Errorf("%w for %s", errNoRoom, source)
+}
+
+// rootOf returns the destination root holding file, the first root when
+// none does.
+func rootOf(file string) string {
+	abs := absPath(file)
+	for _, root := range destinationRoots() {
+		rootAbs := absPath(root)
+		if abs == rootAbs || strings.HasPrefix(abs, rootAbs+string(filepath.Separator)) {
+			return root
+		}
+	}
+	return c.Destination
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// excludedDirs are destination roots inside the source, which the scan
+// leaves out so freshly placed files are not picked up again.
+var excludedDirs = make(map[string]bool)
+
+// within reports whether path is dir or lies below it.
+func within(path, dir string) bool {
+	path, dir = absPath(path), absPath(dir)
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+// excludeDestinations looks for destination roots inside the source tree.
+// Those below it are excluded from the scan; a destination that is the
+// source itself organizes it in place, where files already at their
+// planned path are skipped.
+func excludeDestinations(source string) {
+	for _, root := range destinationRoots() {
+		switch {
+		case absPath(root) == absPath(source):
+			log.Warnf("destination %s is the source, organizing it in place", root)
+		case within(root, source):
+			log.Infof("destination %s is inside the source, it is not scanned", root)
+			excludedDirs[absPath(root)] = true
+		}
+	}
+}
+
+// sameFile reports whether a and b are the same file, through links or a
+// case-insensitive file system too.
+func sameFile(a, b string) bool {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(infoA, infoB)
+}
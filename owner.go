@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ownershipConfig gives placed files and the folders holding them an owner
+// and mode, so a media server on a NAS can read what an import run as root
+// placed.
+type ownershipConfig struct {
+	User  string `yaml:"user"`
+	Group string `yaml:"group"`
+	// FileMode and DirMode are octal, e.g. "0644" and "0755".
+	FileMode string `yaml:"file_mode"`
+	DirMode  string `yaml:"dir_mode"`
+}
+
+// owner is the resolved ownership config; -1 and 0 leave the owner and the
+// mode alone.
+var owner = struct {
+	uid, gid          int
+	fileMode, dirMode os.FileMode
+	sync.Mutex
+	// dirs have been given their owner and mode already
+	dirs map[string]bool
+}{uid: -1, gid: -1, dirs: make(map[string]bool)}
+
+// checkOwnership resolves the user, group and modes of the config.
+func checkOwnership() error {
+	o := y.Ownership
+	if o.User != "" {
+		u, err := user.Lookup(o.User)
+		if err != nil {
+			return fmt.Errorf("ownership user: %w", err)
+		}
+		if owner.uid, err = strconv.Atoi(u.Uid); err != nil {
+			return fmt.Errorf("ownership user %s has no numeric id", o.User)
+		}
+	}
+	if o.Group != "" {
+		g, err := user.LookupGroup(o.Group)
+		if err != nil {
+			return fmt.Errorf("ownership group: %w", err)
+		}
+		if owner.gid, err = strconv.Atoi(g.Gid); err != nil {
+			return fmt.Errorf("ownership group %s has no numeric id", o.Group)
+		}
+	}
+	var err error
+	if owner.fileMode, err = parseMode(o.FileMode); err != nil {
+		return fmt.Errorf("ownership file_mode: %w", err)
+	}
+	if owner.dirMode, err = parseMode(o.DirMode); err != nil {
+		return fmt.Errorf("ownership dir_mode: %w", err)
+	}
+	return nil
+}
+
+func parseMode(mode string) (os.FileMode, error) {
+	if mode == "" {
+		return 0, nil
+	}
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || m > 0777 {
+		return 0, fmt.Errorf("%q is not an octal mode like 0644", mode)
+	}
+	return os.FileMode(m), nil
+}
+
+func ownershipSet() bool {
+	return owner.uid >= 0 || owner.gid >= 0 || owner.fileMode != 0 || owner.dirMode != 0
+}
+
+// applyOwnership gives a placed file and the folders between it and its
+// destination root the configured owner and mode.
+func applyOwnership(file string) {
+	setOwnership(file, owner.fileMode)
+
+	root := absPath(rootOf(file))
+	owner.Lock()
+	defer owner.Unlock()
+	for dir := filepath.Dir(absPath(file)); dir != root && within(dir, root); dir = filepath.Dir(dir) {
+		if owner.dirs[dir] {
+			break
+		}
+		owner.dirs[dir] = true
+		setOwnership(dir, owner.dirMode)
+	}
+}
+
+func setOwnership(path string, mode os.FileMode) {
+	if owner.uid >= 0 || owner.gid >= 0 {
+		if err := os.Lchown(path, owner.uid, owner.gid); err != nil {
+			log.Errorf("error changing owner of %s: %v", path, err)
+		}
+	}
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			log.Errorf("error changing mode of %s: %v", path, err)
+		}
+	}
+}
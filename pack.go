@@ -0,0 +1,275 @@
+package main
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+var packCommand = &cli.Command{
+	Name:  "pack",
+	Usage: "pack a library into fixed-size tar volumes per year for cold storage",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:        "dir",
+			Aliases:     []string{"d"},
+			Destination: &c.Destination,
+			Usage:       "the library to pack",
+			Required:    true,
+		},
+		&cli.StringFlag{
+			Name:        "out",
+			Aliases:     []string{"o"},
+			Destination: &c.Target,
+			Usage:       "folder the volumes are written to",
+			Required:    true,
+		},
+		&cli.StringFlag{
+			Name:        "volume-size",
+			Destination: &c.VolumeSize,
+			Usage:       "largest size of a volume, e.g. 25G or 4.7G",
+			Value:       "25G",
+		},
+	},
+	Action: pack,
+}
+
+// packedFile is one line of the json index written next to each volume.
+type packedFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// parseSize reads sizes such as 700M, 25G or 4.7G, in powers of 1024.
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	multiplier := 1.0
+	if s != "" {
+		if i := strings.IndexByte("KMGT", s[len(s)-1]); i >= 0 {
+			multiplier = float64(int64(1) << (10 * (i + 1)))
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * multiplier), nil
+}
+
+// tarSize is the space a file takes in a tar stream: its header blocks,
+// with the PAX records a long or non-ASCII name needs, and its data padded
+// to whole blocks.
+func tarSize(header *tar.Header) (int64, error) {
+	var blocks countingWriter
+	if err := tar.NewWriter(&blocks).WriteHeader(header); err != nil {
+		return 0, err
+	}
+	return int64(blocks) + (header.Size+511)/512*512, nil
+}
+
+// countingWriter counts what is written to it and drops it.
+type countingWriter int64
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
+
+func pack(_ *cli.Context) error {
+	limit, err := parseSize(c.VolumeSize)
+	if err != nil {
+		return err
+	}
+	catalog, err = loadIndex(indexPath())
+	if err != nil {
+		return err
+	}
+	if err = createParentDir(c.Target); err != nil {
+		return err
+	}
+
+	byYear := make(map[string][]string)
+	for key, entry := range catalog.entries {
+		if entry.Archive != "" {
+			continue
+		}
+		year := "unknown"
+		if !entry.Taken.IsZero() {
+			year = entry.Taken.Format("2006")
+		}
+		byYear[year] = append(byYear[year], key)
+	}
+	years := make([]string, 0, len(byYear))
+	for year := range byYear {
+		years = append(years, year)
+	}
+	sort.Strings(years)
+
+	for _, year := range years {
+		keys := byYear[year]
+		sort.Strings(keys)
+		if err = packYear(year, keys, limit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// packYear writes the files of one year into as many volumes as needed,
+// numbered after the volumes an earlier run left for the same year.
+func packYear(year string, keys []string, limit int64) error {
+	number := lastVolume(year)
+
+	for len(keys) > 0 {
+		number++
+		name := fmt.Sprintf("%s-%03d.tar", year, number)
+		taken, packed, err := writeVolume(filepath.Join(c.Target, name), keys, limit)
+		if err != nil {
+			return err
+		}
+		if taken == 0 {
+			return fmt.Errorf("%s does not fit in a %s volume", keys[0], c.VolumeSize)
+		}
+		if packed == 0 {
+			// only missing files, the number is still free
+			number--
+		}
+		keys = keys[taken:]
+	}
+	return nil
+}
+
+// lastVolume returns the highest number of the volumes of year that the
+// catalog or the target folder knows. Volumes moved to cold storage are
+// gone from the folder but still named in the catalog.
+func lastVolume(year string) int {
+	pattern := regexp.MustCompile(`^` + regexp.QuoteMeta(year) + `-(\d+)\.(?:tar|json)$`)
+	highest := 0
+	consider := func(name string) {
+		if m := pattern.FindStringSubmatch(name); m != nil {
+			if n, err := strconv.Atoi(m[1]); err == nil && n > highest {
+				highest = n
+			}
+		}
+	}
+	for _, entry := range catalog.entries {
+		if entry.Archive != "" {
+			consider(filepath.Base(entry.Archive))
+		}
+	}
+	if entries, err := os.ReadDir(c.Target); err == nil {
+		for _, entry := range entries {
+			consider(entry.Name())
+		}
+	}
+	return highest
+}
+
+// writeVolume packs files from keys until the next one would overflow the
+// limit and returns how many keys it took and how many files it packed.
+// Files missing from the library are skipped and stay unpacked. The index
+// is saved with every volume, so the volumes written survive a later
+// failure.
+func writeVolume(path string, keys []string, limit int64) (int, int, error) {
+	// a volume of the same name is never overwritten
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	fail := func(err error) (int, int, error) {
+		f.Close()
+		_ = os.Remove(path)
+		return 0, 0, err
+	}
+
+	var used int64 = 1024 // the two zero blocks ending the archive
+	packed := make([]packedFile, 0)
+	packedKeys := make([]string, 0)
+	taken := 0
+	for _, key := range keys {
+		entry := catalog.entries[key]
+		file := entry.path(key)
+		info, err := os.Stat(file)
+		if err != nil {
+			log.Warnf("skip %s: %v", file, err)
+			taken++
+			continue
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return fail(err)
+		}
+		header.Name = entry.name(key)
+		size, err := tarSize(header)
+		if err != nil {
+			return fail(fmt.Errorf("error packing %s: %w", file, err))
+		}
+		if used+size > limit {
+			break
+		}
+		sum, err := addToTar(tw, header, file)
+		if err != nil {
+			return fail(fmt.Errorf("error packing %s: %w", file, err))
+		}
+		used += size
+		taken++
+		packed = append(packed, packedFile{Path: header.Name, Size: info.Size(), SHA256: sum})
+		packedKeys = append(packedKeys, key)
+	}
+	if err = tw.Close(); err != nil {
+		return fail(err)
+	}
+	if len(packed) == 0 {
+		f.Close()
+		return taken, 0, os.Remove(path)
+	}
+
+	data, err := json.MarshalIndent(packed, "", "  ")
+	if err != nil {
+		return 0, 0, err
+	}
+	if err = os.WriteFile(strings.TrimSuffix(path, ".tar")+".json", data, 0644); err != nil {
+		return 0, 0, err
+	}
+	for _, key := range packedKeys {
+		catalog.entries[key].Archive = absPath(path)
+	}
+	if err = catalog.save(indexPath()); err != nil {
+		return 0, 0, fmt.Errorf("error saving index: %w", err)
+	}
+	log.Infof("packed %d files into %s", len(packed), path)
+	return taken, len(packed), nil
+}
+
+func addToTar(tw *tar.Writer, header *tar.Header, file string) (string, error) {
+	if err := tw.WriteHeader(header); err != nil {
+		return "", err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(tw, io.TeeReader(f, h)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"time"
+)
+
+// A parquet file of one row group, one uncompressed PLAIN data page per
+// column, which is all the catalog needs and every reader understands. The
+// file and page headers are thrift structures in the compact protocol.
+
+const parquetMagic = "PAR1"
+
+// physical types
+const (
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+)
+
+// converted types, -1 for none
+const (
+	parquetNoConversion    = -1
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+)
+
+const (
+	parquetEncodingPlain = 0
+	parquetEncodingRLE   = 3
+)
+
+// parquetColumn collects the values of a column, PLAIN encoded, and for an
+// optional column which rows have one.
+type parquetColumn struct {
+	name      string
+	physical  int32
+	converted int32
+	optional  bool
+	values    bytes.Buffer
+	defined   []bool
+}
+
+func stringColumn(name string) *parquetColumn {
+	return &parquetColumn{name: name, physical: parquetByteArray, converted: parquetUTF8}
+}
+
+func int64Column(name string) *parquetColumn {
+	return &parquetColumn{name: name, physical: parquetInt64, converted: parquetNoConversion}
+}
+
+// timestampColumn holds milliseconds since the epoch, null for zero times.
+func timestampColumn(name string) *parquetColumn {
+	return &parquetColumn{name: name, physical: parquetInt64, converted: parquetTimestampMillis, optional: true}
+}
+
+// doubleColumn is null where the value is 0, like the empty cells of csv.
+func doubleColumn(name string) *parquetColumn {
+	return &parquetColumn{name: name, physical: parquetDouble, converted: parquetNoConversion, optional: true}
+}
+
+func (col *parquetColumn) addString(s string) {
+	_ = binary.Write(&col.values, binary.LittleEndian, uint32(len(s)))
+	col.values.WriteString(s)
+	col.defined = append(col.defined, true)
+}
+
+func (col *parquetColumn) addInt64(v int64) {
+	_ = binary.Write(&col.values, binary.LittleEndian, v)
+	col.defined = append(col.defined, true)
+}
+
+func (col *parquetColumn) addTime(tm time.Time) {
+	if tm.IsZero() {
+		col.defined = append(col.defined, false)
+		return
+	}
+	col.addInt64(tm.UnixMilli())
+}
+
+func (col *parquetColumn) addDouble(v float64) {
+	if v == 0 {
+		col.defined = append(col.defined, false)
+		return
+	}
+	_ = binary.Write(&col.values, binary.LittleEndian, math.Float64bits(v))
+	col.defined = append(col.defined, true)
+}
+
+// page is the data page body: the definition levels of an optional column,
+// run length encoded with a bit width of 1, then the values.
+func (col *parquetColumn) page() []byte {
+	var page bytes.Buffer
+	if col.optional {
+		var levels bytes.Buffer
+		for i := 0; i < len(col.defined); {
+			run := 1
+			for i+run < len(col.defined) && col.defined[i+run] == col.defined[i] {
+				run++
+			}
+			writeUvarint(&levels, uint64(run)<<1)
+			if col.defined[i] {
+				levels.WriteByte(1)
+			} else {
+				levels.WriteByte(0)
+			}
+			i += run
+		}
+		_ = binary.Write(&page, binary.LittleEndian, uint32(levels.Len()))
+		page.Write(levels.Bytes())
+	}
+	page.Write(col.values.Bytes())
+	return page.Bytes()
+}
+
+func (col *parquetColumn) repetition() int32 {
+	if col.optional {
+		return 1
+	}
+	return 0
+}
+
+// writeParquet writes rows values of every column as a parquet file.
+func writeParquet(w io.Writer, columns []*parquetColumn, rows int) error {
+	var file bytes.Buffer
+	file.WriteString(parquetMagic)
+
+	type chunk struct {
+		offset, size int64
+	}
+	chunks := make([]chunk, len(columns))
+	for i, col := range columns {
+		page := col.page()
+		header := &thriftWriter{}
+		header.i32(1, 0) // DATA_PAGE
+		header.i32(2, int32(len(page)))
+		header.i32(3, int32(len(page)))
+		header.beginStruct(5)
+		header.i32(1, int32(rows))
+		header.i32(2, parquetEncodingPlain)
+		header.i32(3, parquetEncodingRLE)
+		header.i32(4, parquetEncodingRLE)
+		header.endStruct()
+		header.stop()
+
+		chunks[i] = chunk{int64(file.Len()), int64(header.buf.Len() + len(page))}
+		file.Write(header.buf.Bytes())
+		file.Write(page)
+	}
+
+	meta := &thriftWriter{}
+	meta.i32(1, 1)
+	meta.listHeader(2, thriftStruct, len(columns)+1)
+	meta.beginElement()
+	meta.binary(4, "schema")
+	meta.i32(5, int32(len(columns)))
+	meta.endStruct()
+	for _, col := range columns {
+		meta.beginElement()
+		meta.i32(1, col.physical)
+		meta.i32(3, col.repetition())
+		meta.binary(4, col.name)
+		if col.converted != parquetNoConversion {
+			meta.i32(6, col.converted)
+		}
+		meta.endStruct()
+	}
+	meta.i64(3, int64(rows))
+	meta.listHeader(4, thriftStruct, 1)
+	meta.beginElement()
+	meta.listHeader(1, thriftStruct, len(columns))
+	var total int64
+	for i, col := range columns {
+		meta.beginElement()
+		meta.i64(2, chunks[i].offset)
+		meta.beginStruct(3)
+		meta.i32(1, col.physical)
+		meta.listHeader(2, thriftI32, 2)
+		meta.writeVarint(parquetEncodingPlain)
+		meta.writeVarint(parquetEncodingRLE)
+		meta.listHeader(3, thriftBinary, 1)
+		meta.writeString(col.name)
+		meta.i32(4, 0) // UNCOMPRESSED
+		meta.i64(5, int64(rows))
+		meta.i64(6, chunks[i].size)
+		meta.i64(7, chunks[i].size)
+		meta.i64(9, chunks[i].offset)
+		meta.endStruct()
+		meta.endStruct()
+		total += chunks[i].size
+	}
+	meta.i64(2, total)
+	meta.i64(3, int64(rows))
+	meta.endStruct()
+	meta.binary(6, "media_tool "+version)
+	meta.stop()
+
+	file.Write(meta.buf.Bytes())
+	_ = binary.Write(&file, binary.LittleEndian, uint32(meta.buf.Len()))
+	file.WriteString(parquetMagic)
+	_, err := w.Write(file.Bytes())
+	return err
+}
+
+// thrift compact protocol types
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter writes thrift structures in the compact protocol, which
+// numbers each field by its difference to the field before it in the same
+// structure.
+type thriftWriter struct {
+	buf   bytes.Buffer
+	last  int16
+	outer []int16
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	if delta := id - t.last; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.writeVarint(int64(id))
+	}
+	t.last = id
+}
+
+// writeVarint writes a zigzag encoded varint, the form of every integer.
+func (t *thriftWriter) writeVarint(v int64) {
+	writeUvarint(&t.buf, uint64(v<<1^v>>63))
+}
+
+func (t *thriftWriter) writeString(s string) {
+	writeUvarint(&t.buf, uint64(len(s)))
+	t.buf.WriteString(s)
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.writeVarint(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.writeVarint(v)
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.writeString(s)
+}
+
+func (t *thriftWriter) listHeader(id int16, elem byte, size int) {
+	t.field(id, thriftList)
+	if size < 15 {
+		t.buf.WriteByte(byte(size)<<4 | elem)
+	} else {
+		t.buf.WriteByte(0xf0 | elem)
+		writeUvarint(&t.buf, uint64(size))
+	}
+}
+
+// beginStruct starts a structure in field id, beginElement one in a list.
+func (t *thriftWriter) beginStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.beginElement()
+}
+
+func (t *thriftWriter) beginElement() {
+	t.outer = append(t.outer, t.last)
+	t.last = 0
+}
+
+func (t *thriftWriter) endStruct() {
+	t.stop()
+	t.last = t.outer[len(t.outer)-1]
+	t.outer = t.outer[:len(t.outer)-1]
+}
+
+func (t *thriftWriter) stop() {
+	t.buf.WriteByte(0)
+}
+
+func writeUvarint(buf *bytes.Buffer, v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// thriftReader decodes thrift compact structures into maps of field id to
+// value, enough to read back what thriftWriter writes.
+type thriftReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *thriftReader) byte() byte {
+	if r.pos >= len(r.data) {
+		r.err = fmt.Errorf("thrift data ends at %d", r.pos)
+		return 0
+	}
+	r.pos++
+	return r.data[r.pos-1]
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.data[min(r.pos, len(r.data)):])
+	if n <= 0 {
+		r.err = fmt.Errorf("bad varint at %d", r.pos)
+		return 0
+	}
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) varint() int64 {
+	v := r.uvarint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) value(typ byte) interface{} {
+	switch typ {
+	case thriftI32, thriftI64:
+		return r.varint()
+	case thriftBinary:
+		size := int(r.uvarint())
+		if r.pos+size > len(r.data) {
+			r.err = fmt.Errorf("binary of %d bytes at %d runs past the end", size, r.pos)
+			return ""
+		}
+		r.pos += size
+		return string(r.data[r.pos-size : r.pos])
+	case thriftList:
+		header := r.byte()
+		size, elem := int(header>>4), header&0x0f
+		if size == 15 {
+			size = int(r.uvarint())
+		}
+		list := make([]interface{}, 0, size)
+		for i := 0; i < size && r.err == nil; i++ {
+			list = append(list, r.value(elem))
+		}
+		return list
+	case thriftStruct:
+		return r.structure()
+	}
+	r.err = fmt.Errorf("unexpected thrift type %d at %d", typ, r.pos)
+	return nil
+}
+
+func (r *thriftReader) structure() map[int16]interface{} {
+	fields := make(map[int16]interface{})
+	var last int16
+	for r.err == nil {
+		header := r.byte()
+		if header == 0 {
+			break
+		}
+		id := last + int16(header>>4)
+		if header>>4 == 0 {
+			id = int16(r.varint())
+		}
+		fields[id] = r.value(header & 0x0f)
+		last = id
+	}
+	return fields
+}
+
+// readParquet reads back a file of writeParquet: the names of its columns
+// and their values by row, nil where a row has none.
+func readParquet(t *testing.T, file []byte) ([]string, map[string][]interface{}, int64) {
+	t.Helper()
+	if !bytes.HasPrefix(file, []byte(parquetMagic)) || !bytes.HasSuffix(file, []byte(parquetMagic)) {
+		t.Fatal("no parquet magic")
+	}
+	size := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	r := &thriftReader{data: file[:len(file)-8], pos: len(file) - 8 - size}
+	meta := r.structure()
+	if r.err != nil {
+		t.Fatalf("footer: %v", r.err)
+	}
+	if r.pos != len(file)-8 {
+		t.Fatalf("footer ends at %d, its length says %d", r.pos, len(file)-8)
+	}
+	rows := meta[3].(int64)
+
+	optional := make(map[string]bool)
+	names := make([]string, 0)
+	for i, element := range meta[2].([]interface{}) {
+		schema := element.(map[int16]interface{})
+		if i == 0 {
+			continue
+		}
+		name := schema[4].(string)
+		names = append(names, name)
+		optional[name] = schema[3].(int64) == 1
+	}
+
+	groups := meta[4].([]interface{})
+	if len(groups) != 1 {
+		t.Fatalf("%d row groups", len(groups))
+	}
+	values := make(map[string][]interface{})
+	for _, chunk := range groups[0].(map[int16]interface{})[1].([]interface{}) {
+		column := chunk.(map[int16]interface{})[3].(map[int16]interface{})
+		name := column[3].([]interface{})[0].(string)
+		physical := column[1].(int64)
+
+		r := &thriftReader{data: file, pos: int(column[9].(int64))}
+		header := r.structure()
+		if r.err != nil {
+			t.Fatalf("page header of %s: %v", name, r.err)
+		}
+		if header[1].(int64) != 0 {
+			t.Fatalf("page of %s is not a data page", name)
+		}
+		body := file[r.pos : r.pos+int(header[3].(int64))]
+		if got := int64(r.pos+len(body)) - column[9].(int64); got != column[6].(int64) {
+			t.Errorf("chunk of %s is %d bytes, its metadata says %d", name, got, column[6].(int64))
+		}
+		count := header[5].(map[int16]interface{})[1].(int64)
+
+		defined := make([]bool, 0, count)
+		if optional[name] {
+			size := int(binary.LittleEndian.Uint32(body))
+			levels := &thriftReader{data: body[4 : 4+size]}
+			for levels.pos < size && levels.err == nil {
+				run := levels.uvarint()
+				if run&1 != 0 {
+					t.Fatalf("bit packed levels in %s", name)
+				}
+				level := levels.byte()
+				for i := uint64(0); i < run>>1; i++ {
+					defined = append(defined, level == 1)
+				}
+			}
+			body = body[4+size:]
+		} else {
+			for i := int64(0); i < count; i++ {
+				defined = append(defined, true)
+			}
+		}
+		if int64(len(defined)) != count {
+			t.Fatalf("%s has levels for %d rows, not %d", name, len(defined), count)
+		}
+
+		for _, ok := range defined {
+			if !ok {
+				values[name] = append(values[name], nil)
+				continue
+			}
+			switch physical {
+			case parquetByteArray:
+				size := int(binary.LittleEndian.Uint32(body))
+				values[name] = append(values[name], string(body[4:4+size]))
+				body = body[4+size:]
+			case parquetInt64:
+				values[name] = append(values[name], int64(binary.LittleEndian.Uint64(body)))
+				body = body[8:]
+			case parquetDouble:
+				values[name] = append(values[name], math.Float64frombits(binary.LittleEndian.Uint64(body)))
+				body = body[8:]
+			default:
+				t.Fatalf("unexpected physical type %d", physical)
+			}
+		}
+		if len(body) != 0 {
+			t.Errorf("%d bytes left in the page of %s", len(body), name)
+		}
+	}
+	return names, values, rows
+}
+
+func TestWriteParquet(t *testing.T) {
+	taken := time.Date(2021, 7, 4, 12, 30, 0, 0, time.UTC)
+	name, size, when, length := stringColumn("name"), int64Column("size"), timestampColumn("taken"), doubleColumn("duration")
+	columns := []*parquetColumn{name, size, when, length}
+
+	rows := 20
+	want := map[string][]interface{}{}
+	for i := 0; i < rows; i++ {
+		s := fmt.Sprintf("2021/IMG_%04d.JPG", i)
+		if i == 3 {
+			s = "2021/Café ☕.jpg"
+		}
+		name.addString(s)
+		size.addInt64(int64(i) << 33)
+		want["name"] = append(want["name"], s)
+		want["size"] = append(want["size"], int64(i)<<33)
+		if i%7 == 0 {
+			when.addTime(time.Time{})
+			want["taken"] = append(want["taken"], nil)
+		} else {
+			tm := taken.Add(time.Duration(i) * time.Minute)
+			when.addTime(tm)
+			want["taken"] = append(want["taken"], tm.UnixMilli())
+		}
+		if i < 18 {
+			length.addDouble(0)
+			want["duration"] = append(want["duration"], nil)
+		} else {
+			length.addDouble(float64(i) + 0.25)
+			want["duration"] = append(want["duration"], float64(i)+0.25)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := writeParquet(&out, columns, rows); err != nil {
+		t.Fatal(err)
+	}
+	names, values, got := readParquet(t, out.Bytes())
+	if got != int64(rows) {
+		t.Errorf("%d rows, want %d", got, rows)
+	}
+	if !reflect.DeepEqual(names, []string{"name", "size", "taken", "duration"}) {
+		t.Errorf("columns %v", names)
+	}
+	for column, v := range want {
+		if !reflect.DeepEqual(values[column], v) {
+			t.Errorf("%s is %v, want %v", column, values[column], v)
+		}
+	}
+}
+
+func TestExportParquet(t *testing.T) {
+	index := &libraryIndex{entries: map[string]*indexEntry{
+		"2020/a.jpg": {Source: "/src/a.jpg", Size: 843, Taken: time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC),
+			Model: "CamModel", Imported: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), Label: "card"},
+		"/vol2/2020/b.mp4": {Root: "/vol2", Size: 1 << 40, Duration: 12.5, Codec: "hevc"},
+	}}
+	keys := []string{"/vol2/2020/b.mp4", "2020/a.jpg"}
+	// the schema of all index columns is longer than a short thrift list
+	var out bytes.Buffer
+	if err := exportParquet(&out, index, keys); err != nil {
+		t.Fatal(err)
+	}
+	names, values, rows := readParquet(t, out.Bytes())
+	if rows != 2 {
+		t.Fatalf("%d rows", rows)
+	}
+	if !reflect.DeepEqual(names, indexColumns) {
+		t.Errorf("columns %v, want %v", names, indexColumns)
+	}
+	checks := map[string][]interface{}{
+		"path":     {"2020/b.mp4", "2020/a.jpg"},
+		"root":     {"/vol2", ""},
+		"size":     {int64(1 << 40), int64(843)},
+		"taken":    {nil, time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC).UnixMilli()},
+		"model":    {"", "CamModel"},
+		"duration": {12.5, nil},
+		"codec":    {"hevc", ""},
+		"label":    {"", "card"},
+	}
+	for column, want := range checks {
+		if !reflect.DeepEqual(values[column], want) {
+			t.Errorf("%s is %v, want %v", column, values[column], want)
+		}
+	}
+}
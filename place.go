@@ -0,0 +1,119 @@
+package main
+
+import "sync"
+
+// writeSlots limit how many files are written at once to each destination
+// root, so parallel workers do not thrash a single spinning disk.
+var writeSlots = struct {
+	sync.Mutex
+	roots map[string]chan struct{}
+}{roots: make(map[string]chan struct{})}
+
+// acquireWrite waits for a write slot on the root of dest and returns the
+// function giving it back.
+func acquireWrite(dest string) (release func()) {
+	if c.MaxConcurrentWrites <= 0 {
+		return func() {}
+	}
+	root := rootOf(dest)
+	writeSlots.Lock()
+	slots, ok := writeSlots.roots[root]
+	if !ok {
+		slots = make(chan struct{}, c.MaxConcurrentWrites)
+		writeSlots.roots[root] = slots
+	}
+	writeSlots.Unlock()
+	slots <- struct{}{}
+	return func() { <-slots }
+}
+
+// placer places planned files on a number of workers. Files are finished
+// one at a time since uploads and hooks do not expect to run in parallel.
+type placer struct {
+	items     chan planItem
+	wg        sync.WaitGroup
+	finishing sync.Mutex
+	workers   int
+	done      sync.Once
+}
+
+func startPlacer(workers int) *placer {
+	p := &placer{items: make(chan planItem), workers: workers}
+	if workers <= 1 {
+		return p
+	}
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for item := range p.items {
+				p.placeOne(item)
+			}
+		}()
+	}
+	return p
+}
+
+// place hands an item to a worker, or places it right away with a single
+// worker.
+func (p *placer) place(item planItem) {
+	if p.workers <= 1 {
+		p.placeOne(item)
+		return
+	}
+	p.items <- item
+}
+
+// wait returns once every item handed to place is done. Only the first
+// call waits, so it can be deferred for the early returns as well.
+func (p *placer) wait() {
+	p.done.Do(func() {
+		close(p.items)
+		p.wg.Wait()
+	})
+}
+
+func (p *placer) placeOne(item planItem) {
+	if err := placeItem(item); err != nil {
+		recordFailure(item.Source, item.Dest, err)
+		return
+	}
+	p.finishing.Lock()
+	defer p.finishing.Unlock()
+	finishFile(item)
+}
+
+// prefetchMeta decodes the EXIF of files on a number of workers ahead of
+// the planner, which then finds it in the cache, and passes the files on in
+// the order they came.
+func prefetchMeta(files <-chan string, workers int) <-chan string {
+	if workers <= 1 {
+		return files
+	}
+	if exifCache == nil {
+		exifCache = &metaCache{entries: make(map[string]cacheEntry)}
+	}
+	out := make(chan string)
+	pending := make(chan chan string, workers*4)
+	slots := make(chan struct{}, workers)
+	go func() {
+		defer close(pending)
+		for file := range files {
+			decoded := make(chan string, 1)
+			pending <- decoded
+			slots <- struct{}{}
+			go func(file string) {
+				defer func() { <-slots }()
+				decodeExifCached(file)
+				decoded <- file
+			}(file)
+		}
+	}()
+	go func() {
+		defer close(out)
+		for decoded := range pending {
+			out <- <-decoded
+		}
+	}()
+	return out
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"html/template"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// thumbEdge is the long edge of the thumbnails of the plan report.
+const thumbEdge = 160
+
+// reportRow is one planned file of the plan report, with what got in the
+// way of its generated destination.
+type reportRow struct {
+	Source    string
+	Dest      string
+	Generated string
+	Conflict  string
+	Thumb     template.URL
+}
+
+type reportFolder struct {
+	Dir  string
+	Rows []reportRow
+}
+
+var planRows []reportRow
+
+var planPage = template.Must(template.New("plan").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>media_tool plan</title>
+<style>body{font-family:sans-serif}h2{font-size:1em;background:#eee;padding:4px}
+td{padding:4px;vertical-align:top}img{max-width:160px;max-height:160px}
+.conflict{background:#fdd}.conflict b{color:#a00}</style>
+</head><body><h1>{{.Files}} files in {{len .Folders}} folders, {{.Conflicts}} conflicts</h1>
+{{range .Folders}}<h2>{{.Dir}} ({{len .Rows}})</h2><table>
+{{range .Rows}}<tr{{if .Conflict}} class="conflict"{{end}}><td>{{if .Thumb}}<img src="{{.Thumb}}">{{end}}</td>
+<td>{{.Source}}<br>&rarr; {{.Dest}}{{if .Conflict}}<br><b>{{.Conflict}}</b>{{end}}</td></tr>
+{{end}}</table>
+{{end}}</body></html>
+`))
+
+// recordPlanRow adds a planned file to the plan report; generated is where
+// its template put it, before conflicts moved it elsewhere.
+func recordPlanRow(item planItem, generated, conflict string) {
+	planRows = append(planRows, reportRow{Source: item.Source, Dest: item.Dest, Generated: generated, Conflict: conflict})
+}
+
+// writePlanReport writes the plan as a standalone html page with inline
+// thumbnails, grouped by destination folder, to review before the real run.
+// Files that other sources with different content generated the same
+// destination for are highlighted as clashes.
+func writePlanReport(path string, clashes map[string][]string) error {
+	byDir := make(map[string]*reportFolder)
+	conflicts := 0
+	for _, row := range planRows {
+		if row.Conflict == "" && len(clashes[row.Generated]) > 0 {
+			row.Conflict = "other files with different content have the same name"
+		}
+		if row.Conflict != "" {
+			conflicts++
+		}
+		row.Thumb = thumbnail(row.Source)
+		dir := filepath.Dir(row.Dest)
+		if byDir[dir] == nil {
+			byDir[dir] = &reportFolder{Dir: dir}
+		}
+		byDir[dir].Rows = append(byDir[dir].Rows, row)
+	}
+	folders := make([]*reportFolder, 0, len(byDir))
+	for _, folder := range byDir {
+		folders = append(folders, folder)
+	}
+	sort.Slice(folders, func(i, j int) bool { return folders[i].Dir < folders[j].Dir })
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	err = planPage.Execute(f, struct {
+		Files     int
+		Conflicts int
+		Folders   []*reportFolder
+	}{len(planRows), conflicts, folders})
+	if err != nil {
+		return err
+	}
+	log.Infof("write plan report: %s", path)
+	return nil
+}
+
+// thumbnail returns a small jpeg of an image, or of a frame of a video with
+// ffmpeg, as a data url; "" when there is none.
+func thumbnail(file string) template.URL {
+	var data []byte
+	if videoTypes[getFileExtension(file, false)] {
+		if !haveFFmpeg() {
+			return ""
+		}
+		out, err := grabFrame(file, 160)
+		if err != nil {
+			return ""
+		}
+		data = out
+	} else {
+		f, err := os.Open(file)
+		if err != nil {
+			return ""
+		}
+		img, _, err := image.Decode(f)
+		f.Close()
+		if err != nil {
+			return ""
+		}
+		var out bytes.Buffer
+		if err = jpeg.Encode(&out, fitLongEdge(img, thumbEdge), &jpeg.Options{Quality: 70}); err != nil {
+			return ""
+		}
+		data = out.Bytes()
+	}
+	return template.URL("data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(data))
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// planSummaryOver is the size of a plan from which its confirmation shows
+// a summary per destination folder instead of a single question.
+const planSummaryOver = 100
+
+// planFolder is the part of a plan that goes into one destination folder.
+type planFolder struct {
+	Dir   string
+	Items []planItem
+	Bytes int64
+}
+
+func groupByFolder(items []planItem) []planFolder {
+	byDir := make(map[string]*planFolder)
+	for _, item := range items {
+		dir := filepath.Dir(item.Dest)
+		folder, ok := byDir[dir]
+		if !ok {
+			folder = &planFolder{Dir: dir}
+			byDir[dir] = folder
+		}
+		folder.Items = append(folder.Items, item)
+		for _, f := range item.files() {
+			if info, err := os.Stat(f.Source); err == nil {
+				folder.Bytes += info.Size()
+			}
+		}
+	}
+	folders := make([]planFolder, 0, len(byDir))
+	for _, folder := range byDir {
+		folders = append(folders, *folder)
+	}
+	sort.Slice(folders, func(i, j int) bool { return folders[i].Dir < folders[j].Dir })
+	return folders
+}
+
+// confirmPlan asks before a batch is placed and returns what was approved.
+// Large plans are summarized per destination folder, and can be approved
+// as a whole or folder by folder.
+func confirmPlan(items []planItem) []planItem {
+	if len(items) <= planSummaryOver {
+		if askForConfirmation(fmt.Sprintf("Are you sure you want to %s all %d files?\n", c.Mode, len(items))) {
+			return items
+		}
+		return nil
+	}
+
+	folders := groupByFolder(items)
+	for _, folder := range folders {
+		fmt.Printf("%7d files %10s  %s\n", len(folder.Items), formatBytes(uint64(folder.Bytes)), folder.Dir)
+	}
+	prompt := fmt.Sprintf("%s (a)ll %d files in %d folders, (n)one or choose (f)older by folder?", c.Mode, len(items), len(folders))
+	switch askChoice(prompt, "a", "n", "f") {
+	case "a":
+		return items
+	case "n":
+		return nil
+	}
+
+	approved := make([]planItem, 0, len(items))
+	for i, folder := range folders {
+		prompt = fmt.Sprintf("%s %d files into %s? (y)es, (n)o, (a)ll remaining or (s)kip remaining", c.Mode, len(folder.Items), folder.Dir)
+		switch askChoice(prompt, "y", "n", "a", "s") {
+		case "y":
+			approved = append(approved, folder.Items...)
+		case "a":
+			for _, rest := range folders[i:] {
+				approved = append(approved, rest.Items...)
+			}
+			return approved
+		case "s":
+			return approved
+		}
+	}
+	return approved
+}
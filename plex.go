@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// plexTemplate names home videos "Home Videos/2019/2019-07-04 Paris - 1.mp4",
+// which Plex and Jellyfin parse into a date and a title.
+const (
+	plexTemplate        = "Home Videos/{year}/{year}-{month}-{day} {event} - {index}.{ext}"
+	plexTemplateNoEvent = "Home Videos/{year}/{year}-{month}-{day} - {index}.{ext}"
+)
+
+// plexIndexPattern captures the index of a name of the plex layout.
+var plexIndexPattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2} .*- (\d+)\.[^.]+$`)
+
+// plexNfo is the movie NFO both Plex agents and Jellyfin read the date of
+// a home video from.
+type plexNfo struct {
+	XMLName   xml.Name `xml:"movie"`
+	Title     string   `xml:"title"`
+	Premiered string   `xml:"premiered"`
+	Aired     string   `xml:"aired"`
+	Year      string   `xml:"year"`
+}
+
+// plexVideoTemplate returns the template of the plex layout for videos, ""
+// for other files.
+func plexVideoTemplate(file string, meta *mediaMeta) string {
+	if c.Layout != layoutPlex || !videoTypes[getFileExtension(mediaExt(file), false)] {
+		return ""
+	}
+	if meta.Event == "" {
+		return plexTemplateNoEvent
+	}
+	return plexTemplate
+}
+
+// writePlexNfo writes Movie.nfo next to a home video with its capture date.
+func writePlexNfo(video string, tm time.Time) error {
+	stem := strings.TrimSuffix(video, filepath.Ext(video))
+	nfo := plexNfo{
+		Title:     filepath.Base(stem),
+		Premiered: tm.Format(time.DateOnly),
+		Aired:     tm.Format(time.DateOnly),
+		Year:      tm.Format("2006"),
+	}
+	data, err := xml.MarshalIndent(nfo, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append([]byte(xml.Header), append(data, '\n')...)
+	return os.WriteFile(stem+".nfo", data, 0644)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// poster is a frame grabbed from a planned video so the plan can be reviewed
+// before cryptically named clips are moved.
+type poster struct {
+	Source string
+	Dest   string
+	Image  string
+}
+
+var (
+	posters []poster
+	// ffmpegFound is looked up with the first video
+	ffmpegFound *bool
+)
+
+var reviewPage = template.Must(template.New("review").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>media_tool review</title>
+<style>body{font-family:sans-serif}td{padding:4px;vertical-align:top}img{max-width:320px}</style>
+</head><body><table>
+{{range .}}<tr><td>{{if .Image}}<img src="{{.Image}}">{{end}}</td><td>{{.Source}}<br>&rarr; {{.Dest}}</td></tr>
+{{end}}</table></body></html>
+`))
+
+// addPoster grabs a frame of a planned video into the posters folder with
+// ffmpeg; without ffmpeg the video is listed without an image.
+func addPoster(item planItem) {
+	if !videoTypes[getFileExtension(item.Source, false)] {
+		return
+	}
+	p := poster{Source: item.Source, Dest: item.Dest}
+	if err := createParentDir(c.Posters); err != nil {
+		log.Errorf("error creating %s: %v", c.Posters, err)
+		return
+	}
+
+	if !haveFFmpeg() {
+		posters = append(posters, p)
+		return
+	}
+
+	image := fmt.Sprintf("%05d.jpg", len(posters)+1)
+	if data, err := grabFrame(item.Source, 320); err != nil {
+		log.Debugf("no poster for %s: %v", item.Source, err)
+	} else if err = os.WriteFile(filepath.Join(c.Posters, image), data, 0644); err != nil {
+		log.Errorf("error writing poster of %s: %v", item.Source, err)
+	} else {
+		p.Image = image
+	}
+	posters = append(posters, p)
+}
+
+// grabFrame returns a frame of a video scaled to width as a jpeg. One
+// second in skips the black first frame, short clips use the start.
+func grabFrame(file string, width int) ([]byte, error) {
+	var err error
+	for _, at := range []string{"1", "0"} {
+		cmd := exec.Command("ffmpeg", "-loglevel", "error", "-ss", at, "-i", file, "-frames:v", "1",
+			"-vf", fmt.Sprintf("scale=%d:-2", width), "-f", "image2pipe", "-vcodec", "mjpeg", "-")
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, runErr := cmd.Output()
+		if runErr == nil && len(out) > 0 {
+			return out, nil
+		}
+		err = fmt.Errorf("at %ss: %v: %s", at, runErr, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return nil, err
+}
+
+func writeReviewPage(dir string) error {
+	if len(posters) == 0 {
+		return nil
+	}
+	path := filepath.Join(dir, "review.html")
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err = reviewPage.Execute(f, posters); err != nil {
+		return err
+	}
+	log.Infof("write video review page: %s", path)
+	return nil
+}
+
+// haveFFmpeg looks for ffmpeg once, warning that videos go without a frame
+// when it is missing.
+func haveFFmpeg() bool {
+	if ffmpegFound == nil {
+		_, err := exec.LookPath("ffmpeg")
+		found := err == nil
+		if !found {
+			log.Warnf("ffmpeg not found, videos are listed without a poster")
+		}
+		ffmpegFound = &found
+	}
+	return *ffmpegFound
+}
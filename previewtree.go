@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// previewDests are the destinations of every planned file for
+// --preview-tree, companions included.
+var previewDests []string
+
+func recordPreview(item planItem) {
+	for _, f := range item.files() {
+		previewDests = append(previewDests, f.Dest)
+	}
+}
+
+// treeNode is a destination folder and the number of files below it.
+type treeNode struct {
+	name     string
+	children map[string]*treeNode
+	files    int // directly in the folder
+	total    int // in the folder and its subfolders
+}
+
+func (n *treeNode) child(name string) *treeNode {
+	if n.children == nil {
+		n.children = make(map[string]*treeNode)
+	}
+	if n.children[name] == nil {
+		n.children[name] = &treeNode{name: name}
+	}
+	return n.children[name]
+}
+
+// buildTree makes the folder tree of files, its root the deepest folder
+// they all share.
+func buildTree(files []string) *treeNode {
+	root := &treeNode{}
+	for _, file := range files {
+		node := root
+		node.total++
+		for _, part := range strings.Split(filepath.Dir(filepath.Clean(file)), string(filepath.Separator)) {
+			if part == "" {
+				part = string(filepath.Separator)
+			}
+			node = node.child(part)
+			node.total++
+		}
+		node.files++
+	}
+	// a chain of folders holding one folder each is one line
+	for root.files == 0 && len(root.children) == 1 {
+		for _, only := range root.children {
+			only.name = filepath.Join(root.name, only.name)
+			root = only
+		}
+	}
+	return root
+}
+
+// printPreviewTree shows where the plan puts files, a line with the count
+// of files per folder.
+func printPreviewTree(files []string) {
+	if len(files) == 0 {
+		return
+	}
+	root := buildTree(files)
+	fmt.Printf("%s  %s\n", root.name, treeCount(root))
+	printTreeChildren(root, "")
+}
+
+func printTreeChildren(node *treeNode, indent string) {
+	names := make([]string, 0, len(node.children))
+	for name := range node.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		child := node.children[name]
+		branch, next := "├── ", "│   "
+		if i == len(names)-1 {
+			branch, next = "└── ", "    "
+		}
+		fmt.Printf("%s%s%s  %s\n", indent, branch, name, treeCount(child))
+		printTreeChildren(child, indent+next)
+	}
+}
+
+func treeCount(node *treeNode) string {
+	count := fmt.Sprintf("%d files", node.total)
+	if node.total == 1 {
+		count = "1 file"
+	}
+	if node.files > 0 && node.files < node.total {
+		count += fmt.Sprintf(", %d here", node.files)
+	}
+	return count
+}
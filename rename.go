@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const renameLayout = "20060102_150405"
+
+// renamedPattern also matches the milliseconds of renamed burst shots,
+// YYYYMMDD_HHMMSS_mmm_nnn, before the counter.
+var renamedPattern = regexp.MustCompile(`^\d{8}_\d{6}(?:_\d{3})?_(\d+)`)
+
+// folderCounters holds the next free counter of every destination folder a
+// renamed file has been planned into during this run, per naming pattern.
+var folderCounters = struct {
+	sync.Mutex
+	next map[string]int
+}{next: make(map[string]int)}
+
+// resetCounters forgets the counters of an earlier run, such as a dry run
+// or another volume of the import wizard; what it placed is on disk.
+func resetCounters() {
+	folderCounters.Lock()
+	defer folderCounters.Unlock()
+	folderCounters.next = make(map[string]int)
+}
+
+// nextCounter returns the next free counter of a destination folder, a path
+// under its root, for names whose counter pattern captures. It is noted in
+// meta and only taken by takeCounters once the file is planned, so a file
+// skipped after naming leaves it to the next one.
+func nextCounter(dir string, pattern *regexp.Regexp, meta *mediaMeta) int {
+	folderCounters.Lock()
+	defer folderCounters.Unlock()
+
+	key := pattern.String() + "\x00" + dir
+	counter, ok := folderCounters.next[key]
+	if !ok {
+		counter = highestCounter(dir, pattern) + 1
+		folderCounters.next[key] = counter
+	}
+	if meta.Counters == nil {
+		meta.Counters = make(map[string]int)
+	}
+	meta.Counters[key] = counter
+	return counter
+}
+
+// takeCounters uses up the counters the name of a planned file was given.
+func takeCounters(meta *mediaMeta) {
+	if meta == nil {
+		return
+	}
+	folderCounters.Lock()
+	defer folderCounters.Unlock()
+	for key, counter := range meta.Counters {
+		if folderCounters.next[key] <= counter {
+			folderCounters.next[key] = counter + 1
+		}
+	}
+}
+
+// renamedBase names a file YYYYMMDD_HHMMSS_nnn.ext, continuing the counter
+// from the highest one already present in the destination folder so that
+// incremental imports never restart numbering. When the capture time has
+// sub-seconds its milliseconds come before the counter, keeping the shots
+// of a burst in the order they were taken.
+func renamedBase(dir string, meta *mediaMeta, ext string) string {
+	tm := meta.Time
+	counter := nextCounter(dir, renamedPattern, meta)
+	if ms := tm.Nanosecond() / int(time.Millisecond); tm.Nanosecond() != 0 {
+		return fmt.Sprintf("%s_%03d_%03d%s", tm.Format(renameLayout), ms, counter, ext)
+	}
+	return fmt.Sprintf("%s_%03d%s", tm.Format(renameLayout), counter, ext)
+}
+
+func highestCounter(dir string, pattern *regexp.Regexp) int {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+	highest := 0
+	for _, entry := range entries {
+		matches := pattern.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			continue
+		}
+		counter, err := strconv.Atoi(matches[1])
+		if err == nil && counter > highest {
+			highest = counter
+		}
+	}
+	return highest
+}
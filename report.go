@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// findNameClashes returns the generated destinations that more than one
+// source with different content mapped to in this run.
+func findNameClashes(generated map[string][]string, placed map[string]string) map[string][]string {
+	clashes := make(map[string][]string)
+	for dest, sources := range generated {
+		if len(sources) < 2 {
+			continue
+		}
+		hashes := make(map[string]bool)
+		for _, source := range sources {
+			// a moved file is hashed where it went
+			file := source
+			if !fileExists(file) && placed[source] != "" {
+				file = placed[source]
+			}
+			hash, err := fullHash(file)
+			if err != nil {
+				log.Errorf("error hashing %s: %v", source, err)
+				continue
+			}
+			hashes[hash] = true
+		}
+		if len(hashes) > 1 {
+			clashes[dest] = sources
+		}
+	}
+	return clashes
+}
+
+// reportNameClashes logs every clash and, when path is set, writes them to a
+// plain text report as well.
+func reportNameClashes(clashes map[string][]string, path string) error {
+	if len(clashes) == 0 {
+		return nil
+	}
+
+	dests := make([]string, 0, len(clashes))
+	for dest := range clashes {
+		dests = append(dests, dest)
+	}
+	sort.Strings(dests)
+
+	var b strings.Builder
+	for _, dest := range dests {
+		log.Warnf("%d different files would be named %s", len(clashes[dest]), dest)
+		fmt.Fprintf(&b, "%s\n", dest)
+		for _, source := range clashes[dest] {
+			log.Warnf("  %s", source)
+			fmt.Fprintf(&b, "\t%s\n", source)
+		}
+	}
+
+	if path == "" {
+		return nil
+	}
+	log.Infof("write name clash report: %s", path)
+	return os.WriteFile(path, []byte(b.String()), 0644)
+}
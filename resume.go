@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// partSuffix marks a file that is still being copied.
+const partSuffix = ".part"
+
+// chunkSize is how much of a resumable copy is written between two syncs,
+// which is also the most a dropped connection makes it copy again.
+const chunkSize = 8 << 20
+
+// resumableCopy copies src to dst through dst.part in chunks, journaling
+// each one once synced. When a previous copy of it broke off, it carries on
+// after the last chunk that reached the disk instead of from the start. It
+// is meant for slow network mounts, where multi-gigabyte videos would
+// otherwise start over on every dropped connection.
+func resumableCopy(j *journal, chunks map[string]copiedChunk, src, dst string) error {
+	part := dst + partSuffix
+	source, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("error opening source file: %w", err)
+	}
+	defer source.Close()
+	info, err := source.Stat()
+	if err != nil {
+		return err
+	}
+
+	// a partial file left by another source, or an older version of this
+	// one, starts over
+	var offset int64
+	if chunk, ok := chunks[absPath(part)]; ok && chunk.resumes(src, info) {
+		offset = chunk.Offset
+	}
+	if partInfo, err := os.Stat(part); err != nil || partInfo.Size() < offset || offset > info.Size() {
+		offset = 0
+	}
+	destination, err := os.OpenFile(part, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error creating destination file: %w", err)
+	}
+	defer destination.Close()
+	// whatever follows the last synced chunk may be torn
+	if err = destination.Truncate(offset); err != nil {
+		return err
+	}
+	if offset > 0 {
+		if _, err = source.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err = destination.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	for offset < info.Size() {
+		n, err := io.CopyN(destination, source, chunkSize)
+		if err == io.EOF {
+			// the source shrank, which the size check below reports
+			err = nil
+		}
+		if err == nil {
+			err = injectFault("write", dst)
+		}
+		if err != nil {
+			return fmt.Errorf("error copying file after %d bytes: %w", offset, err)
+		}
+		if err = destination.Sync(); err != nil {
+			return fmt.Errorf("error syncing destination file: %w", err)
+		}
+		offset += n
+		j.recordChunk(src, part, info, offset)
+		if n == 0 {
+			break
+		}
+	}
+	if offset != info.Size() {
+		return fmt.Errorf("copied %d of %d bytes of %s", offset, info.Size(), src)
+	}
+	if err = destination.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(part, dst); err != nil {
+		return err
+	}
+	j.record(opCopied, src, dst, nil)
+	return nil
+}
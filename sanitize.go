@@ -0,0 +1,95 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// sanitizeConfig controls how the values of template variables are made
+// safe as path components, so a model such as "HERO9 Black/1" does not
+// nest a folder. A slash and control characters are always replaced.
+type sanitizeConfig struct {
+	// Replacement stands in for the characters replaced, "_" by default.
+	Replacement string `yaml:"replacement"`
+	// WindowsSafe also replaces what Windows and SMB shares reject, such as
+	// the colon of "12:30", and drops trailing dots.
+	WindowsSafe bool `yaml:"windows_safe"`
+	// MaxLength caps each component in bytes, 255 by default as most file
+	// systems do; a file name keeps its extension.
+	MaxLength  int  `yaml:"max_length"`
+	StripEmoji bool `yaml:"strip_emoji"`
+}
+
+// windowsUnsafe are the characters Windows rejects in a name besides "/".
+const windowsUnsafe = `\:*?"<>|`
+
+const defaultMaxLength = 255
+
+// sanitizeValue makes the value of a template variable one safe path
+// component.
+func sanitizeValue(value string) string {
+	replacement := y.Sanitize.Replacement
+	if replacement == "" {
+		replacement = "_"
+	}
+	var b strings.Builder
+	for _, r := range value {
+		switch {
+		case r == '/' || unicode.IsControl(r) || y.Sanitize.WindowsSafe && strings.ContainsRune(windowsUnsafe, r):
+			b.WriteString(replacement)
+		case y.Sanitize.StripEmoji && isEmoji(r):
+		default:
+			b.WriteRune(r)
+		}
+	}
+	s := b.String()
+	if y.Sanitize.StripEmoji {
+		// what surrounded an emoji may now be doubled spaces
+		s = strings.Join(strings.Fields(s), " ")
+	}
+	if y.Sanitize.WindowsSafe {
+		s = strings.TrimRight(s, ". ")
+	}
+	if s == "." || s == ".." {
+		return replacement
+	}
+	return s
+}
+
+// isEmoji reports whether r is an emoji or one of the joiners, selectors and
+// modifiers that build them.
+func isEmoji(r rune) bool {
+	switch {
+	case r >= 0x1F000 && r <= 0x1FAFF, // pictographs, emoticons, flags, modifiers
+		r >= 0x2600 && r <= 0x27BF, // symbols and dingbats
+		r >= 0x2B00 && r <= 0x2BFF, // arrows, stars
+		r == 0x200D, r == 0x20E3,   // joiner, keycap
+		r >= 0xFE00 && r <= 0xFE0F,   // variation selectors
+		r >= 0xE0020 && r <= 0xE007F: // tags of subdivision flags
+		return true
+	}
+	return false
+}
+
+// truncateComponent cuts a path component to the max length, keeping its
+// extension and whole characters.
+func truncateComponent(part string) string {
+	max := y.Sanitize.MaxLength
+	if max <= 0 {
+		max = defaultMaxLength
+	}
+	if len(part) <= max {
+		return part
+	}
+	ext := filepath.Ext(part)
+	if len(ext) > 16 || len(ext) >= max {
+		ext = ""
+	}
+	stem := part[:max-len(ext)]
+	for len(stem) > 0 && !utf8.ValidString(stem) {
+		stem = stem[:len(stem)-1]
+	}
+	return strings.TrimSpace(stem) + ext
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+const classScan = "scan"
+
+var (
+	// scanNamePattern matches the names given by scanner apps and drivers.
+	scanNamePattern = regexp.MustCompile(`^(?i:scan|scanned|camscanner|adobe scan|genius scan|office lens|img\d*_scan)`)
+	// scannerPattern matches the EXIF make, model or software of flatbed
+	// and sheet-fed scanners and of phone scanner apps.
+	scannerPattern = regexp.MustCompile(`(?i)scan|perfection|fi-\d{4}|\bmfc-|\bdcp-|camscanner|office lens|microsoft lens|scanner pro|vflat`)
+)
+
+// paperAspects are the long to short side ratios of A-series and letter
+// paper; cameras shoot 4:3, 3:2 or 16:9.
+var paperAspects = []float64{1.414, 1.294}
+
+const (
+	// paperTolerance is how far from a paper aspect an image may be.
+	paperTolerance = 0.015
+	// scanMinEdge is the smallest long edge, in pixels, of a page scanned
+	// at a readable resolution.
+	scanMinEdge = 2000
+)
+
+// isScan tells flatbed and phone document scans from camera photos: by the
+// name a scanner app gave it, by scanner EXIF tags, or, for images without
+// a camera model, by a high resolution page shape.
+func isScan(file string, meta *mediaMeta) bool {
+	if !picTypes[getFileExtension(file, false)] {
+		return false
+	}
+	if scanNamePattern.MatchString(filepath.Base(file)) {
+		return true
+	}
+	if meta.Model != "" && scannerPattern.MatchString(meta.Model) {
+		return true
+	}
+	if scannerTags(file) {
+		return true
+	}
+	return meta.Model == "" && pageShaped(file)
+}
+
+// scannerTags reports whether the EXIF make, model or software of file
+// names a scanner.
+func scannerTags(file string) bool {
+	f, err := os.Open(file)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	x, err := exif.Decode(f)
+	if x == nil {
+		return false
+	}
+	for _, name := range []exif.FieldName{exif.Make, exif.Model, exif.Software} {
+		if tag, err := x.Get(name); err == nil && scannerPattern.MatchString(getTagString(tag)) {
+			return true
+		}
+	}
+	return false
+}
+
+func pageShaped(file string) bool {
+	f, err := os.Open(file)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	config, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return false
+	}
+	long, short := float64(config.Width), float64(config.Height)
+	if short > long {
+		long, short = short, long
+	}
+	if long < scanMinEdge || short == 0 {
+		return false
+	}
+	for _, aspect := range paperAspects {
+		if d := long/short - aspect; d < paperTolerance && d > -paperTolerance {
+			return true
+		}
+	}
+	return false
+}
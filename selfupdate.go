@@ -0,0 +1,313 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// releasesURL is where the latest release is looked up.
+const releasesURL = "https://api.github.com/repos/phpgao/media_tool/releases/latest"
+
+// checksumsAsset lists the sha256 of every other asset of a release, in the
+// format of sha256sum; its signature is checksumsAsset + ".sig".
+const checksumsAsset = "checksums.txt"
+
+// releaseKey is the base64 ed25519 key releases are signed with, set at
+// build time with -ldflags "-X main.releaseKey=...". --public-key overrides
+// it; without either no update is installed.
+var releaseKey = ""
+
+// updateClient gives up on a release server that stops answering instead of
+// hanging self-update.
+var updateClient = &http.Client{Timeout: 10 * time.Minute}
+
+var selfUpdateCommand = &cli.Command{
+	Name:  "self-update",
+	Usage: "replace this binary with the latest release after checking its signed checksum",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:        "check",
+			Destination: &c.UpdateCheck,
+			Usage:       "only tell whether a newer release exists",
+		},
+		&cli.BoolFlag{
+			Name:        "force",
+			Destination: &c.UpdateForce,
+			Usage:       "install the latest release even if it is not newer",
+		},
+		&cli.StringFlag{
+			Name:        "public-key",
+			Destination: &c.PublicKey,
+			Usage:       "base64 ed25519 key the checksums of the release must be signed with, instead of the one built in",
+		},
+	},
+	Action: selfUpdate,
+}
+
+type githubRelease struct {
+	TagName string `json:"tag_name"`
+	Assets  []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+func (r githubRelease) asset(name string) string {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a.URL
+		}
+	}
+	return ""
+}
+
+// binaryAsset picks the asset built for this system, e.g.
+// media_tool_linux_arm64.tar.gz.
+func (r githubRelease) binaryAsset() (name, url string) {
+	arch := "_" + runtime.GOARCH
+	for _, a := range r.Assets {
+		lower := strings.ToLower(a.Name)
+		if strings.Contains(lower, runtime.GOOS) && (strings.Contains(lower, arch+".") || strings.HasSuffix(lower, arch)) &&
+			!strings.HasSuffix(lower, ".sig") && lower != checksumsAsset {
+			return a.Name, a.URL
+		}
+	}
+	return "", ""
+}
+
+func selfUpdate(_ *cli.Context) error {
+	var release githubRelease
+	data, err := download(releasesURL)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(data, &release); err != nil {
+		return fmt.Errorf("error reading the release: %w", err)
+	}
+	if !newerVersion(release.TagName, version) && !c.UpdateForce {
+		log.Infof("%s is the latest release", version)
+		return nil
+	}
+	if c.UpdateCheck {
+		log.Infof("%s is out, this is %s", release.TagName, version)
+		return nil
+	}
+
+	name, url := release.binaryAsset()
+	if url == "" {
+		return fmt.Errorf("release %s has no build for %s/%s", release.TagName, runtime.GOOS, runtime.GOARCH)
+	}
+	checksumsURL := release.asset(checksumsAsset)
+	if checksumsURL == "" {
+		return fmt.Errorf("release %s has no %s, not installing it unchecked", release.TagName, checksumsAsset)
+	}
+	checksums, err := download(checksumsURL)
+	if err != nil {
+		return err
+	}
+	if err = verifySignature(checksums, release.asset(checksumsAsset+".sig")); err != nil {
+		return err
+	}
+	want, err := checksumOf(checksums, name)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("download %s", name)
+	archive, err := download(url)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(archive)
+	if got := hex.EncodeToString(sum[:]); got != want {
+		return fmt.Errorf("checksum of %s is %s, the release says %s", name, got, want)
+	}
+	binary, err := extractBinary(name, archive)
+	if err != nil {
+		return err
+	}
+	if err = replaceExecutable(binary); err != nil {
+		return err
+	}
+	log.Infof("updated %s to %s", version, release.TagName)
+	return nil
+}
+
+func download(url string) ([]byte, error) {
+	resp, err := updateClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("get %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// newerVersion compares dotted versions such as v1.10.2 number by number.
+func newerVersion(latest, current string) bool {
+	l := strings.Split(strings.TrimPrefix(latest, "v"), ".")
+	cur := strings.Split(strings.TrimPrefix(current, "v"), ".")
+	for i := 0; i < len(l) || i < len(cur); i++ {
+		var a, b int
+		if i < len(l) {
+			a, _ = strconv.Atoi(l[i])
+		}
+		if i < len(cur) {
+			b, _ = strconv.Atoi(cur[i])
+		}
+		if a != b {
+			return a > b
+		}
+	}
+	return false
+}
+
+// verifySignature checks checksums against its signature with --public-key
+// or, by default, the key built in.
+func verifySignature(checksums []byte, sigURL string) error {
+	encodedKey, source := releaseKey, "the built-in release key"
+	if c.PublicKey != "" {
+		encodedKey, source = c.PublicKey, "--public-key"
+	}
+	if encodedKey == "" {
+		return fmt.Errorf("this build has no release key to check %s with, pass --public-key", checksumsAsset)
+	}
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("%s is not a base64 ed25519 key", source)
+	}
+	if sigURL == "" {
+		return fmt.Errorf("the release has no %s.sig", checksumsAsset)
+	}
+	encoded, err := download(sigURL)
+	if err != nil {
+		return err
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil {
+		return fmt.Errorf("error reading %s.sig: %w", checksumsAsset, err)
+	}
+	if !ed25519.Verify(key, checksums, sig) {
+		return fmt.Errorf("the signature of %s does not match %s", checksumsAsset, source)
+	}
+	return nil
+}
+
+// checksumOf finds the sha256 of name in sha256sum output.
+func checksumOf(checksums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("%s is not in %s", name, checksumsAsset)
+}
+
+// extractBinary returns the executable from a release asset, which is the
+// binary itself or a .tar.gz or .zip holding it.
+func extractBinary(name string, data []byte) ([]byte, error) {
+	isBinary := func(entry string) bool {
+		return strings.HasPrefix(filepath.Base(entry), "media_tool")
+	}
+	switch {
+	case strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz"):
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		tr := tar.NewReader(gz)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if header.Typeflag == tar.TypeReg && isBinary(header.Name) {
+				return io.ReadAll(tr)
+			}
+		}
+	case strings.HasSuffix(name, ".zip"):
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() || !isBinary(f.Name) {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			defer rc.Close()
+			return io.ReadAll(rc)
+		}
+	default:
+		return data, nil
+	}
+	return nil, fmt.Errorf("no media_tool binary in %s", name)
+}
+
+// replaceExecutable writes the new binary next to the running one and
+// renames it over it, so a failure leaves the old one working. Windows
+// cannot replace a running executable, it is moved aside first.
+func replaceExecutable(binary []byte) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	info, err := os.Stat(exe)
+	if err != nil {
+		return err
+	}
+
+	tmp := exe + ".new"
+	if err = os.WriteFile(tmp, binary, info.Mode().Perm()|0111); err != nil {
+		return fmt.Errorf("error writing %s: %w", tmp, err)
+	}
+	if runtime.GOOS == "windows" {
+		old := exe + ".old"
+		_ = os.Remove(old)
+		if err = os.Rename(exe, old); err != nil {
+			_ = os.Remove(tmp)
+			return err
+		}
+	}
+	if err = os.Rename(tmp, exe); err != nil {
+		_ = os.Remove(tmp)
+		if runtime.GOOS == "windows" {
+			_ = os.Rename(exe+".old", exe)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+)
+
+// EXIF 2.3 serial number tags, which goexif does not know
+const (
+	bodySerialNumber exif.FieldName = "BodySerialNumber"
+	lensSerialNumber exif.FieldName = "LensSerialNumber"
+)
+
+var serialFields = map[uint16]exif.FieldName{
+	0xa431: bodySerialNumber,
+	0xa435: lensSerialNumber,
+}
+
+// serialParser loads the serial number tags of the EXIF sub-IFD, so two
+// bodies of the same model can be told apart.
+type serialParser struct{}
+
+func (serialParser) Parse(x *exif.Exif) error {
+	ptr, err := x.Get(exif.ExifIFDPointer)
+	if err != nil {
+		return nil
+	}
+	offset, err := ptr.Int64(0)
+	if err != nil {
+		return nil
+	}
+	r := bytes.NewReader(x.Raw)
+	if _, err = r.Seek(offset, 0); err != nil {
+		return nil
+	}
+	dir, _, err := tiff.DecodeDir(r, x.Tiff.Order)
+	if err != nil {
+		return nil
+	}
+	x.LoadTags(dir, serialFields, false)
+	return nil
+}
+
+func init() {
+	exif.RegisterParsers(serialParser{})
+}
+
+// readSerials returns the body and lens serial numbers of a decoded EXIF.
+func readSerials(x *exif.Exif) (body, lens string) {
+	if tag, err := x.Get(bodySerialNumber); err == nil {
+		body = getTagString(tag)
+	}
+	if tag, err := x.Get(lensSerialNumber); err == nil {
+		lens = getTagString(tag)
+	}
+	return body, lens
+}
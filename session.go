@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"strings"
+	"time"
+)
+
+// session identifies this run in the index, so everything that came off
+// one card in one import can be found, audited or undone later.
+var session = time.Now().Format("20060102-150405")
+
+const sessionNamespace = "https://github.com/phpgao/media_tool/ns/1.0/"
+
+// sessionSidecar is written next to a file that has no sidecar yet.
+const sessionSidecar = `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""%s/>
+ </rdf:RDF>
+</x:xmpmeta>
+`
+
+// tagSessionSidecar records the session in the XMP sidecar of a placed
+// file, creating IMG_1.JPG.xmp when it has none. A sidecar already tagged
+// by an earlier session keeps its tag.
+func tagSessionSidecar(file string) error {
+	attrs := fmt.Sprintf(` xmlns:mediatool="%s" mediatool:Session="%s"`, sessionNamespace, session)
+	if c.SessionSource != "" {
+		attrs += fmt.Sprintf(` mediatool:SessionSource="%s"`, html.EscapeString(c.SessionSource))
+	}
+
+	sidecar := findSidecar(file)
+	if sidecar == "" {
+		sidecar = file + ".xmp"
+		if err := os.WriteFile(sidecar, []byte(fmt.Sprintf(sessionSidecar, attrs)), 0644); err != nil {
+			return err
+		}
+		// the sidecar is part of the library now, derived from its file
+		if catalog != nil {
+			catalog.add(file, sidecar, nil)
+		}
+		return nil
+	}
+	data, err := os.ReadFile(sidecar)
+	if err != nil {
+		return err
+	}
+	xmp := string(data)
+	if strings.Contains(xmp, "mediatool:Session=") {
+		return nil
+	}
+	if !strings.Contains(xmp, "<rdf:Description") {
+		return fmt.Errorf("%s has no rdf:Description to tag", sidecar)
+	}
+	xmp = strings.Replace(xmp, "<rdf:Description", "<rdf:Description"+attrs, 1)
+	return os.WriteFile(sidecar, []byte(xmp), 0644)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// fileStamp is the size and modification time a file had when scanned.
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+var scanStamps = struct {
+	sync.Mutex
+	m map[string]fileStamp
+}{m: make(map[string]fileStamp)}
+
+// settled reports whether a file was left alone for the --settle period,
+// so files a sync client is still writing are left for the next run.
+func settled(file string) bool {
+	if c.Settle <= 0 {
+		return true
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return false
+	}
+	if age := time.Since(info.ModTime()); age < c.Settle {
+		action(labelSkip, "%s: modified %s ago", file, age.Round(time.Second))
+		return false
+	}
+	scanStamps.Lock()
+	scanStamps.m[file] = fileStamp{size: info.Size(), modTime: info.ModTime()}
+	scanStamps.Unlock()
+	return true
+}
+
+// unchanged fails for a file whose size or modification time moved since it
+// was scanned.
+func unchanged(file string) error {
+	scanStamps.Lock()
+	stamp, ok := scanStamps.m[file]
+	scanStamps.Unlock()
+	if !ok {
+		return nil
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	if info.Size() != stamp.size || !info.ModTime().Equal(stamp.modTime) {
+		return fmt.Errorf("%s changed since it was scanned, it is probably still being written", file)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// varShapes is what a template variable looks like in a rendered path;
+// the others may be anything within a path component, or nothing.
+var varShapes = map[string]string{
+	"year":  `\d{4}`,
+	"month": `\d{2}`,
+	"day":   `\d{2}`,
+	"date":  `\d{4}-\d{2}(?:-\d{2})?`,
+}
+
+var templateVar = regexp.MustCompile(`\{[a-z_]+\}`)
+
+// organizedShapes match the paths the configured templates render, set
+// with --skip-organized.
+var organizedShapes []*regexp.Regexp
+
+// templateShapes returns the shape of every template a file can be placed
+// by.
+func templateShapes() []*regexp.Regexp {
+	templates := []string{defaultTemplate, defaultDocumentTemplate}
+	if y.Template != "" {
+		templates[0] = y.Template
+	}
+	for _, rule := range y.Apps {
+		templates = append(templates, rule.Template)
+	}
+	for _, rule := range y.Classes {
+		templates = append(templates, rule.Template)
+	}
+	for _, rule := range y.Cameras {
+		templates = append(templates, rule.Template)
+	}
+	shapes := make([]*regexp.Regexp, 0, len(templates))
+	for _, tmpl := range templates {
+		if tmpl != "" {
+			shapes = append(shapes, templateShape(tmpl))
+		}
+	}
+	return shapes
+}
+
+// templateShape turns a template into a pattern matching the paths it can
+// render, whatever the metadata.
+func templateShape(tmpl string) *regexp.Regexp {
+	parts := strings.Split(tmpl, "/")
+	var b strings.Builder
+	b.WriteString("^")
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		shape := componentShape(part)
+		if i < len(parts)-1 {
+			shape += "/"
+		}
+		// components of variables only disappear when these are empty
+		if strings.TrimSpace(templateVar.ReplaceAllStringFunc(part, func(v string) string {
+			if _, ok := varShapes[v[1:len(v)-1]]; ok {
+				return v
+			}
+			return ""
+		})) == "" {
+			shape = "(?:" + shape + ")?"
+		}
+		b.WriteString(shape)
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+func componentShape(part string) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range templateVar.FindAllStringIndex(part, -1) {
+		b.WriteString(literalShape(part[last:loc[0]]))
+		shape, ok := varShapes[part[loc[0]+1:loc[1]-1]]
+		if !ok {
+			shape = `[^/]*`
+		}
+		b.WriteString(shape)
+		last = loc[1]
+	}
+	b.WriteString(literalShape(part[last:]))
+	return b.String()
+}
+
+// literalShape quotes text between variables; its spaces are optional as
+// rendering trims them next to an empty variable.
+func literalShape(s string) string {
+	return strings.ReplaceAll(regexp.QuoteMeta(s), " ", " ?")
+}
+
+// organized reports whether a source file already sits where a template
+// would put it relative to the source, judged by its path alone.
+func organized(file string) bool {
+	rel, err := filepath.Rel(c.Source, file)
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	for _, shape := range organizedShapes {
+		if shape.MatchString(rel) {
+			return true
+		}
+	}
+	return false
+}
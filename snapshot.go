@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// btrfsRootInode is the inode of the top directory of every btrfs subvolume.
+const btrfsRootInode = 256
+
+// snapshots are the snapshots this process took, by the dataset or
+// subvolume they are of. One is taken per process, so the volumes of an
+// import wizard share the one taken before the first.
+var snapshots = make(map[string]string)
+
+// snapshotDestination takes a read-only snapshot of the ZFS dataset or btrfs
+// subvolume holding dest, named after the session, and returns its name:
+// pool/photos@media_tool-20240101-120000 or, for btrfs, the path of the
+// snapshot, kept in the bookkeeping folder of the subvolume. A dataset
+// already snapshotted by this process is not snapshotted again.
+func snapshotDestination(dest string) (string, error) {
+	dir := existingParent(dest)
+	name := "media_tool-" + session
+	if dataset := zfsDataset(dir); dataset != "" {
+		if snapshot, ok := snapshots[dataset]; ok {
+			return snapshot, nil
+		}
+		snapshot := dataset + "@" + name
+		if out, err := exec.Command("zfs", "snapshot", snapshot).CombinedOutput(); err != nil {
+			return "", fmt.Errorf("error snapshotting %s: %v: %s", dataset, err, strings.TrimSpace(string(out)))
+		}
+		snapshots[dataset] = snapshot
+		return snapshot, nil
+	}
+	if subvolume := btrfsSubvolume(dir); subvolume != "" {
+		if snapshot, ok := snapshots[subvolume]; ok {
+			return snapshot, nil
+		}
+		snapshot := filepath.Join(subvolume, metaDirName, "snapshots", name)
+		if err := createParentDir(filepath.Dir(snapshot)); err != nil {
+			return "", err
+		}
+		if out, err := exec.Command("btrfs", "subvolume", "snapshot", "-r", subvolume, snapshot).CombinedOutput(); err != nil {
+			return "", fmt.Errorf("error snapshotting %s: %v: %s", subvolume, err, strings.TrimSpace(string(out)))
+		}
+		snapshots[subvolume] = snapshot
+		return snapshot, nil
+	}
+	return "", fmt.Errorf("%s is neither on a ZFS dataset nor a btrfs subvolume, or zfs or btrfs is not installed", dir)
+}
+
+// zfsDataset returns the ZFS dataset dir is on, or "".
+func zfsDataset(dir string) string {
+	if _, err := exec.LookPath("zfs"); err != nil {
+		return ""
+	}
+	out, err := exec.Command("zfs", "list", "-H", "-o", "name", dir).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// btrfsSubvolume returns the top directory of the btrfs subvolume dir is
+// in, or "".
+func btrfsSubvolume(dir string) string {
+	if _, err := exec.LookPath("btrfs"); err != nil {
+		return ""
+	}
+	for {
+		if info, err := os.Stat(dir); err == nil {
+			// other filesystems have an inode 256 too, btrfs has to agree
+			if id, ok := fileIdentity(info); ok && id.ino == btrfsRootInode &&
+				exec.Command("btrfs", "subvolume", "show", dir).Run() == nil {
+				return dir
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// snapshotBefore snapshots every root the run may write to, the
+// destination, overflow and tier roots, before anything is placed and
+// records the snapshots in the journal, to roll the library back with zfs
+// rollback or by putting the btrfs snapshots in their place.
+func snapshotBefore() error {
+	roots := make([]string, 0)
+	for _, root := range destinationRoots() {
+		if root != "" && !contains(roots, root) {
+			roots = append(roots, root)
+		}
+	}
+	if c.Dry {
+		log.Infof("would snapshot the filesystems of %s", strings.Join(roots, ", "))
+		return nil
+	}
+	taken := make(map[string]string)
+	for _, root := range roots {
+		snapshot, err := snapshotDestination(root)
+		if err != nil {
+			return err
+		}
+		taken[root] = snapshot
+	}
+	j, err := openJournal(journalPath())
+	if err != nil {
+		return fmt.Errorf("error opening journal: %w", err)
+	}
+	defer j.Close()
+	for _, root := range roots {
+		log.Infof("snapshot %s of %s taken before the run", taken[root], root)
+		j.recordSnapshot(root, taken[root])
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// sniffExtension guesses the extension of a media file from its first
+// bytes, for files some apps export without one. It returns "" when the
+// content is not a known media type.
+func sniffExtension(file string) string {
+	f, err := os.Open(file)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	head := make([]byte, 16)
+	n, _ := io.ReadFull(f, head)
+	head = head[:n]
+
+	switch {
+	case bytes.HasPrefix(head, []byte{0xFF, 0xD8, 0xFF}):
+		return "jpg"
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		return "png"
+	case bytes.HasPrefix(head, []byte("%PDF-")):
+		return "pdf"
+	case bytes.HasPrefix(head, []byte("GIF8")):
+		return "gif"
+	case bytes.HasPrefix(head, []byte("BM")) && n >= 14:
+		return "bmp"
+	case bytes.HasPrefix(head, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		return "mkv"
+	case bytes.HasPrefix(head, []byte{0x30, 0x26, 0xB2, 0x75}):
+		return "wmv"
+	case n >= 12 && bytes.HasPrefix(head, []byte("RIFF")) && bytes.Equal(head[8:12], []byte("AVI ")):
+		return "avi"
+	case n >= 12 && bytes.Equal(head[4:8], []byte("ftyp")):
+		switch string(head[8:12]) {
+		case "heic", "heix", "mif1", "msf1":
+			return "heic"
+		case "qt  ":
+			return "mov"
+		}
+		return "mp4"
+	}
+	return ""
+}
+
+// mediaExt is the extension of a file with its dot, sniffed from the content
+// when the name has none.
+func mediaExt(file string) string {
+	if ext := filepath.Ext(file); ext != "" {
+		return ext
+	}
+	if sniffed := sniffExtension(file); sniffed != "" {
+		return "." + sniffed
+	}
+	return ""
+}
+
+// formatFamilies maps extensions to the one sniffExtension returns for
+// their content.
+var formatFamilies = map[string]string{
+	"jpeg": "jpg",
+	"jpe":  "jpg",
+	"jfif": "jpg",
+	"heif": "heic",
+	"hif":  "heic",
+	"mov":  "mp4",
+	"m4v":  "mp4",
+	"3gp":  "mp4",
+	"3g2":  "mp4",
+}
+
+func formatFamily(ext string) string {
+	if family, ok := formatFamilies[ext]; ok {
+		return family
+	}
+	return ext
+}
+
+// wrongExtension returns the extension the content of file calls for when
+// it does not match the one it has, such as png for a PNG named .jpg, and
+// "" otherwise. Only extensions whose content can be sniffed are checked,
+// so the TIFF and ISO based RAW formats are left alone.
+func wrongExtension(file string) string {
+	ext := getFileExtension(file, false)
+	family := formatFamily(ext)
+	switch family {
+	case "jpg", "png", "gif", "bmp", "pdf", "mkv", "wmv", "avi", "mp4", "heic":
+	default:
+		return ""
+	}
+	sniffed := sniffExtension(file)
+	if sniffed == "" || formatFamily(sniffed) == family {
+		return ""
+	}
+	// other ISO brands such as avif are sniffed as mp4
+	if family == "heic" && sniffed == "mp4" {
+		return ""
+	}
+	return sniffed
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// yearStats sums up one year folder of the library.
+type yearStats struct {
+	Year    string         `json:"year"`
+	Files   int            `json:"files"`
+	Bytes   int64          `json:"bytes"`
+	Kinds   map[string]int `json:"kinds"`
+	Cameras map[string]int `json:"cameras"`
+	Months  map[string]int `json:"months"`
+	// Codecs counts the videos by codec and VideoSeconds sums their length.
+	Codecs       map[string]int `json:"codecs"`
+	VideoSeconds float64        `json:"video_seconds"`
+	// Days counts the days with at least one file.
+	Days    int       `json:"days"`
+	First   time.Time `json:"first"`
+	Last    time.Time `json:"last"`
+	Updated time.Time `json:"updated"`
+}
+
+// touchedYears are the year folders files went into during this run,
+// mapped to their year.
+var touchedYears = struct {
+	sync.Mutex
+	dirs map[string]string
+}{dirs: make(map[string]string)}
+
+// recordYear remembers the year folder above dest, the closest directory
+// named after the year the file was taken in.
+func recordYear(dest string, meta *mediaMeta) {
+	if meta == nil {
+		return
+	}
+	year := meta.Time.Format("2006")
+	root := absPath(rootOf(dest))
+	for dir := filepath.Dir(absPath(dest)); dir != root && dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if filepath.Base(dir) == year {
+			touchedYears.Lock()
+			touchedYears.dirs[dir] = year
+			touchedYears.Unlock()
+			return
+		}
+	}
+	log.Debugf("no year folder above %s for stats", dest)
+}
+
+func checkStats() error {
+	switch c.Stats {
+	case "", "json", "md":
+		return nil
+	}
+	return fmt.Errorf("--stats is json or md, not %q", c.Stats)
+}
+
+// writeYearStats rewrites the stats of every year folder touched by this
+// run from the index, so they cover earlier imports too.
+func writeYearStats() {
+	touchedYears.Lock()
+	defer touchedYears.Unlock()
+	for dir, year := range touchedYears.dirs {
+		stats := collectYearStats(dir, year)
+		var err error
+		if c.Stats == "md" {
+			err = os.WriteFile(filepath.Join(dir, "stats.md"), []byte(stats.markdown()), 0644)
+		} else {
+			var data []byte
+			if data, err = json.MarshalIndent(stats, "", "  "); err == nil {
+				err = os.WriteFile(filepath.Join(dir, "stats.json"), data, 0644)
+			}
+		}
+		if err != nil {
+			log.Errorf("error writing stats of %s: %v", dir, err)
+		}
+	}
+}
+
+func collectYearStats(dir, year string) yearStats {
+	stats := yearStats{
+		Year:    year,
+		Kinds:   make(map[string]int),
+		Cameras: make(map[string]int),
+		Months:  make(map[string]int),
+		Codecs:  make(map[string]int),
+		Updated: time.Now(),
+	}
+	days := make(map[string]bool)
+	prefix := dir + string(filepath.Separator)
+
+	catalog.Lock()
+	defer catalog.Unlock()
+	for key, entry := range catalog.entries {
+		file := absPath(entry.path(key))
+		if !strings.HasPrefix(file, prefix) {
+			continue
+		}
+		stats.Bytes += entry.Size
+		// a RAW, gain map or edit is part of the asset of its primary
+		if primaryOf(file) != "" {
+			continue
+		}
+		stats.Files++
+		stats.Kinds[mediaKind(file)]++
+		model := entry.Model
+		if model == "" {
+			model = "unknown"
+		}
+		stats.Cameras[model]++
+		if entry.Codec != "" {
+			stats.Codecs[entry.Codec]++
+		}
+		stats.VideoSeconds += entry.Duration
+		if entry.Taken.IsZero() {
+			continue
+		}
+		stats.Months[entry.Taken.Format("01")]++
+		days[entry.Taken.Format(time.DateOnly)] = true
+		if stats.First.IsZero() || entry.Taken.Before(stats.First) {
+			stats.First = entry.Taken
+		}
+		if entry.Taken.After(stats.Last) {
+			stats.Last = entry.Taken
+		}
+	}
+	stats.Days = len(days)
+	return stats
+}
+
+func (s yearStats) markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", s.Year)
+	fmt.Fprintf(&b, "%d files, %s, taken on %d days from %s to %s.\n\n", s.Files, formatBytes(uint64(s.Bytes)),
+		s.Days, s.First.Format(time.DateOnly), s.Last.Format(time.DateOnly))
+	if s.VideoSeconds > 0 {
+		fmt.Fprintf(&b, "%s of video.\n\n", time.Duration(s.VideoSeconds*float64(time.Second)).Round(time.Second))
+	}
+	writeCounts(&b, "Month", s.Months)
+	writeCounts(&b, "Kind", s.Kinds)
+	writeCounts(&b, "Camera", s.Cameras)
+	if len(s.Codecs) > 0 {
+		writeCounts(&b, "Codec", s.Codecs)
+	}
+	fmt.Fprintf(&b, "Updated %s.\n", s.Updated.Format(time.DateTime))
+	return b.String()
+}
+
+func writeCounts(b *strings.Builder, title string, counts map[string]int) {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	fmt.Fprintf(b, "| %s | Files |\n| --- | ---: |\n", title)
+	for _, k := range keys {
+		fmt.Fprintf(b, "| %s | %d |\n", k, counts[k])
+	}
+	b.WriteString("\n")
+}
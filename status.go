@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// runStatus counts the progress of a run for the heartbeat.
+type runStatus struct {
+	sync.Mutex
+	Current      string    `json:"current"`
+	CurrentSince time.Time `json:"current_since"`
+	Started      time.Time `json:"started"`
+	Scanned      int       `json:"scanned"`
+	Placed       int       `json:"placed"`
+	Failed       int       `json:"failed"`
+	Bytes        int64     `json:"bytes"`
+}
+
+var status = &runStatus{Started: time.Now()}
+
+func (s *runStatus) working(file string) {
+	s.Lock()
+	s.Current, s.CurrentSince = file, time.Now()
+	s.Unlock()
+}
+
+func (s *runStatus) scanned() {
+	s.Lock()
+	s.Scanned++
+	s.Unlock()
+}
+
+func (s *runStatus) placed(file string) {
+	var size int64
+	if info, err := os.Stat(file); err == nil {
+		size = info.Size()
+	}
+	s.Lock()
+	s.Placed++
+	s.Bytes += size
+	s.Unlock()
+}
+
+func (s *runStatus) failed() {
+	s.Lock()
+	s.Failed++
+	s.Unlock()
+}
+
+// startHeartbeat logs a status line, and rewrites the status file when one
+// is given, every interval until stop is called.
+func startHeartbeat(every time.Duration, path string) (stop func()) {
+	ticker := time.NewTicker(every)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				status.beat(path)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+		status.beat(path)
+	}
+}
+
+func (s *runStatus) beat(path string) {
+	s.Lock()
+	elapsed := time.Since(s.Started)
+	rate := uint64(float64(s.Bytes) / elapsed.Seconds())
+	log.Infof("heartbeat: %d scanned, %d placed, %d failed, %s at %s/s, on %s for %s",
+		s.Scanned, s.Placed, s.Failed, formatBytes(uint64(s.Bytes)), formatBytes(rate),
+		s.Current, time.Since(s.CurrentSince).Round(time.Second))
+	data, err := json.MarshalIndent(s, "", "  ")
+	s.Unlock()
+
+	if path == "" || err != nil {
+		return
+	}
+	if err = os.WriteFile(path, data, 0644); err != nil {
+		log.Errorf("error writing status file %s: %v", path, err)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	policyEarliest      = "earliest"
+	policyExifFirst     = "exif-first"
+	policyFilenameFirst = "filename-first"
+)
+
+// kinds of date strategies that --date-policy moves ahead of each other
+const (
+	kindMetadata = "metadata"
+	kindFilename = "filename"
+)
+
+// dateStrategy is one way of learning when a file was taken. Strategies are
+// tried in order and the first one that knows wins.
+type dateStrategy struct {
+	Name string
+	// Kind is kindMetadata or kindFilename, or empty for the others.
+	Kind string
+	// Enabled reports whether the flag switching the strategy on is set;
+	// nil means always. It only matters for the default order.
+	Enabled func() bool
+	// Applies reports whether the strategy can date a file at all; nil
+	// means any file.
+	Applies func(file string) bool
+	Match   func(file string) *mediaMeta
+}
+
+// dateStrategies are in their default order.
+var dateStrategies = []dateStrategy{
+	// a sidecar date wins, it is where RAW workflows keep corrected dates
+	{Name: "xmp", Kind: kindMetadata, Enabled: func() bool { return c.Xmp }, Match: matchSidecar},
+	{Name: "exif", Kind: kindMetadata, Match: readExif},
+	// exiftool reads the formats goexif cannot
+	{Name: "exiftool", Kind: kindMetadata, Enabled: func() bool { return c.Exiftool }, Match: readExiftool},
+	// editors that strip EXIF often keep IPTC or a comment
+	{Name: "iptc", Kind: kindMetadata, Applies: isJPEG, Match: readJPEGComments},
+	// scanned documents carry their date in the PDF metadata
+	{Name: "pdf", Kind: kindMetadata, Applies: isDocument, Match: matchDocument},
+	{Name: "chat", Kind: kindFilename, Match: matchChatExport},
+	{Name: "regex", Kind: kindFilename, Match: matchRegex},
+	{Name: "folder", Enabled: func() bool { return c.DirDate }, Match: matchParentDir},
+	{Name: "mtime", Match: getModifiedTime},
+}
+
+// checkStrategies fails on a strategy in the config that does not exist
+// and on an unknown --date-policy.
+func checkStrategies(names []string) error {
+	for _, name := range names {
+		if _, ok := findStrategy(dateStrategies, name); !ok {
+			return fmt.Errorf("unknown strategy %q in the config", name)
+		}
+	}
+	switch c.DatePolicy {
+	case "", policyEarliest, policyExifFirst, policyFilenameFirst:
+		return nil
+	}
+	return fmt.Errorf("--date-policy is earliest, exif-first or filename-first, not %q", c.DatePolicy)
+}
+
+func findStrategy(strategies []dateStrategy, name string) (dateStrategy, bool) {
+	for _, s := range strategies {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return dateStrategy{}, false
+}
+
+// strategyOrder returns the strategies to try: the ones listed in the
+// config, whatever the flags say, or those switched on in default order.
+// exif-first and filename-first then move one kind ahead of the other.
+func strategyOrder() []dateStrategy {
+	order := make([]dateStrategy, 0, len(dateStrategies))
+	if len(y.Strategies) > 0 {
+		for _, name := range y.Strategies {
+			if s, ok := findStrategy(dateStrategies, name); ok {
+				order = append(order, s)
+			}
+		}
+	} else {
+		for _, s := range dateStrategies {
+			if s.Enabled == nil || s.Enabled() {
+				order = append(order, s)
+			}
+		}
+	}
+
+	first := ""
+	switch c.DatePolicy {
+	case policyExifFirst:
+		first = kindMetadata
+	case policyFilenameFirst:
+		first = kindFilename
+	default:
+		return order
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return order[i].Kind == first && order[j].Kind != first
+	})
+	return order
+}
+
+// dateFile runs the strategies that apply to file until one knows its date,
+// and returns what it learned along with its name. With the earliest policy
+// every strategy runs and the earliest date wins, for apps that rewrite the
+// EXIF date with the time of the export. A winner dated by its name or
+// folder keeps the camera and location the metadata knows.
+func dateFile(file string) (*mediaMeta, string) {
+	var earliest, details *mediaMeta
+	var name string
+	for _, s := range strategyOrder() {
+		if s.Applies != nil && !s.Applies(file) {
+			continue
+		}
+		meta := s.Match(file)
+		if meta == nil || meta.Time.IsZero() {
+			continue
+		}
+		if c.DatePolicy != policyEarliest {
+			return meta, s.Name
+		}
+		if details == nil && s.Kind == kindMetadata {
+			details = meta
+		}
+		if earliest == nil || earlier(meta, earliest) {
+			earliest, name = meta, s.Name
+		}
+	}
+	if earliest != nil && details != nil && earliest != details {
+		if earliest.Model == "" {
+			earliest.Model = details.Model
+		}
+		if earliest.Serial == "" {
+			earliest.Serial, earliest.LensSerial = details.Serial, details.LensSerial
+		}
+		if earliest.GPS == nil {
+			earliest.GPS = details.GPS
+		}
+	}
+	return earliest, name
+}
+
+// earlier reports whether a is dated before b. A date without a time of day,
+// as names and folders give, is only earlier on an earlier day, and a month
+// only in an earlier month, not because midnight or the 1st come before the
+// time the photo was taken.
+func earlier(a, b *mediaMeta) bool {
+	pa, pb := datePrecision(a), datePrecision(b)
+	coarse := min(pa, pb)
+	ta, tb := truncateDate(a.Time, coarse), truncateDate(b.Time, coarse)
+	if !ta.Equal(tb) {
+		return ta.Before(tb)
+	}
+	// the same day or month, the more precise date knows more
+	return pa > pb
+}
+
+// how much of its date a file is known to have, coarse before precise
+const (
+	precisionMonth = iota
+	precisionDay
+	precisionTime
+)
+
+func datePrecision(meta *mediaMeta) int {
+	switch {
+	case meta.MonthOnly:
+		return precisionMonth
+	case dateOnly(meta.Time):
+		return precisionDay
+	}
+	return precisionTime
+}
+
+// truncateDate drops what a date of the precision cannot tell.
+func truncateDate(t time.Time, precision int) time.Time {
+	y, m, d := t.Date()
+	switch precision {
+	case precisionMonth:
+		return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+	case precisionDay:
+		return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	}
+	return t
+}
+
+func dateOnly(t time.Time) bool {
+	return t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 && t.Nanosecond() == 0
+}
+
+// matchSidecar dates a file by its XMP sidecar, taking the camera model
+// from the EXIF when the sidecar has none.
+func matchSidecar(file string) *mediaMeta {
+	sidecar := readXmpSidecar(file)
+	if sidecar == nil || sidecar.Time.IsZero() {
+		return nil
+	}
+	if sidecar.Model == "" {
+		if meta := readExif(file); meta != nil {
+			sidecar.Model = meta.Model
+		}
+	}
+	return sidecar
+}
+
+func isDocument(file string) bool {
+	return docTypes[getFileExtension(file, false)]
+}
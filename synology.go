@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// synologyThumbDir is where DSM keeps generated thumbnails and metadata,
+// next to the files they belong to.
+const synologyThumbDir = "@eaDir"
+
+type synologyConfig struct {
+	// KeepEaDir scans @eaDir folders like any other folder.
+	KeepEaDir bool `yaml:"keep_ea_dir"`
+	// RegenerateThumbnails removes the thumbnails of replaced files so DSM
+	// creates new ones.
+	RegenerateThumbnails bool   `yaml:"regenerate_thumbnails"`
+	Synoindex            string `yaml:"synoindex"`
+}
+
+func skipSynologyDir(name string) bool {
+	return c.Synology && !y.Synology.KeepEaDir && name == synologyThumbDir
+}
+
+// indexSynology refreshes the thumbnails of an imported file and adds it to
+// the DSM media index so it shows up without a manual reindex.
+func indexSynology(file string) {
+	if y.Synology.RegenerateThumbnails {
+		thumbs := filepath.Join(filepath.Dir(file), synologyThumbDir, filepath.Base(file))
+		if err := os.RemoveAll(thumbs); err != nil {
+			log.Errorf("error removing thumbnails %s: %v", thumbs, err)
+		}
+	}
+
+	output, err := exec.Command(synoindexPath(), "-a", file).CombinedOutput()
+	if err != nil {
+		log.Errorf("error indexing %s: %v %s", file, err, output)
+	}
+}
+
+func synoindexPath() string {
+	if y.Synology.Synoindex != "" {
+		return y.Synology.Synoindex
+	}
+	return "/usr/syno/bin/synoindex"
+}
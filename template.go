@@ -0,0 +1,175 @@
+package main
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// defaultTemplate reproduces the original layout; empty components such as
+// a missing {model} or {event} are dropped when rendering.
+const defaultTemplate = "{model}/{year}/{month}/{date} {event}/{name}"
+
+// buildPath renders the destination of a file, relative to its root, from
+// the template that applies to it.
+func buildPath(file string, meta *mediaMeta) string {
+	tmpl := y.Template
+	if tmpl == "" {
+		tmpl = defaultTemplate
+	}
+	if plex := plexVideoTemplate(file, meta); plex != "" {
+		tmpl = plex
+	}
+	if app, ok := y.Apps[meta.App]; ok && app.Template != "" {
+		tmpl = app.Template
+	}
+	if class, ok := y.Classes[meta.Class]; ok && class.Template != "" {
+		tmpl = class.Template
+	} else if meta.Class == classDocument {
+		tmpl = defaultDocumentTemplate
+	}
+	rule, ok := y.Cameras[meta.Model]
+	if ok && rule.Template != "" {
+		tmpl = rule.Template
+	}
+
+	vars := templateVars(file, meta)
+
+	// the file name is rendered last so renaming can see its folder
+	dirTmpl, nameTmpl := "", tmpl
+	if i := strings.LastIndex(tmpl, "/"); i >= 0 {
+		dirTmpl, nameTmpl = tmpl[:i], tmpl[i+1:]
+	}
+	dir := renderTemplate(dirTmpl, vars)
+	// counters continue in the folder of the root the file goes under
+	root := meta.Root
+	if root == "" {
+		root = c.Destination
+	}
+	if c.Rename {
+		vars["name"] = renamedBase(filepath.Join(root, dir), meta, "."+vars["ext"])
+	}
+	// the index counts on from the highest one already in the folder
+	if strings.Contains(nameTmpl, "{index}") {
+		vars["index"] = strconv.Itoa(nextCounter(filepath.Join(root, dir), plexIndexPattern, meta))
+	}
+
+	return filepath.Join(dir, renderTemplate(nameTmpl, vars))
+}
+
+func templateVars(file string, meta *mediaMeta) map[string]string {
+	date := meta.Time.Format("2006-01-02")
+	if meta.MonthOnly {
+		date = meta.Time.Format("2006-01")
+	}
+
+	fileBase := androidName(filepath.Base(file))
+	ext := filepath.Ext(fileBase)
+	// a file exported without an extension gets the one of its content
+	if ext == "" {
+		ext = mediaExt(file)
+		fileBase += ext
+	}
+	if sniffed := wrongExtension(file); sniffed != "" && c.FixExt {
+		fileBase = strings.TrimSuffix(fileBase, ext) + "." + sniffed
+		ext = "." + sniffed
+	}
+	if normalized := normalizeExt(ext); normalized != ext {
+		fileBase = strings.TrimSuffix(fileBase, ext) + normalized
+		ext = normalized
+	}
+
+	vars := map[string]string{
+		"year":        meta.Time.Format("2006"),
+		"month":       meta.Time.Format("01"),
+		"day":         meta.Time.Format("02"),
+		"date":        date,
+		"event":       meta.Event,
+		"name":        fileBase,
+		"stem":        strings.TrimSuffix(fileBase, ext),
+		"ext":         strings.TrimPrefix(ext, "."),
+		"model":       modelAlias(meta.Model),
+		"serial":      meta.Serial,
+		"lens_serial": meta.LensSerial,
+		"label":       c.Label,
+		"app":         meta.App,
+		"class":       meta.Class,
+		"lat":         "",
+		"lon":         "",
+	}
+	if meta.GPS != nil {
+		vars["lat"] = strconv.FormatFloat(meta.GPS.Lat, 'f', 4, 64)
+		vars["lon"] = strconv.FormatFloat(meta.GPS.Lon, 'f', 4, 64)
+	}
+	for k, v := range videoVars(meta) {
+		vars[k] = v
+	}
+	for k, v := range sizeVars(meta) {
+		vars[k] = v
+	}
+	for k, v := range hostVars() {
+		vars[k] = v
+	}
+	for k, v := range vars {
+		vars[k] = sanitizeValue(v)
+	}
+	return vars
+}
+
+// hostVars are the {hostname} and {user} of an import, which also work in
+// --dest, so one share can take the phones of a whole household. --user
+// names the person whose phone it is when someone else runs the import.
+func hostVars() map[string]string {
+	hostname, _ := os.Hostname()
+	// the short name, not the domain
+	hostname, _, _ = strings.Cut(hostname, ".")
+
+	name := c.User
+	if name == "" {
+		if u, err := user.Current(); err == nil {
+			name = u.Username
+		}
+		// windows names users DOMAIN\name
+		if i := strings.LastIndex(name, `\`); i >= 0 {
+			name = name[i+1:]
+		}
+	}
+	return map[string]string{"hostname": hostname, "user": name}
+}
+
+// renderTemplate replaces every {var} and cleans up the resulting path:
+// components are trimmed, the empty ones removed and the long ones cut.
+func renderTemplate(tmpl string, vars map[string]string) string {
+	rendered := expandVars(tmpl, vars)
+
+	parts := make([]string, 0)
+	for _, part := range strings.Split(rendered, "/") {
+		part = strings.TrimSpace(part)
+		if part != "" {
+			parts = append(parts, truncateComponent(part))
+		}
+	}
+	return filepath.Join(parts...)
+}
+
+// expandVars replaces every {var} of s and leaves the rest alone.
+func expandVars(s string, vars map[string]string) string {
+	pairs := make([]string, 0, len(vars)*2)
+	for k, v := range vars {
+		pairs = append(pairs, "{"+k+"}", v)
+	}
+	return strings.NewReplacer(pairs...).Replace(s)
+}
+
+// normalizeExt applies --normalize-ext to an extension.
+func normalizeExt(ext string) string {
+	switch c.NormalizeExt {
+	case "lower":
+		return strings.ToLower(ext)
+	case "upper":
+		return strings.ToUpper(ext)
+	}
+	return ext
+}
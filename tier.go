@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tierRule sends media of a kind older than a given age to another root,
+// e.g. videos older than 3y to /archive.
+type tierRule struct {
+	// Kind is video, image or document; empty matches all.
+	Kind string `yaml:"kind"`
+	// OlderThan is an age such as 3y, 18m (months) or 90d.
+	OlderThan   string `yaml:"older_than"`
+	Destination string `yaml:"destination"`
+}
+
+func mediaKind(file string) string {
+	ext := getFileExtension(mediaExt(file), false)
+	switch {
+	case videoTypes[ext]:
+		return "video"
+	case docTypes[ext]:
+		return "document"
+	}
+	return "image"
+}
+
+// checkTiers makes sure every tier rule can be applied before the scan
+// starts, so a typo does not fail a run halfway.
+func checkTiers(rules []tierRule) error {
+	for i, rule := range rules {
+		switch strings.ToLower(rule.Kind) {
+		case "", "video", "image", "document":
+		default:
+			return fmt.Errorf("tier %d: kind is video, image or document, not %q", i+1, rule.Kind)
+		}
+		if _, err := ageCutoff(rule.OlderThan); err != nil {
+			return fmt.Errorf("tier %d: %w", i+1, err)
+		}
+		if rule.Destination == "" {
+			return fmt.Errorf("tier %d has no destination", i+1)
+		}
+	}
+	return nil
+}
+
+// ageCutoff returns the time an age like 3y lies back from now.
+func ageCutoff(age string) (time.Time, error) {
+	if len(age) < 2 {
+		return time.Time{}, fmt.Errorf("invalid age %q", age)
+	}
+	n, err := strconv.Atoi(age[:len(age)-1])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid age %q", age)
+	}
+	now := time.Now()
+	switch age[len(age)-1] {
+	case 'y':
+		return now.AddDate(-n, 0, 0), nil
+	case 'm':
+		return now.AddDate(0, -n, 0), nil
+	case 'd':
+		return now.AddDate(0, 0, -n), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid age %q, use y, m or d", age)
+}
+
+// olderThan reports whether tm lies further back than an age like 3y.
+func olderThan(tm time.Time, age string) (bool, error) {
+	cutoff, err := ageCutoff(age)
+	if err != nil {
+		return false, err
+	}
+	return tm.Before(cutoff), nil
+}
+
+// isTierRoot reports whether root is the destination of a tier rule.
+func isTierRoot(root string) bool {
+	for _, rule := range y.Tiers {
+		if absPath(rule.Destination) == absPath(root) {
+			return true
+		}
+	}
+	return false
+}
+
+// tierRoot returns the root of the first tier rule a file falls under, or
+// "" when it stays in the destination.
+func tierRoot(file string, meta *mediaMeta) (string, error) {
+	if meta == nil {
+		return "", nil
+	}
+	kind := mediaKind(file)
+	for _, rule := range y.Tiers {
+		if rule.Kind != "" && !strings.EqualFold(rule.Kind, kind) {
+			continue
+		}
+		old, err := olderThan(meta.Time, rule.OlderThan)
+		if err != nil {
+			return "", err
+		}
+		if old {
+			return rule.Destination, nil
+		}
+	}
+	return "", nil
+}
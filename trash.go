@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// removeFile deletes a source that was placed, or a destination displaced
+// by --overwrite. With --use-os-trash it goes to the trash of the desktop
+// instead, from where it can be put back.
+func removeFile(path string) error {
+	if !c.UseOSTrash {
+		return os.Remove(path)
+	}
+	switch runtime.GOOS {
+	case "darwin":
+		// the Finder records where the file came from for Put Back
+		script := fmt.Sprintf(`tell application "Finder" to delete POSIX file %q`, absPath(path))
+		return runTrash(exec.Command("osascript", "-e", script))
+	case "windows":
+		quoted := "'" + strings.ReplaceAll(absPath(path), "'", "''") + "'"
+		return runTrash(exec.Command("powershell", "-NoProfile", "-Command",
+			"Add-Type -AssemblyName Microsoft.VisualBasic; "+
+				"[Microsoft.VisualBasic.FileIO.FileSystem]::DeleteFile("+quoted+", 'OnlyErrorDialogs', 'SendToRecycleBin')"))
+	}
+	return freedesktopTrash(path)
+}
+
+func runTrash(cmd *exec.Cmd) error {
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("error moving to the trash: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// freedesktopTrash moves path into the home trash of the freedesktop.org
+// spec, or into the .Trash-uid of its own volume when that is another one,
+// with the .trashinfo file managers restore it from.
+func freedesktopTrash(path string) error {
+	path = absPath(path)
+	trash := os.Getenv("XDG_DATA_HOME")
+	if trash == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		trash = filepath.Join(home, ".local", "share")
+	}
+	trash = filepath.Join(trash, "Trash")
+	err := trashInto(trash, path, path)
+	var linkErr *os.LinkError
+	if !errors.As(err, &linkErr) || !errors.Is(linkErr.Err, syscall.EXDEV) {
+		return err
+	}
+	mount, _ := mountOf(path)
+	if mount == "" {
+		return err
+	}
+	// the trash of a volume records paths relative to it
+	rel, _ := filepath.Rel(mount, path)
+	return trashInto(filepath.Join(mount, fmt.Sprintf(".Trash-%d", os.Getuid())), path, rel)
+}
+
+// trashInto moves path into the files folder of trash under a free name,
+// and records recorded as its original path.
+func trashInto(trash, path, recorded string) error {
+	files, info := filepath.Join(trash, "files"), filepath.Join(trash, "info")
+	for _, dir := range []string{files, info} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+	name := filepath.Base(path)
+	ext := filepath.Ext(name)
+	for i := 2; fileExists(filepath.Join(files, name)) || fileExists(filepath.Join(info, name+".trashinfo")); i++ {
+		name = fmt.Sprintf("%s.%d%s", strings.TrimSuffix(filepath.Base(path), ext), i, ext)
+	}
+	escaped := (&url.URL{Path: filepath.ToSlash(recorded)}).EscapedPath()
+	trashInfo := fmt.Sprintf("[Trash Info]\nPath=%s\nDeletionDate=%s\n", escaped, time.Now().Format("2006-01-02T15:04:05"))
+	infoFile := filepath.Join(info, name+".trashinfo")
+	if err := os.WriteFile(infoFile, []byte(trashInfo), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(path, filepath.Join(files, name)); err != nil {
+		os.Remove(infoFile)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// uploader feeds organized files to a photo server once they reached
+// their destination.
+type uploader interface {
+	Upload(file, album string, meta *mediaMeta) error
+	// Finish is called once after the last upload of a run.
+	Finish() error
+}
+
+type immichConfig struct {
+	URL    string `yaml:"url"`
+	APIKey string `yaml:"api_key"`
+}
+
+type photoprismConfig struct {
+	URL       string `yaml:"url"`
+	Token     string `yaml:"token"`
+	ImportDir string `yaml:"import_dir"`
+}
+
+var activeUploader uploader
+
+// uploadClient gives up on a photo server that stops answering instead of
+// hanging the import, while leaving time to upload a long video.
+var uploadClient = &http.Client{Timeout: 10 * time.Minute}
+
+func newUploader(kind string) (uploader, error) {
+	switch kind {
+	case "immich":
+		if y.Immich.URL == "" || y.Immich.APIKey == "" {
+			return nil, fmt.Errorf("immich needs url and api_key in the config")
+		}
+		return &immichUploader{albums: make(map[string]string)}, nil
+	case "photoprism":
+		if y.PhotoPrism.ImportDir == "" {
+			return nil, fmt.Errorf("photoprism needs import_dir in the config")
+		}
+		return &photoprismUploader{albums: make(map[string]bool)}, nil
+	}
+	return nil, fmt.Errorf("unknown upload target %q", kind)
+}
+
+// albumFor names the album of a file: the configured album template, or
+// the folder the file was organized into.
+func albumFor(item planItem) string {
+	if y.Album != "" && item.Meta != nil {
+		return renderTemplate(y.Album, templateVars(item.Source, item.Meta))
+	}
+	rel, err := filepath.Rel(rootOf(item.Dest), filepath.Dir(item.Dest))
+	if err != nil || rel == "." {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+type immichUploader struct {
+	albums map[string]string // name -> id
+}
+
+func (u *immichUploader) request(method, path string, body io.Reader, contentType string, out interface{}) error {
+	req, err := http.NewRequest(method, strings.TrimRight(y.Immich.URL, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("x-api-key", y.Immich.APIKey)
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := uploadClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("immich %s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(msg))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (u *immichUploader) Upload(file, album string, meta *mediaMeta) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	created := info.ModTime()
+	if meta != nil && !meta.Time.IsZero() {
+		created = meta.Time
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// the form is streamed as it is sent, a video is never held in memory
+	body, pw := io.Pipe()
+	form := multipart.NewWriter(pw)
+	fields := map[string]string{
+		"deviceAssetId":  filepath.Base(file) + "-" + fmt.Sprint(info.Size()),
+		"deviceId":       "media_tool",
+		"fileCreatedAt":  created.Format(time.RFC3339),
+		"fileModifiedAt": info.ModTime().Format(time.RFC3339),
+	}
+	go func() {
+		for k, v := range fields {
+			if err := form.WriteField(k, v); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		part, err := form.CreateFormFile("assetData", filepath.Base(file))
+		if err == nil {
+			_, err = io.Copy(part, f)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	var asset struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	}
+	err = u.request(http.MethodPost, "/api/assets", body, form.FormDataContentType(), &asset)
+	// a request that failed early leaves the writer waiting
+	body.Close()
+	if err != nil {
+		return err
+	}
+	log.Infof("uploaded %s to immich (%s)", file, asset.Status)
+
+	if album == "" {
+		return nil
+	}
+	albumID, err := u.albumID(album)
+	if err != nil {
+		return err
+	}
+	ids, _ := json.Marshal(map[string][]string{"ids": {asset.ID}})
+	return u.request(http.MethodPut, "/api/albums/"+albumID+"/assets", bytes.NewReader(ids), "application/json", nil)
+}
+
+// albumID returns the id of an album, creating it on first use.
+func (u *immichUploader) albumID(name string) (string, error) {
+	if len(u.albums) == 0 {
+		var albums []struct {
+			ID        string `json:"id"`
+			AlbumName string `json:"albumName"`
+		}
+		if err := u.request(http.MethodGet, "/api/albums", nil, "", &albums); err != nil {
+			return "", err
+		}
+		for _, a := range albums {
+			u.albums[a.AlbumName] = a.ID
+		}
+	}
+	if id, ok := u.albums[name]; ok {
+		return id, nil
+	}
+
+	var created struct {
+		ID string `json:"id"`
+	}
+	body, _ := json.Marshal(map[string]string{"albumName": name})
+	if err := u.request(http.MethodPost, "/api/albums", bytes.NewReader(body), "application/json", &created); err != nil {
+		return "", err
+	}
+	log.Infof("created immich album %s", name)
+	u.albums[name] = created.ID
+	return created.ID, nil
+}
+
+func (u *immichUploader) Finish() error {
+	return nil
+}
+
+// photoprismUploader copies files into the import folder, one sub folder
+// per album, and asks PhotoPrism to import each folder at the end. The
+// import folder is usually a share of the NAS, so copies resume where a
+// dropped connection left them; the journal of the destination keeps track.
+type photoprismUploader struct {
+	albums  map[string]bool
+	journal *journal
+	chunks  map[string]copiedChunk
+}
+
+func (u *photoprismUploader) Upload(file, album string, _ *mediaMeta) error {
+	if u.journal == nil {
+		entries, err := readJournal(journalPath())
+		if err != nil {
+			return err
+		}
+		if u.journal, err = openJournal(journalPath()); err != nil {
+			return err
+		}
+		u.chunks = copiedChunks(entries)
+	}
+	dest := filepath.Join(y.PhotoPrism.ImportDir, filepath.FromSlash(album), filepath.Base(file))
+	if _, err := createDestinationDir(dest); err != nil {
+		return err
+	}
+	if err := resumableCopy(u.journal, u.chunks, file, dest); err != nil {
+		return err
+	}
+	u.albums[album] = true
+	return nil
+}
+
+func (u *photoprismUploader) Finish() error {
+	if u.journal != nil {
+		defer u.journal.Close()
+	}
+	if y.PhotoPrism.URL == "" {
+		log.Infof("files are in %s, start the import in PhotoPrism", y.PhotoPrism.ImportDir)
+		return nil
+	}
+	for album := range u.albums {
+		options := map[string]interface{}{"move": true}
+		if album != "" {
+			options["albums"] = []string{album}
+		}
+		body, _ := json.Marshal(options)
+
+		segments := strings.Split(album, "/")
+		for i := range segments {
+			segments[i] = url.PathEscape(segments[i])
+		}
+		endpoint := strings.TrimRight(y.PhotoPrism.URL, "/") + "/api/v1/import/" + strings.Join(segments, "/")
+		req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if y.PhotoPrism.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+y.PhotoPrism.Token)
+		}
+		resp, err := uploadClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("photoprism import of %s: %s", album, resp.Status)
+		}
+		log.Infof("started photoprism import of %s", album)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// skipProfile lists the folders and files a camera vendor keeps on its
+// cards for itself: databases, thumbnails and proxies that are no photos.
+// Dirs match a folder by name pattern or by its trailing path, such as
+// M4ROOT/SUB; Files are name patterns. Both ignore case.
+type skipProfile struct {
+	Dirs  []string
+	Files []string
+}
+
+// profileNone in the config's skip_profiles turns every profile off.
+const profileNone = "none"
+
+var skipProfiles = map[string]skipProfile{
+	"sony": {
+		Dirs:  []string{"AVF_INFO", "M4ROOT/THMBNL", "M4ROOT/SUB", "M4ROOT/GENERAL", "AVCHD/BDMV/CLIPINF"},
+		Files: []string{"MEDIAPRO.XML", "CUEUP.XML", "STATUS.BIN", "AVIN0001.*"},
+	},
+	"canon": {
+		Dirs:  []string{"CANONMSC"},
+		Files: []string{"*.CTG"},
+	},
+	"nikon": {
+		Dirs:  []string{"NCFL"},
+		Files: []string{"NIKON001.DSC"},
+	},
+	"gopro": {
+		Files: []string{"*.THM", "*.LRV"},
+	},
+	"panasonic": {
+		Dirs: []string{"PANA_GRP", "PRIVATE/PANA_GRP"},
+	},
+	// what operating systems leave on cards, such as the ._IMG_1.JPG of
+	// macOS that only holds resource forks
+	"hidden": {
+		Dirs:  []string{".Trashes", ".Trash-*", ".Spotlight-V100", ".fseventsd", "System Volume Information", "$RECYCLE.BIN"},
+		Files: []string{"._*"},
+	},
+}
+
+// activeProfiles returns the profiles named in the config, or all of them
+// when it names none.
+func activeProfiles() []skipProfile {
+	names := y.SkipProfiles
+	if len(names) == 0 {
+		names = make([]string, 0, len(skipProfiles))
+		for name := range skipProfiles {
+			names = append(names, name)
+		}
+	}
+	profiles := make([]skipProfile, 0, len(names))
+	for _, name := range names {
+		if profile, ok := skipProfiles[strings.ToLower(name)]; ok {
+			profiles = append(profiles, profile)
+		}
+	}
+	return profiles
+}
+
+// checkSkipProfiles fails on a profile in the config that does not exist.
+func checkSkipProfiles(names []string) error {
+	for _, name := range names {
+		if _, ok := skipProfiles[strings.ToLower(name)]; !ok && name != profileNone {
+			return fmt.Errorf("unknown skip profile %q in the config", name)
+		}
+	}
+	return nil
+}
+
+// profileSkipsDir reports whether a vendor profile skips the folder at the
+// slash separated dir.
+func profileSkipsDir(dir string) bool {
+	dir = strings.ToUpper(dir)
+	for _, profile := range activeProfiles() {
+		for _, skip := range profile.Dirs {
+			skip = strings.ToUpper(skip)
+			if dir == skip || strings.HasSuffix(dir, "/"+skip) {
+				return true
+			}
+			if ok, _ := path.Match(skip, path.Base(dir)); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// profileSkipsFile reports whether a vendor profile skips the file name.
+func profileSkipsFile(name string) bool {
+	name = strings.ToUpper(name)
+	for _, profile := range activeProfiles() {
+		for _, pattern := range profile.Files {
+			if ok, _ := path.Match(strings.ToUpper(pattern), name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
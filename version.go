@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v2"
+)
+
+// version is set at build time with -ldflags "-X main.version=v1.2.3".
+var version = "v0.0.1"
+
+var versionCommand = &cli.Command{
+	Name:   "version",
+	Usage:  "show the version, how it was built, the tools found and the config used, for bug reports",
+	Flags:  []cli.Flag{configFlag()},
+	Action: showVersion,
+}
+
+func showVersion(_ *cli.Context) error {
+	fmt.Printf("media tool %s\n", version)
+	fmt.Printf("  %-10s %s %s/%s\n", "go", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	if info, ok := debug.ReadBuildInfo(); ok {
+		settings := make(map[string]string)
+		for _, s := range info.Settings {
+			settings[s.Key] = s.Value
+		}
+		if revision := settings["vcs.revision"]; revision != "" {
+			if settings["vcs.modified"] == "true" {
+				revision += " (modified)"
+			}
+			fmt.Printf("  %-10s %s\n", "commit", revision)
+		}
+		if built := settings["vcs.time"]; built != "" {
+			fmt.Printf("  %-10s %s\n", "committed", built)
+		}
+	}
+
+	// the config is read quietly: a broken one is what a report is about
+	configPath := c.ConfigPath
+	how := "from --config"
+	if configPath == "" {
+		configPath, how = defaultConfigPath, "default, in the working directory"
+	}
+	abs, _ := filepath.Abs(configPath)
+	fmt.Printf("config\n  %-10s %s (%s)\n", "path", abs, how)
+	if data, err := os.ReadFile(configPath); err != nil {
+		fmt.Printf("  %-10s %v\n", "status", err)
+	} else if err = unmarshalConfig(data); err != nil {
+		fmt.Printf("  %-10s %v\n", "status", err)
+	} else {
+		fmt.Printf("  %-10s ok\n", "status")
+	}
+
+	fmt.Println("tools")
+	for _, tool := range []struct{ name, path string }{
+		{"exiftool", exiftoolPath()},
+		{"ffmpeg", "ffmpeg"},
+		{"7z", sevenZipPath()},
+		{"synoindex", synoindexPath()},
+	} {
+		fmt.Printf("  %-10s %s\n", tool.name, lookTool(tool.path))
+	}
+
+	fmt.Println("uploads")
+	fmt.Printf("  %-10s %s\n", "immich", configured(y.Immich.URL))
+	fmt.Printf("  %-10s %s\n", "photoprism", configured(y.PhotoPrism.ImportDir))
+	return nil
+}
+
+// unmarshalConfig parses a config like loadConfigFile, but returns what is
+// wrong with it instead of giving up.
+func unmarshalConfig(data []byte) error {
+	if err := yaml.Unmarshal(data, &y); err != nil {
+		return err
+	}
+	if err := checkSkipProfiles(y.SkipProfiles); err != nil {
+		return err
+	}
+	return checkStrategies(y.Strategies)
+}
+
+func lookTool(name string) string {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return "not found (" + name + ")"
+	}
+	return path
+}
+
+func configured(value string) string {
+	if value == "" {
+		return "not configured"
+	}
+	return value
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// isoVideoTypes are the containers built from ISO base media boxes, which
+// are the ones checkVideo understands.
+var isoVideoTypes = map[string]bool{
+	"mp4": true,
+	"mov": true,
+	"m4v": true,
+	"3gp": true,
+}
+
+type mp4Box struct {
+	Type   string
+	Offset int64 // of the payload
+	Size   int64 // of the payload
+}
+
+// checkVideo does a quick structural check of a video: every top-level box
+// has to fit in the file and the moov box must carry a readable duration,
+// which catches truncated copies without decoding any frame.
+func checkVideo(file string) error {
+	if !isoVideoTypes[getFileExtension(file, false)] {
+		return nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	boxes, err := readBoxes(f, 0, info.Size())
+	if err != nil {
+		return err
+	}
+	moov, ok := findBox(boxes, "moov")
+	if !ok {
+		return fmt.Errorf("no moov atom")
+	}
+	duration, err := movieDuration(f, moov)
+	if err != nil {
+		return err
+	}
+	if duration <= 0 {
+		return fmt.Errorf("duration is not readable")
+	}
+	return nil
+}
+
+// readBoxes lists the boxes between start and end, failing if any of them
+// claims to extend past end.
+func readBoxes(r io.ReaderAt, start, end int64) ([]mp4Box, error) {
+	boxes := make([]mp4Box, 0)
+	header := make([]byte, 16)
+	for pos := start; pos+8 <= end; {
+		if _, err := r.ReadAt(header[:8], pos); err != nil {
+			return nil, err
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		boxType := string(header[4:8])
+		headerSize := int64(8)
+		switch size {
+		case 0:
+			size = end - pos
+		case 1:
+			if _, err := r.ReadAt(header[8:16], pos+8); err != nil {
+				return nil, err
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		if size < headerSize || pos+size > end {
+			return nil, fmt.Errorf("box %q at %d is truncated", boxType, pos)
+		}
+		boxes = append(boxes, mp4Box{Type: boxType, Offset: pos + headerSize, Size: size - headerSize})
+		pos += size
+	}
+	return boxes, nil
+}
+
+func findBox(boxes []mp4Box, boxType string) (mp4Box, bool) {
+	for _, box := range boxes {
+		if box.Type == boxType {
+			return box, true
+		}
+	}
+	return mp4Box{}, false
+}
+
+// movieDuration reads the mvhd box of a moov box and returns its duration
+// in seconds.
+func movieDuration(r io.ReaderAt, moov mp4Box) (float64, error) {
+	children, err := readBoxes(r, moov.Offset, moov.Offset+moov.Size)
+	if err != nil {
+		return 0, err
+	}
+	mvhd, ok := findBox(children, "mvhd")
+	if !ok {
+		return 0, fmt.Errorf("no mvhd atom")
+	}
+
+	buf := make([]byte, 32)
+	if mvhd.Size < int64(len(buf)) {
+		return 0, fmt.Errorf("mvhd atom is too short")
+	}
+	if _, err = r.ReadAt(buf, mvhd.Offset); err != nil {
+		return 0, err
+	}
+	var timescale, duration uint64
+	if buf[0] == 1 {
+		timescale = uint64(binary.BigEndian.Uint32(buf[20:24]))
+		duration = binary.BigEndian.Uint64(buf[24:32])
+	} else {
+		timescale = uint64(binary.BigEndian.Uint32(buf[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(buf[16:20]))
+	}
+	if timescale == 0 {
+		return 0, fmt.Errorf("timescale is zero")
+	}
+	return float64(duration) / float64(timescale), nil
+}
+
+// videoDimensions returns the width and height of the first video track
+// of an ISO media file, as stored in its tkhd box.
+func videoDimensions(file string) (int, int, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	boxes, err := readBoxes(f, 0, info.Size())
+	if err != nil {
+		return 0, 0, err
+	}
+	moov, ok := findBox(boxes, "moov")
+	if !ok {
+		return 0, 0, fmt.Errorf("no moov atom")
+	}
+	traks, err := readBoxes(f, moov.Offset, moov.Offset+moov.Size)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, trak := range traks {
+		if trak.Type != "trak" {
+			continue
+		}
+		children, err := readBoxes(f, trak.Offset, trak.Offset+trak.Size)
+		if err != nil {
+			return 0, 0, err
+		}
+		tkhd, ok := findBox(children, "tkhd")
+		if !ok {
+			continue
+		}
+		buf := make([]byte, 96)
+		n, _ := f.ReadAt(buf[:min64(int64(len(buf)), tkhd.Size)], tkhd.Offset)
+		at := 76
+		if buf[0] == 1 {
+			at = 88
+		}
+		if n < at+8 {
+			continue
+		}
+		// 16.16 fixed point; audio tracks are 0 x 0
+		width := int(binary.BigEndian.Uint32(buf[at:at+4]) >> 16)
+		height := int(binary.BigEndian.Uint32(buf[at+4:at+8]) >> 16)
+		if width > 0 && height > 0 {
+			return width, height, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("no video track")
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// videoInfo returns the duration in seconds and the codec of the first
+// video track of an ISO media file, zero and empty when not known.
+func videoInfo(file string) (float64, string) {
+	if !isoVideoTypes[getFileExtension(file, false)] {
+		return 0, ""
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, ""
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, ""
+	}
+
+	boxes, err := readBoxes(f, 0, info.Size())
+	if err != nil {
+		return 0, ""
+	}
+	moov, ok := findBox(boxes, "moov")
+	if !ok {
+		return 0, ""
+	}
+	duration, _ := movieDuration(f, moov)
+	traks, err := readBoxes(f, moov.Offset, moov.Offset+moov.Size)
+	if err != nil {
+		return duration, ""
+	}
+	for _, trak := range traks {
+		if trak.Type != "trak" {
+			continue
+		}
+		if codec := trackCodec(f, trak); codec != "" {
+			return duration, codec
+		}
+	}
+	return duration, ""
+}
+
+// videoCodecs name the sample entries of the common video codecs.
+var videoCodecs = map[string]string{
+	"avc1": "h264",
+	"avc3": "h264",
+	"hvc1": "hevc",
+	"hev1": "hevc",
+	"av01": "av1",
+	"vp09": "vp9",
+	"mp4v": "mpeg4",
+	"apch": "prores",
+	"apcn": "prores",
+	"apcs": "prores",
+	"apco": "prores",
+	"ap4h": "prores",
+}
+
+// trackCodec returns the codec of a video track, read from the first sample
+// entry of trak/mdia/minf/stbl/stsd, or empty for the other tracks.
+func trackCodec(r io.ReaderAt, trak mp4Box) string {
+	box, ok := trak, false
+	for _, name := range []string{"mdia", "minf", "stbl", "stsd"} {
+		children, err := readBoxes(r, box.Offset, box.Offset+box.Size)
+		if err != nil {
+			return ""
+		}
+		if name == "minf" {
+			// only a video track has a video media header
+			if hdlr, ok := findBox(children, "hdlr"); !ok || !isVideoHandler(r, hdlr) {
+				return ""
+			}
+		}
+		if box, ok = findBox(children, name); !ok {
+			return ""
+		}
+	}
+
+	// version and flags, entry count, then the size and type of the entry
+	buf := make([]byte, 16)
+	if box.Size < int64(len(buf)) {
+		return ""
+	}
+	if _, err := r.ReadAt(buf, box.Offset); err != nil {
+		return ""
+	}
+	fourcc := string(buf[12:16])
+	if codec, ok := videoCodecs[fourcc]; ok {
+		return codec
+	}
+	return strings.TrimSpace(fourcc)
+}
+
+// isVideoHandler reports whether an hdlr box declares a video track.
+func isVideoHandler(r io.ReaderAt, hdlr mp4Box) bool {
+	buf := make([]byte, 12)
+	if hdlr.Size < int64(len(buf)) {
+		return false
+	}
+	if _, err := r.ReadAt(buf, hdlr.Offset); err != nil {
+		return false
+	}
+	return string(buf[8:12]) == "vide"
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// volume is a mounted card or drive the wizard offers to import.
+type volume struct {
+	Path  string
+	Label string
+	Files int
+	Bytes int64
+}
+
+// importWizard finds the mounted removable volumes, previews what each
+// holds, and imports the chosen ones one after the other, each labelled
+// after its volume.
+func importWizard(ctx *cli.Context) error {
+	if c.Source != "" || c.FilesFrom != "" {
+		return fmt.Errorf("--wizard finds its sources itself, leave out --source and --files-from")
+	}
+	if err := loadConfigFile(); err != nil {
+		return err
+	}
+	volumes := removableVolumes()
+	if len(volumes) == 0 {
+		return fmt.Errorf("no removable volume is mounted")
+	}
+	for i := range volumes {
+		countMedia(&volumes[i])
+		v := volumes[i]
+		fmt.Printf("%2d) %-24s %7d files %10s  %s\n", i+1, v.Label, v.Files, formatBytes(uint64(v.Bytes)), v.Path)
+	}
+
+	chosen := chooseVolumes(volumes)
+	label := c.Label
+	for _, v := range chosen {
+		fmt.Printf("== %s (%s)\n", v.Label, v.Path)
+		c.Source, c.Label = v.Path, label
+		if c.Label == "" {
+			c.Label = v.Label
+		}
+		resetSummary()
+		if err := mediaTool(ctx); err != nil {
+			return fmt.Errorf("importing %s: %w", v.Path, err)
+		}
+	}
+	return nil
+}
+
+// removableVolumes returns the volumes mounted where desktops mount cards.
+func removableVolumes() []volume {
+	mounts := make([]string, 0)
+	if data, err := os.ReadFile("/proc/self/mountinfo"); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 5 {
+				continue
+			}
+			point := unescapeMount(fields[4])
+			for _, prefix := range removableMounts {
+				if strings.HasPrefix(point, prefix) {
+					mounts = append(mounts, point)
+					break
+				}
+			}
+		}
+	} else if entries, err := os.ReadDir("/Volumes"); err == nil {
+		for _, entry := range entries {
+			// the startup disk is a link to /
+			if entry.IsDir() {
+				mounts = append(mounts, filepath.Join("/Volumes", entry.Name()))
+			}
+		}
+	}
+	sort.Strings(mounts)
+
+	volumes := make([]volume, 0, len(mounts))
+	for _, mount := range mounts {
+		label := volumeLabel(mount)
+		if label == "" {
+			label = filepath.Base(mount)
+		}
+		volumes = append(volumes, volume{Path: mount, Label: label})
+	}
+	return volumes
+}
+
+// countMedia counts the media files of a volume and their size for the
+// preview.
+func countMedia(v *volume) {
+	_ = filepath.WalkDir(v.Path, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			log.Debugf("skip unreadable %s: %v", path, err)
+			return nil
+		}
+		if entry.IsDir() {
+			if path != v.Path && (contains(y.SkipDir, entry.Name()) || profileSkipsDir(filepath.ToSlash(path))) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !isMediaFile(path) || profileSkipsFile(entry.Name()) {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil {
+			v.Files++
+			v.Bytes += info.Size()
+		}
+		return nil
+	})
+}
+
+// chooseVolumes asks for the volumes to import by number, such as 1,3, or
+// all of them.
+func chooseVolumes(volumes []volume) []volume {
+	for {
+		fmt.Printf("import which volumes? numbers such as 1,3 or (a)ll: ")
+		response, err := stdin.ReadString('\n')
+		if err != nil {
+			log.Fatal(err)
+		}
+		response = strings.ToLower(strings.TrimSpace(response))
+		if response == "a" || response == "all" {
+			return volumes
+		}
+		chosen := make([]volume, 0)
+		for _, part := range strings.FieldsFunc(response, func(r rune) bool { return r == ',' || r == ' ' }) {
+			n, err := strconv.Atoi(part)
+			if err != nil || n < 1 || n > len(volumes) {
+				chosen = nil
+				break
+			}
+			chosen = append(chosen, volumes[n-1])
+		}
+		if len(chosen) > 0 {
+			return chosen
+		}
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package main
+
+import "fmt"
+
+func copyXattrs(_, _ string) error {
+	return fmt.Errorf("preserving xattrs is not supported on this platform")
+}
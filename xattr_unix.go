@@ -0,0 +1,47 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyXattrs copies every extended attribute (Finder tags,
+// com.apple.metadata, user.* ...) from src to dst.
+func copyXattrs(src, dst string) error {
+	size, err := unix.Listxattr(src, nil)
+	if err != nil {
+		return fmt.Errorf("error listing xattrs of %s: %w", src, err)
+	}
+	if size == 0 {
+		return nil
+	}
+	names := make([]byte, size)
+	size, err = unix.Listxattr(src, names)
+	if err != nil {
+		return fmt.Errorf("error listing xattrs of %s: %w", src, err)
+	}
+
+	for _, name := range bytes.Split(names[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		attr := string(name)
+		valueSize, err := unix.Getxattr(src, attr, nil)
+		if err != nil {
+			return fmt.Errorf("error reading xattr %s of %s: %w", attr, src, err)
+		}
+		value := make([]byte, valueSize)
+		valueSize, err = unix.Getxattr(src, attr, value)
+		if err != nil {
+			return fmt.Errorf("error reading xattr %s of %s: %w", attr, src, err)
+		}
+		if err = unix.Setxattr(dst, attr, value[:valueSize], 0); err != nil {
+			return fmt.Errorf("error writing xattr %s to %s: %w", attr, dst, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"html"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// xmpDateTags are tried in order for the capture time.
+var xmpDateTags = []string{"exif:DateTimeOriginal", "photoshop:DateCreated", "xmp:CreateDate"}
+
+var xmpDateLayouts = []string{
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+var (
+	xmpSubjectPattern = regexp.MustCompile(`(?s)<dc:subject>\s*<rdf:Bag>(.*?)</rdf:Bag>`)
+	xmpItemPattern    = regexp.MustCompile(`(?s)<rdf:li[^>]*>([^<]*)</rdf:li>`)
+)
+
+// findSidecar returns the XMP sidecar of a file, named either IMG_1.ARW.xmp
+// or IMG_1.xmp, or "" if it has none.
+func findSidecar(file string) string {
+	stem := strings.TrimSuffix(file, filepath.Ext(file))
+	for _, candidate := range []string{file + ".xmp", file + ".XMP", stem + ".xmp", stem + ".XMP"} {
+		if candidate != file && fileExists(candidate) {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// readXmpSidecar reads capture time, model, rating and keywords from the
+// sidecar of a file. The time is zero if the sidecar has none.
+func readXmpSidecar(file string) *mediaMeta {
+	sidecar := findSidecar(file)
+	if sidecar == "" {
+		return nil
+	}
+	data, err := os.ReadFile(sidecar)
+	if err != nil {
+		log.Errorf("error reading sidecar %s: %v", sidecar, err)
+		return nil
+	}
+	return parseXmp(data)
+}
+
+// xmpSegmentPrefix starts the APP1 segment holding XMP inside a JPEG.
+const xmpSegmentPrefix = "http://ns.adobe.com/xap/1.0/\x00"
+
+// readEmbeddedXmp reads the XMP packet embedded in a JPEG, if any.
+func readEmbeddedXmp(file string) *mediaMeta {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	offset, size, err := findJpegSegment(f, 0xE1, xmpSegmentPrefix)
+	if err != nil {
+		return nil
+	}
+	data := make([]byte, size)
+	if _, err = f.ReadAt(data, offset); err != nil {
+		return nil
+	}
+	return parseXmp(data)
+}
+
+func parseXmp(data []byte) *mediaMeta {
+	meta := &mediaMeta{Model: xmpProperty(data, "tiff:Model")}
+
+	for _, tag := range xmpDateTags {
+		if tm, ok := parseXmpDate(xmpProperty(data, tag)); ok {
+			meta.Time = tm
+			break
+		}
+	}
+
+	if rating, err := strconv.Atoi(xmpProperty(data, "xmp:Rating")); err == nil {
+		meta.Rating = rating
+	}
+
+	if subject := xmpSubjectPattern.FindSubmatch(data); subject != nil {
+		for _, item := range xmpItemPattern.FindAllSubmatch(subject[1], -1) {
+			keyword := strings.TrimSpace(html.UnescapeString(string(item[1])))
+			if keyword != "" {
+				meta.Keywords = append(meta.Keywords, keyword)
+			}
+		}
+	}
+	return meta
+}
+
+// mergeKeywords adds the keywords of more that keywords lacks, compared
+// case-insensitively like the keyword filter does.
+func mergeKeywords(keywords, more []string) []string {
+	for _, keyword := range more {
+		found := false
+		for _, k := range keywords {
+			if strings.EqualFold(k, keyword) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			keywords = append(keywords, keyword)
+		}
+	}
+	return keywords
+}
+
+// xmpProperty returns a simple property written either as an attribute or
+// as an element.
+func xmpProperty(data []byte, name string) string {
+	pattern := regexp.MustCompile(regexp.QuoteMeta(name) + `(?:\s*=\s*"([^"]*)"|>([^<]*)<)`)
+	matches := pattern.FindSubmatch(data)
+	if matches == nil {
+		return ""
+	}
+	value := matches[1]
+	if len(value) == 0 {
+		value = matches[2]
+	}
+	return strings.TrimSpace(html.UnescapeString(string(value)))
+}
+
+func parseXmpDate(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	for _, l := range xmpDateLayouts {
+		if tm, err := time.Parse(l, value); err == nil {
+			return tm, true
+		}
+	}
+	return time.Time{}, false
+}